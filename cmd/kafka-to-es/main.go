@@ -0,0 +1,15 @@
+// Command kafka-to-es consumes Kafka topics into Elasticsearch. Its
+// subcommands also produce demo events, replay topics into new indices,
+// validate the configuration, check connectivity and work with the dead
+// letter topics; run it without arguments for the list.
+package main
+
+import (
+	"os"
+
+	"github.com/gor0utine/kafka-to-es/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.Main(os.Args[1:], os.Stdout, os.Stderr))
+}
@@ -0,0 +1,264 @@
+// Package admin changes a running consumer without a restart: it pauses and
+// resumes topics, edits the topic->index mappings, flushes bulk indexers and
+// sets the log level. Handler exposes the operations over HTTP.
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+
+	"github.com/gor0utine/kafka-to-es/internal/app"
+	"github.com/gor0utine/kafka-to-es/internal/config"
+	"github.com/gor0utine/kafka-to-es/internal/indexer"
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+	"github.com/gor0utine/kafka-to-es/internal/mapper"
+)
+
+// ErrInvalidIndex is returned for a mapping to an index name Elasticsearch
+// would reject.
+var ErrInvalidIndex = errors.New("invalid index name")
+
+// Services lists the running consumers; *app.Supervisor implements it.
+type Services interface {
+	Services() []*app.Service
+}
+
+// Controller applies administrative changes to the consumers of one
+// process. It is safe for concurrent use.
+type Controller struct {
+	mapper   *mapper.Mapper
+	services Services
+
+	mu sync.Mutex
+	// pending holds the topics paused by PauseWhenStarted before a
+	// consumer reading them was running.
+	pending map[string]bool
+}
+
+// NewController creates a Controller for the consumers listed by services,
+// which share the mappings of m.
+func NewController(m *mapper.Mapper, services Services) *Controller {
+	return &Controller{mapper: m, services: services, pending: make(map[string]bool)}
+}
+
+// TopicStatus describes the topics of one running consumer.
+type TopicStatus struct {
+	Pipeline    string                  `json:"pipeline"`
+	Topics      []string                `json:"topics"`
+	Paused      []string                `json:"paused"`
+	Assignments []kafka.GroupAssignment `json:"assignments"`
+}
+
+// Topics returns the topics, paused topics and partition assignments of
+// every running consumer, sorted by pipeline.
+func (c *Controller) Topics() []TopicStatus {
+	var out []TopicStatus
+	for _, s := range c.sorted() {
+		out = append(out, TopicStatus{
+			Pipeline:    s.Name,
+			Topics:      s.Consumer.Topics(),
+			Paused:      s.Consumer.Paused(),
+			Assignments: s.Consumer.Assignments(),
+		})
+	}
+	return out
+}
+
+// Pause stops fetching from topic; see kafka.ConsumerManager.Pause. It
+// returns an error wrapping kafka.ErrUnknownTopic if no consumer reads the
+// topic.
+func (c *Controller) Pause(topic string) error {
+	return c.eachConsumer(topic, (*kafka.ConsumerManager).Pause)
+}
+
+// PauseWhenStarted is like Pause, but a topic no running consumer reads is
+// paused once a consumer reading it starts; it reports whether the pause was
+// deferred. Consumers start in the background, so commands received at
+// startup may arrive before them.
+func (c *Controller) PauseWhenStarted(topic string) (deferred bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err = c.eachConsumer(topic, (*kafka.ConsumerManager).Pause)
+	if errors.Is(err, kafka.ErrUnknownTopic) {
+		c.pending[topic] = true
+		return true, nil
+	}
+	return false, err
+}
+
+// Started pauses the topics of s deferred by PauseWhenStarted. Call it for
+// every consumer once it has started.
+func (c *Controller) Started(s *app.Service) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, topic := range s.Consumer.Topics() {
+		if !c.pending[topic] {
+			continue
+		}
+		if err := s.Consumer.Pause(topic); err != nil {
+			slog.Error("failed to pause topic", "pipeline", s.Name, "topic", topic, "error", err)
+			continue
+		}
+		delete(c.pending, topic)
+		slog.Info("paused topic", "pipeline", s.Name, "topic", topic)
+	}
+}
+
+// Resume restarts fetching from a paused topic and cancels a deferred
+// pause.
+func (c *Controller) Resume(topic string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deferred := c.pending[topic]
+	delete(c.pending, topic)
+	err := c.eachConsumer(topic, (*kafka.ConsumerManager).Resume)
+	if deferred && errors.Is(err, kafka.ErrUnknownTopic) {
+		return nil
+	}
+	return err
+}
+
+// eachConsumer applies fn to every consumer that reads topic.
+func (c *Controller) eachConsumer(topic string, fn func(*kafka.ConsumerManager, string) error) error {
+	found := false
+	for _, s := range c.services.Services() {
+		err := fn(s.Consumer, topic)
+		if errors.Is(err, kafka.ErrUnknownTopic) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("pipeline %s: %w", s.Name, err)
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("%w %s", kafka.ErrUnknownTopic, topic)
+	}
+	return nil
+}
+
+// Mappings returns the current topic->index mappings.
+func (c *Controller) Mappings() map[string]string {
+	return c.mapper.GetMappings()
+}
+
+// SetMappings replaces every mapping. Nothing changes if an index name is
+// invalid.
+func (c *Controller) SetMappings(mappings map[string]string) error {
+	for topic, index := range mappings {
+		if err := checkMapping(topic, index); err != nil {
+			return err
+		}
+	}
+	c.mapper.SetMappings(mappings)
+	slog.Info("mappings replaced", "mappings", mappings)
+	return nil
+}
+
+// SetMapping maps topic to index.
+func (c *Controller) SetMapping(topic, index string) error {
+	if err := checkMapping(topic, index); err != nil {
+		return err
+	}
+	c.mapper.AddMapping(topic, index)
+	slog.Info("mapping updated", "topic", topic, "index", index)
+	return nil
+}
+
+// RemoveMapping deletes the mapping of topic, which then goes to the index
+// named after it.
+func (c *Controller) RemoveMapping(topic string) {
+	c.mapper.RemoveMapping(topic)
+	slog.Info("mapping removed", "topic", topic)
+}
+
+func checkMapping(topic, index string) error {
+	if err := config.CheckIndexName(index); err != nil {
+		return fmt.Errorf("%w %q for topic %s: %v", ErrInvalidIndex, index, topic, err)
+	}
+	return nil
+}
+
+// Flush sends the queued documents of index to Elasticsearch and waits for
+// the answer. It returns the pipelines that flushed, or an error wrapping
+// indexer.ErrNoIndexer if none had documents queued for the index.
+func (c *Controller) Flush(ctx context.Context, index string) ([]string, error) {
+	var flushed []string
+	var errs []error
+	for _, s := range c.sorted() {
+		for _, p := range s.Router.Processors() {
+			err := p.FlushIndex(ctx, index)
+			switch {
+			case errors.Is(err, indexer.ErrNoIndexer):
+			case err != nil:
+				errs = append(errs, fmt.Errorf("pipeline %s: %w", p.Name, err))
+			default:
+				flushed = append(flushed, p.Name)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return flushed, errors.Join(errs...)
+	}
+	if len(flushed) == 0 {
+		return nil, fmt.Errorf("%w for %s", indexer.ErrNoIndexer, index)
+	}
+	slog.Info("flushed index", "index", index, "pipelines", flushed)
+	return flushed, nil
+}
+
+// IndexStats are the bulk statistics of one index.
+type IndexStats struct {
+	Added        uint64 `json:"added"`
+	Flushed      uint64 `json:"flushed"`
+	Failed       uint64 `json:"failed"`
+	Indexed      uint64 `json:"indexed"`
+	Created      uint64 `json:"created"`
+	Updated      uint64 `json:"updated"`
+	Deleted      uint64 `json:"deleted"`
+	Requests     uint64 `json:"requests"`
+	FlushedBytes uint64 `json:"flushed_bytes"`
+}
+
+func newIndexStats(s esutil.BulkIndexerStats) IndexStats {
+	return IndexStats{
+		Added:        s.NumAdded,
+		Flushed:      s.NumFlushed,
+		Failed:       s.NumFailed,
+		Indexed:      s.NumIndexed,
+		Created:      s.NumCreated,
+		Updated:      s.NumUpdated,
+		Deleted:      s.NumDeleted,
+		Requests:     s.NumRequests,
+		FlushedBytes: s.FlushedBytes,
+	}
+}
+
+// IndexStats returns the bulk statistics by pipeline and index. Pipelines
+// in shared bulk mode report all their indices under app.AllIndices.
+func (c *Controller) IndexStats() map[string]map[string]IndexStats {
+	out := make(map[string]map[string]IndexStats)
+	for _, s := range c.services.Services() {
+		for _, p := range s.Router.Processors() {
+			stats := make(map[string]IndexStats)
+			for index, st := range p.IndexStats() {
+				stats[index] = newIndexStats(st)
+			}
+			out[p.Name] = stats
+		}
+	}
+	return out
+}
+
+// sorted returns the running consumers sorted by name.
+func (c *Controller) sorted() []*app.Service {
+	services := c.services.Services()
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services
+}
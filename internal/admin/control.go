@@ -0,0 +1,239 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gor0utine/kafka-to-es/internal/app"
+	"github.com/gor0utine/kafka-to-es/internal/config"
+	"github.com/gor0utine/kafka-to-es/internal/indexer"
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+	"github.com/gor0utine/kafka-to-es/internal/logging"
+)
+
+// Commands accepted in Command.Command.
+const (
+	CommandPause         = "pause"
+	CommandResume        = "resume"
+	CommandSetMapping    = "set_mapping"
+	CommandRemoveMapping = "remove_mapping"
+	CommandSetLogLevel   = "set_log_level"
+	CommandFlush         = "flush"
+)
+
+// Acknowledgement statuses.
+const (
+	// StatusApplied means the command took effect on the instance.
+	StatusApplied = "applied"
+	// StatusPending means a pause waits for the topic's consumer to start.
+	StatusPending = "pending"
+	// StatusIgnored means the command does not concern the instance, such
+	// as a topic it does not read or an index it has nothing queued for.
+	StatusIgnored = "ignored"
+	// StatusFailed means the command was invalid or could not be applied.
+	StatusFailed = "failed"
+)
+
+// Command is a message of the control topic. Messages should be keyed by
+// what they change, such as "topic/orders" for pause and resume or
+// "mapping/orders", so compaction keeps the latest command per target and a
+// target's commands stay in order within a partition.
+type Command struct {
+	// ID identifies the command in acknowledgements; the partition and
+	// offset are used if it is empty.
+	ID      string `json:"id"`
+	Command string `json:"command"`
+	Topic   string `json:"topic,omitempty"`
+	Index   string `json:"index,omitempty"`
+	Level   string `json:"level,omitempty"`
+}
+
+// Ack is the acknowledgement of a command by one instance, keyed by the
+// instance and the command ID in the status topic.
+type Ack struct {
+	ID       string    `json:"id"`
+	Instance string    `json:"instance"`
+	Command  string    `json:"command"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// AckWriter publishes acknowledgements; *kafka.StatusWriter implements it.
+type AckWriter interface {
+	Send(ctx context.Context, key, value []byte) error
+}
+
+// Control applies the commands of the control topic through a Controller.
+// A command is applied once per process even if it is read again, as long as
+// it is among the last maxApplied commands; after a restart the commands retained by the topic are applied anew, which is
+// harmless since each one sets a state rather than changing it relative to
+// the current one.
+type Control struct {
+	c        *Controller
+	instance string
+	acks     AckWriter
+	// applied holds the IDs of the last maxApplied commands and order lists
+	// them oldest first; Handle calls are serialized.
+	applied map[string]bool
+	order   []string
+}
+
+// maxApplied is the number of command IDs a Control remembers to skip
+// commands it reads again.
+const maxApplied = 10000
+
+// NewControl creates a Control that acknowledges commands as instance
+// through acks, which may be nil.
+func NewControl(c *Controller, instance string, acks AckWriter) *Control {
+	return &Control{c: c, instance: instance, acks: acks, applied: make(map[string]bool)}
+}
+
+// remember records id as applied, forgetting the oldest ID beyond
+// maxApplied.
+func (ctl *Control) remember(id string) {
+	ctl.applied[id] = true
+	ctl.order = append(ctl.order, id)
+	if len(ctl.order) > maxApplied {
+		delete(ctl.applied, ctl.order[0])
+		ctl.order = ctl.order[1:]
+	}
+}
+
+// Handle applies the command in msg and acknowledges it. Calls must not
+// run concurrently; kafka.ControlConsumer serializes them.
+func (ctl *Control) Handle(ctx context.Context, msg *kafka.Message) {
+	if msg.Value == nil {
+		return // tombstone of a compacted command
+	}
+	var cmd Command
+	dec := json.NewDecoder(bytes.NewReader(msg.Value))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&cmd)
+	if cmd.ID == "" {
+		cmd.ID = fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)
+	}
+	if ctl.applied[cmd.ID] {
+		return
+	}
+	ctl.remember(cmd.ID)
+
+	status := StatusFailed
+	if err != nil {
+		err = fmt.Errorf("invalid command: %w", err)
+	} else {
+		status, err = ctl.apply(ctx, cmd)
+	}
+	logger := slog.With("id", cmd.ID, "command", cmd.Command, "status", status)
+	if err != nil {
+		logger.Warn("control command not applied", "error", err)
+	} else {
+		logger.Info("control command")
+	}
+	ctl.ack(ctx, Ack{ID: cmd.ID, Instance: ctl.instance, Command: cmd.Command, Status: status, Error: errorString(err), Time: time.Now().UTC()})
+}
+
+// apply runs cmd and returns its acknowledgement status.
+func (ctl *Control) apply(ctx context.Context, cmd Command) (string, error) {
+	switch cmd.Command {
+	case CommandPause:
+		deferred, err := ctl.c.PauseWhenStarted(cmd.Topic)
+		if deferred {
+			return StatusPending, nil
+		}
+		return result(err)
+	case CommandResume:
+		return result(ctl.c.Resume(cmd.Topic))
+	case CommandSetMapping:
+		if cmd.Topic == "" {
+			return StatusFailed, errors.New("topic is required")
+		}
+		return result(ctl.c.SetMapping(cmd.Topic, cmd.Index))
+	case CommandRemoveMapping:
+		if cmd.Topic == "" {
+			return StatusFailed, errors.New("topic is required")
+		}
+		ctl.c.RemoveMapping(cmd.Topic)
+		return StatusApplied, nil
+	case CommandSetLogLevel:
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(cmd.Level)); err != nil {
+			return StatusFailed, err
+		}
+		logging.SetLevel(level)
+		return StatusApplied, nil
+	case CommandFlush:
+		if cmd.Index == "" {
+			return StatusFailed, errors.New("index is required")
+		}
+		_, err := ctl.c.Flush(ctx, cmd.Index)
+		return result(err)
+	}
+	return StatusFailed, fmt.Errorf("unknown command %q", cmd.Command)
+}
+
+// result maps the error of an operation to a status. Operations on topics
+// and indices the instance does not handle are ignored.
+func result(err error) (string, error) {
+	switch {
+	case err == nil:
+		return StatusApplied, nil
+	case errors.Is(err, kafka.ErrUnknownTopic), errors.Is(err, indexer.ErrNoIndexer):
+		return StatusIgnored, err
+	}
+	return StatusFailed, err
+}
+
+func (ctl *Control) ack(ctx context.Context, a Ack) {
+	if ctl.acks == nil {
+		return
+	}
+	value, err := json.Marshal(a)
+	if err == nil {
+		err = ctl.acks.Send(ctx, []byte(a.Instance+"/"+a.ID), value)
+	}
+	if err != nil && ctx.Err() == nil {
+		slog.Error("failed to acknowledge control command", "id", a.ID, "error", err)
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// RunControl applies the commands of cfg's control topic through c until
+// ctx is done, acknowledging them in the status topic if one is set.
+func RunControl(ctx context.Context, cfg *config.Config, c *Controller) error {
+	consumerCfg, err := app.ConsumerConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("kafka config: %w", err)
+	}
+	instance := cfg.Control.InstanceID
+	if instance == "" {
+		if instance, err = os.Hostname(); err != nil {
+			return fmt.Errorf("instance id: %w", err)
+		}
+	}
+	var acks AckWriter
+	if cfg.Control.StatusTopic != "" {
+		w := kafka.NewStatusWriter(consumerCfg.Brokers, cfg.Control.StatusTopic, consumerCfg.TLS, consumerCfg.SASL, consumerCfg.Timeouts)
+		defer w.Close()
+		acks = w
+	}
+	ctl := NewControl(c, instance, acks)
+	slog.Info("reading control topic", "topic", cfg.Control.Topic, "instance", instance)
+	err = kafka.NewControlConsumer(consumerCfg, cfg.Control.Topic).Run(ctx, ctl.Handle)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+	"github.com/gor0utine/kafka-to-es/internal/logging"
+	"github.com/gor0utine/kafka-to-es/internal/mapper"
+)
+
+type fakeAcks struct {
+	keys []string
+	acks []Ack
+}
+
+func (f *fakeAcks) Send(_ context.Context, key, value []byte) error {
+	var a Ack
+	if err := json.Unmarshal(value, &a); err != nil {
+		return err
+	}
+	f.keys = append(f.keys, string(key))
+	f.acks = append(f.acks, a)
+	return nil
+}
+
+func TestControlAppliesCommands(t *testing.T) {
+	defer logging.SetLevel(logging.Level())
+	var list services
+	m := mapper.New(nil)
+	c := NewController(m, &list)
+	acks := &fakeAcks{}
+	ctl := NewControl(c, "node-1", acks)
+
+	offset := int64(0)
+	send := func(value string) {
+		t.Helper()
+		msg := &kafka.Message{Topic: "control", Offset: offset}
+		if value != "" {
+			msg.Value = []byte(value)
+		}
+		offset++
+		before := len(acks.acks)
+		ctl.Handle(context.Background(), msg)
+		if len(acks.acks) > before+1 {
+			t.Fatalf("%s acknowledged %d times", value, len(acks.acks)-before)
+		}
+	}
+	lastStatus := func() string {
+		if len(acks.acks) == 0 {
+			return ""
+		}
+		return acks.acks[len(acks.acks)-1].Status
+	}
+
+	// The consumer of orders starts after the pause is read.
+	send(`{"id":"p1","command":"pause","topic":"orders"}`)
+	if lastStatus() != StatusPending {
+		t.Fatalf("pause before start: status %q", lastStatus())
+	}
+	s := newTestService(t)
+	list = append(list, s)
+	c.Started(s)
+	if paused := s.Consumer.Paused(); len(paused) != 1 {
+		t.Fatalf("deferred pause not applied: %v", paused)
+	}
+
+	// Commands read again are neither applied nor acknowledged twice.
+	n := len(acks.acks)
+	send(`{"id":"r1","command":"resume","topic":"orders"}`)
+	send(`{"id":"p1","command":"pause","topic":"orders"}`)
+	if len(acks.acks) != n+1 || len(s.Consumer.Paused()) != 0 {
+		t.Fatalf("duplicate command applied: %d acks, paused %v", len(acks.acks)-n, s.Consumer.Paused())
+	}
+
+	for _, tc := range []struct {
+		value, status string
+	}{
+		{`{"id":"m1","command":"set_mapping","topic":"orders","index":"orders-v2"}`, StatusApplied},
+		{`{"id":"m2","command":"set_mapping","topic":"orders","index":"Orders"}`, StatusFailed},
+		{`{"id":"l1","command":"set_log_level","level":"debug"}`, StatusApplied},
+		{`{"id":"f1","command":"flush","index":"orders-v2"}`, StatusIgnored},
+		{`{"id":"u1","command":"resume","topic":"users"}`, StatusIgnored},
+		{`{"id":"x1","command":"restart"}`, StatusFailed},
+		{`{"command":"pause","topic":"orders","extra":1}`, StatusFailed},
+	} {
+		send(tc.value)
+		if lastStatus() != tc.status {
+			t.Errorf("%s: status %q, want %q", tc.value, lastStatus(), tc.status)
+		}
+	}
+	if m.IndexForTopic("orders") != "orders-v2" {
+		t.Error("mapping not applied")
+	}
+	if !slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		t.Error("log level not applied")
+	}
+	last := acks.acks[len(acks.acks)-1]
+	if last.ID != "control/0/9" || last.Instance != "node-1" || last.Error == "" || acks.keys[len(acks.keys)-1] != "node-1/control/0/9" {
+		t.Errorf("ack of a command without id = %+v", last)
+	}
+
+	n = len(acks.acks)
+	send("")
+	if len(acks.acks) != n {
+		t.Error("tombstone acknowledged")
+	}
+}
+
+func TestControlForgetsOldCommandIDs(t *testing.T) {
+	ctl := NewControl(NewController(mapper.New(nil), &services{}), "node-1", nil)
+	for i := 0; i < maxApplied+10; i++ {
+		ctl.remember(fmt.Sprint(i))
+	}
+	if len(ctl.applied) != maxApplied || len(ctl.order) != maxApplied {
+		t.Fatalf("remembered %d IDs (%d in order), want %d", len(ctl.applied), len(ctl.order), maxApplied)
+	}
+	if ctl.applied["9"] || !ctl.applied["10"] {
+		t.Error("oldest IDs not forgotten first")
+	}
+}
@@ -0,0 +1,184 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gor0utine/kafka-to-es/internal/indexer"
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+	"github.com/gor0utine/kafka-to-es/internal/logging"
+)
+
+// Handler returns the admin API of c. Every request must carry
+// "Authorization: Bearer <token>"; token must not be empty.
+//
+//	GET    /topics                 topics, paused topics and assignments
+//	POST   /topics/{topic}/pause   stop fetching from a topic
+//	POST   /topics/{topic}/resume  resume a paused topic
+//	GET    /mappings               topic->index mappings
+//	PUT    /mappings               replace every mapping
+//	PUT    /mappings/{topic}       map a topic, body {"index": "..."}
+//	DELETE /mappings/{topic}       remove a topic's mapping
+//	POST   /indices/{index}/flush  flush the bulk indexer of an index
+//	GET    /indices/stats          bulk statistics by pipeline and index
+//	GET    /indices/{index}/stats  bulk statistics of an index by pipeline
+//	GET    /log/level              current log level
+//	PUT    /log/level              set the log level, body {"level": "debug"}
+func Handler(c *Controller, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /topics", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.Topics())
+	})
+	mux.HandleFunc("POST /topics/{topic}/pause", func(w http.ResponseWriter, r *http.Request) {
+		topic := r.PathValue("topic")
+		if err := c.Pause(topic); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"topic": topic, "paused": true})
+	})
+	mux.HandleFunc("POST /topics/{topic}/resume", func(w http.ResponseWriter, r *http.Request) {
+		topic := r.PathValue("topic")
+		if err := c.Resume(topic); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"topic": topic, "paused": false})
+	})
+
+	mux.HandleFunc("GET /mappings", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.Mappings())
+	})
+	mux.HandleFunc("PUT /mappings", func(w http.ResponseWriter, r *http.Request) {
+		var mappings map[string]string
+		if !readJSON(w, r, &mappings) {
+			return
+		}
+		if err := c.SetMappings(mappings); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, c.Mappings())
+	})
+	mux.HandleFunc("PUT /mappings/{topic}", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Index string `json:"index"`
+		}
+		if !readJSON(w, r, &body) {
+			return
+		}
+		if err := c.SetMapping(r.PathValue("topic"), body.Index); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, c.Mappings())
+	})
+	mux.HandleFunc("DELETE /mappings/{topic}", func(w http.ResponseWriter, r *http.Request) {
+		c.RemoveMapping(r.PathValue("topic"))
+		writeJSON(w, http.StatusOK, c.Mappings())
+	})
+
+	mux.HandleFunc("POST /indices/{index}/flush", func(w http.ResponseWriter, r *http.Request) {
+		index := r.PathValue("index")
+		pipelines, err := c.Flush(r.Context(), index)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"index": index, "pipelines": pipelines})
+	})
+	mux.HandleFunc("GET /indices/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.IndexStats())
+	})
+	mux.HandleFunc("GET /indices/{index}/stats", func(w http.ResponseWriter, r *http.Request) {
+		index := r.PathValue("index")
+		out := make(map[string]IndexStats)
+		for pipeline, stats := range c.IndexStats() {
+			if st, ok := stats[index]; ok {
+				out[pipeline] = st
+			}
+		}
+		if len(out) == 0 {
+			writeJSON(w, http.StatusNotFound, errorBody{"no statistics for index " + index})
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+	})
+
+	mux.HandleFunc("GET /log/level", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, logLevelBody{logging.Level().String()})
+	})
+	mux.HandleFunc("PUT /log/level", func(w http.ResponseWriter, r *http.Request) {
+		var body logLevelBody
+		if !readJSON(w, r, &body) {
+			return
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(body.Level)); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorBody{err.Error()})
+			return
+		}
+		logging.SetLevel(level)
+		writeJSON(w, http.StatusOK, logLevelBody{logging.Level().String()})
+	})
+	return authenticate(token, mux)
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+type logLevelBody struct {
+	Level string `json:"level"`
+}
+
+// authenticate rejects requests without the bearer token and logs the
+// requests that change something.
+func authenticate(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if token == "" || subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kafka-to-es"`)
+			writeJSON(w, http.StatusUnauthorized, errorBody{"missing or invalid token"})
+			return
+		}
+		if r.Method != http.MethodGet {
+			slog.Info("admin request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readJSON decodes the request body into v, or answers 400 and returns
+// false.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorBody{"invalid body: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+// writeError answers with the status matching err.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, kafka.ErrUnknownTopic), errors.Is(err, indexer.ErrNoIndexer):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrInvalidIndex):
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, errorBody{err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
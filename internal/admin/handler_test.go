@@ -0,0 +1,209 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+
+	"github.com/gor0utine/kafka-to-es/internal/app"
+	"github.com/gor0utine/kafka-to-es/internal/config"
+	"github.com/gor0utine/kafka-to-es/internal/indexer"
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+	"github.com/gor0utine/kafka-to-es/internal/logging"
+	"github.com/gor0utine/kafka-to-es/internal/mapper"
+)
+
+type fakeCheckpoints struct{}
+
+func (fakeCheckpoints) CommitOffsets(map[string]map[int]int64) error { return nil }
+
+func (fakeCheckpoints) Offset(context.Context, string, int) (int64, error) { return -1, nil }
+
+type services []*app.Service
+
+func (s services) Services() []*app.Service { return s }
+
+// newTestService builds a consumer of topic orders that is never started
+// and whose bulk requests all succeed.
+func newTestService(t *testing.T) *app.Service {
+	t.Helper()
+	es := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"took":1,"errors":false,"items":[{"index":{"_id":"1","status":201,"result":"created"}}]}`)
+	}))
+	t.Cleanup(es.Close)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	body := "kafka:\n  brokers: [localhost:9092]\n  group_id: g\n  topics: [orders]\nes:\n  addresses: [" + es.URL + "]\n"
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{es.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, err := app.NewRouter(cfg, client, mapper.New(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := kafka.NewConsumerManager(kafka.ConsumerConfig{
+		Brokers:          cfg.Kafka.Brokers,
+		StaticPartitions: map[string][]int{"orders": {0}},
+		Checkpoints:      fakeCheckpoints{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = router.Close(context.Background())
+		_ = consumer.Close()
+	})
+	return &app.Service{Name: cfg.Name(), Config: cfg, Router: router, Consumer: consumer}
+}
+
+func do(t *testing.T, h http.Handler, method, target, body string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code, strings.TrimSpace(rec.Body.String())
+}
+
+func TestHandlerRequiresToken(t *testing.T) {
+	h := Handler(NewController(mapper.New(nil), services{}), "secret")
+	for _, auth := range []string{"", "Bearer wrong", "secret"} {
+		req := httptest.NewRequest(http.MethodGet, "/mappings", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Authorization %q: status %d", auth, rec.Code)
+		}
+	}
+	if code, _ := do(t, Handler(NewController(mapper.New(nil), services{}), ""), http.MethodGet, "/mappings", ""); code != http.StatusUnauthorized {
+		t.Errorf("empty token accepted requests: status %d", code)
+	}
+}
+
+func TestHandlerMappings(t *testing.T) {
+	m := mapper.New(map[string]string{"orders": "orders-v1"})
+	h := Handler(NewController(m, services{}), "secret")
+
+	if code, body := do(t, h, http.MethodPut, "/mappings/users", `{"index":"users-v2"}`); code != http.StatusOK || body != `{"orders":"orders-v1","users":"users-v2"}` {
+		t.Errorf("PUT /mappings/users = %d %s", code, body)
+	}
+	if m.IndexForTopic("users") != "users-v2" {
+		t.Error("mapping not applied")
+	}
+	if code, _ := do(t, h, http.MethodPut, "/mappings/users", `{"index":"Users"}`); code != http.StatusBadRequest {
+		t.Errorf("invalid index name: status %d", code)
+	}
+	if code, _ := do(t, h, http.MethodPut, "/mappings", `{"a":"ok","b":"NOT-OK"}`); code != http.StatusBadRequest || m.IndexForTopic("a") != "a" {
+		t.Errorf("invalid mapping set: status %d, partially applied %q", code, m.IndexForTopic("a"))
+	}
+	if code, _ := do(t, h, http.MethodPut, "/mappings/users", `{"idx":"x"}`); code != http.StatusBadRequest {
+		t.Errorf("unknown field: status %d", code)
+	}
+	if code, body := do(t, h, http.MethodDelete, "/mappings/orders", ""); code != http.StatusOK || body != `{"users":"users-v2"}` {
+		t.Errorf("DELETE /mappings/orders = %d %s", code, body)
+	}
+	if code, body := do(t, h, http.MethodPut, "/mappings", `{"logs":"logs-v1"}`); code != http.StatusOK || body != `{"logs":"logs-v1"}` {
+		t.Errorf("PUT /mappings = %d %s", code, body)
+	}
+}
+
+func TestHandlerTopics(t *testing.T) {
+	s := newTestService(t)
+	h := Handler(NewController(mapper.New(nil), services{s}), "secret")
+
+	if code, _ := do(t, h, http.MethodPost, "/topics/missing/pause", ""); code != http.StatusNotFound {
+		t.Errorf("pause unknown topic: status %d", code)
+	}
+	if code, _ := do(t, h, http.MethodPost, "/topics/orders/pause", ""); code != http.StatusOK {
+		t.Fatalf("pause: status %d", code)
+	}
+	code, body := do(t, h, http.MethodGet, "/topics", "")
+	var topics []TopicStatus
+	if err := json.Unmarshal([]byte(body), &topics); code != http.StatusOK || err != nil {
+		t.Fatalf("GET /topics = %d %s", code, body)
+	}
+	if len(topics) != 1 || topics[0].Pipeline != config.DefaultPipelineName || len(topics[0].Paused) != 1 || len(topics[0].Assignments) != 1 {
+		t.Errorf("GET /topics = %+v", topics)
+	}
+	if code, _ := do(t, h, http.MethodPost, "/topics/orders/resume", ""); code != http.StatusOK || len(s.Consumer.Paused()) != 0 {
+		t.Errorf("resume: status %d, paused %v", code, s.Consumer.Paused())
+	}
+}
+
+func TestHandlerFlushAndStats(t *testing.T) {
+	s := newTestService(t)
+	h := Handler(NewController(mapper.New(nil), services{s}), "secret")
+
+	if code, _ := do(t, h, http.MethodPost, "/indices/orders/flush", ""); code != http.StatusNotFound {
+		t.Errorf("flush without indexer: status %d", code)
+	}
+	done := make(chan error, 1)
+	item := indexer.Item{Index: "orders", ID: "1", Body: []byte(`{}`), OnDone: func(err error) { done <- err }}
+	if err := s.Router.Default().Bulker.Add(context.Background(), item); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := do(t, h, http.MethodPost, "/indices/orders/flush", ""); code != http.StatusOK {
+		t.Fatalf("flush: %d %s", code, body)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("item failed: %v", err)
+		}
+	default:
+		t.Error("flush returned before the item was indexed")
+	}
+
+	code, body := do(t, h, http.MethodGet, "/indices/orders/stats", "")
+	var stats map[string]IndexStats
+	if err := json.Unmarshal([]byte(body), &stats); code != http.StatusOK || err != nil {
+		t.Fatalf("GET /indices/orders/stats = %d %s", code, body)
+	}
+	if st := stats[config.DefaultPipelineName]; st.Added != 1 || st.Flushed != 1 || st.Requests != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if code, _ := do(t, h, http.MethodGet, "/indices/users/stats", ""); code != http.StatusNotFound {
+		t.Errorf("stats of unknown index: status %d", code)
+	}
+}
+
+func TestHandlerLogLevel(t *testing.T) {
+	defer logging.SetLevel(logging.Level())
+	h := Handler(NewController(mapper.New(nil), services{}), "secret")
+
+	if code, body := do(t, h, http.MethodPut, "/log/level", `{"level":"debug"}`); code != http.StatusOK || body != `{"level":"DEBUG"}` {
+		t.Errorf("PUT /log/level = %d %s", code, body)
+	}
+	if !slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		t.Error("debug logs still disabled")
+	}
+	if code, _ := do(t, h, http.MethodPut, "/log/level", `{"level":"verbose"}`); code != http.StatusBadRequest {
+		t.Errorf("invalid level: status %d", code)
+	}
+	if code, body := do(t, h, http.MethodGet, "/log/level", ""); code != http.StatusOK || body != `{"level":"DEBUG"}` {
+		t.Errorf("GET /log/level = %d %s", code, body)
+	}
+}
@@ -0,0 +1,355 @@
+// Package app wires the configuration into the consumer, indexer and worker
+// pool shared by the commands.
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/segmentio/kafka-go/sasl"
+
+	"github.com/gor0utine/kafka-to-es/internal/checkpoint"
+	"github.com/gor0utine/kafka-to-es/internal/config"
+	"github.com/gor0utine/kafka-to-es/internal/flow"
+	"github.com/gor0utine/kafka-to-es/internal/indexer"
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+	"github.com/gor0utine/kafka-to-es/internal/metrics"
+	"github.com/gor0utine/kafka-to-es/internal/worker"
+)
+
+// ConsumerConfig builds the Kafka consumer settings, including security and
+// flow control.
+// Topics from pipeline patterns are not included; see ResolveTopics.
+func ConsumerConfig(cfg *config.Config) (kafka.ConsumerConfig, error) {
+	kafkaTLS, kafkaSASL, err := kafkaSecurity(cfg)
+	if err != nil {
+		return kafka.ConsumerConfig{}, err
+	}
+
+	tuning, err := readerTuning(cfg.Kafka.ReaderTuning)
+	if err != nil {
+		return kafka.ConsumerConfig{}, err
+	}
+	reg := registry(cfg, cfg.Name())
+	consumerCfg := kafka.ConsumerConfig{
+		Brokers:      cfg.Kafka.Brokers,
+		GroupID:      cfg.Kafka.GroupID,
+		Topics:       cfg.SubscribedTopics(),
+		ReaderTuning: tuning,
+		TopicTuning:  make(map[string]kafka.ReaderTuning),
+		SingleGroup:  cfg.Kafka.SingleGroup,
+
+		StaticPartitions: cfg.Kafka.StaticPartitions,
+		Flow: flow.New(flow.Config{
+			MaxBytes:     int64(cfg.Flow.MaxInflightBytes),
+			MaxMessages:  cfg.Flow.MaxInflightMessages,
+			LowWatermark: cfg.Flow.LowWatermark,
+			Metrics:      reg,
+		}),
+		CommitThreshold: cfg.Kafka.CommitThreshold,
+		RetryInterval:   cfg.Kafka.RetryInterval,
+		TLS:             kafkaTLS,
+		SASL:            kafkaSASL,
+		Timeouts:        kafkaTimeouts(cfg),
+		Metrics:         reg,
+	}
+	for topic := range cfg.Kafka.TopicOverrides {
+		t, err := readerTuning(cfg.Kafka.TuningFor(topic))
+		if err != nil {
+			return kafka.ConsumerConfig{}, fmt.Errorf("topic %s: %w", topic, err)
+		}
+		consumerCfg.TopicTuning[topic] = t
+	}
+	return consumerCfg, nil
+}
+
+// registry returns where the named pipeline of the consumer described by cfg
+// reports its metrics. The shared consumer and its default pipeline keep
+// the top-level registry; every other pipeline has its own.
+func registry(cfg *config.Config, pipeline string) *metrics.Registry {
+	if pipeline == config.DefaultPipelineName {
+		pipeline = cfg.Name()
+	}
+	if pipeline == config.DefaultPipelineName {
+		return metrics.Default()
+	}
+	return metrics.Pipeline(pipeline)
+}
+
+// kafkaSecurity builds the TLS and SASL settings for Kafka connections.
+func kafkaSecurity(cfg *config.Config) (*tls.Config, sasl.Mechanism, error) {
+	kafkaTLS, err := cfg.Kafka.TLS.Build()
+	if err != nil {
+		return nil, nil, fmt.Errorf("tls: %w", err)
+	}
+	kafkaSASL, err := kafka.NewSASLMechanism(kafka.SASLOptions{
+		Mechanism:    cfg.Kafka.SASL.Mechanism,
+		Username:     cfg.Kafka.SASL.Username,
+		Password:     cfg.Kafka.SASL.Password.Value,
+		TokenFile:    cfg.Kafka.SASL.TokenFile,
+		TokenRefresh: cfg.Kafka.SASL.TokenRefresh,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("sasl: %w", err)
+	}
+	return kafkaTLS, kafkaSASL, nil
+}
+
+// kafkaTimeouts converts the broker timeouts from the config file.
+func kafkaTimeouts(cfg *config.Config) kafka.Timeouts {
+	return kafka.Timeouts{
+		Dial:    cfg.Kafka.DialTimeout,
+		Request: cfg.Kafka.RequestTimeout,
+		Write:   cfg.Kafka.WriteTimeout,
+	}
+}
+
+// ResolveTopics adds the topics on the cluster that match the patterns of
+// the configured pipelines to kc.Topics.
+func ResolveTopics(ctx context.Context, cfg *config.Config, kc *kafka.ConsumerConfig) error {
+	patterns := cfg.TopicPatterns()
+	if len(patterns) == 0 {
+		return nil
+	}
+	matched, err := kafka.ListTopics(ctx, kc.Brokers, kc.TLS, kc.SASL, kc.Timeouts, patterns)
+	if err != nil {
+		return fmt.Errorf("resolve topic patterns: %w", err)
+	}
+	seen := make(map[string]bool, len(kc.Topics))
+	for _, t := range kc.Topics {
+		seen[t] = true
+	}
+	for _, t := range matched {
+		if !seen[t] {
+			kc.Topics = append(kc.Topics, t)
+		}
+	}
+	if len(kc.Topics) == 0 {
+		return fmt.Errorf("no topics match %v", patterns)
+	}
+	return nil
+}
+
+// CheckpointStore builds the offset store for static partitions and for
+// exactly-once mode, or returns nil if neither is configured. In exactly-once
+// mode checkpoints are written through ix.
+func CheckpointStore(cfg *config.Config, es *elasticsearch.Client, ix indexer.Indexer) (kafka.CheckpointStore, error) {
+	cp := cfg.Kafka.Checkpoint
+	if len(cfg.Kafka.StaticPartitions) == 0 && !cp.ExactlyOnce {
+		return nil, nil
+	}
+	switch cp.Store {
+	case config.CheckpointFile:
+		return checkpoint.NewFile(cp.Path)
+	case config.CheckpointElasticsearch:
+		if cp.Name == "" {
+			return nil, fmt.Errorf("checkpoint name or group_id is required for the elasticsearch store")
+		}
+		var opts []checkpoint.Option
+		if cp.ExactlyOnce {
+			opts = append(opts, checkpoint.WithIndexer(ix))
+		}
+		return checkpoint.NewElasticsearch(es, cp.Index, cp.Name, opts...), nil
+	default:
+		return nil, fmt.Errorf("unknown checkpoint store %q", cp.Store)
+	}
+}
+
+// Processor is the indexing side of one pipeline: the worker pool, its input
+// and the bulk indexer it writes to.
+type Processor struct {
+	Name   string
+	Bulker indexer.Indexer
+	Pool   *worker.Pool
+	// Sink is where consumers deliver messages for the pool.
+	Sink  kafka.Sink
+	inCh  chan *kafka.Message
+	sched *worker.Scheduler
+	dlq   *kafka.DeadLetterWriter
+}
+
+// newProcessor builds the bulk indexer and worker pool of pipeline pl.
+// Pipelines without an index template write to the indices chosen by m.
+func newProcessor(cfg *config.Config, es *elasticsearch.Client, m worker.Mapper, pl config.PipelineConfig) (*Processor, error) {
+	dispatch := worker.Dispatch(cfg.Worker.Dispatch)
+	switch dispatch {
+	case worker.DispatchShared, worker.DispatchPartition, worker.DispatchKey:
+	default:
+		return nil, fmt.Errorf("unknown dispatch %q", cfg.Worker.Dispatch)
+	}
+	poolOpts, err := outputOptions(pl)
+	if err != nil {
+		return nil, err
+	}
+	// Ordered dispatch is only useful if the bulk layer keeps the order.
+	ordered := dispatch != worker.DispatchShared
+
+	p := &Processor{Name: pl.Name, inCh: make(chan *kafka.Message, 10000)}
+	reg := registry(cfg, pl.Name)
+	switch cfg.Worker.BulkMode {
+	case config.BulkModeShared:
+		p.Bulker = indexer.NewPipeline(es, indexer.PipelineConfig{
+			Concurrency:      cfg.Worker.BulkConcurrency,
+			FlushBytes:       int(pl.BatchBytes),
+			FlushInterval:    pl.FlushInterval,
+			MaxBufferedBytes: int(cfg.Worker.MaxBufferedBytes),
+			Ordered:          ordered,
+		})
+	case config.BulkModePerIndex:
+		opts := []indexer.Option{indexer.WithIdleTTL(cfg.Worker.IndexerIdleTTL), indexer.WithMetrics(reg)}
+		if ordered {
+			opts = append(opts, indexer.WithOrdered())
+		}
+		p.Bulker = indexer.NewBulker(
+			es,
+			pl.NumWorkers,
+			int(pl.BatchBytes),
+			pl.FlushInterval,
+			opts...,
+		)
+	default:
+		return nil, fmt.Errorf("unknown bulk_mode %q", cfg.Worker.BulkMode)
+	}
+
+	poolOpts = append(poolOpts, worker.WithDispatch(dispatch))
+	if pl.DLQ.Topic != "" {
+		tlsCfg, mechanism, err := kafkaSecurity(cfg)
+		if err != nil {
+			return nil, err
+		}
+		p.dlq = kafka.NewDeadLetterWriter(cfg.Kafka.Brokers, pl.DLQ.Topic, tlsCfg, mechanism, kafkaTimeouts(cfg), reg)
+		poolOpts = append(poolOpts, worker.WithDeadLetter(p.dlq))
+	}
+	p.Sink = kafka.ChanSink(p.inCh)
+	if cfg.Worker.Scheduling.Enabled {
+		policies := make(map[string]worker.TopicPolicy, len(cfg.Worker.Scheduling.Topics))
+		for topic, s := range cfg.Worker.Scheduling.Topics {
+			policies[topic] = topicPolicy(s)
+		}
+		p.sched = worker.NewScheduler(topicPolicy(cfg.Worker.Scheduling.Default), policies, worker.WithQueueMetrics(reg))
+		p.Sink = p.sched
+		poolOpts = append(poolOpts, worker.WithScheduler(p.sched))
+	}
+	p.Pool = worker.NewWorkerPool(p.Bulker, m, p.inCh, pl.NumWorkers, poolOpts...)
+	return p, nil
+}
+
+// outputOptions returns the worker options that build the documents of
+// pipeline pl. They are the part of a pipeline that Reconfigure can change
+// while it runs.
+func outputOptions(pl config.PipelineConfig) ([]worker.Option, error) {
+	switch pl.DocumentID {
+	case worker.IDRandom, worker.IDKey, worker.IDOffset:
+	default:
+		return nil, fmt.Errorf("unknown document_id %q", pl.DocumentID)
+	}
+	transforms := make([]worker.Transform, len(pl.Transforms))
+	for i, t := range pl.Transforms {
+		transforms[i] = worker.Transform{Op: t.Op, Field: t.Field, To: t.To, Value: t.Value}
+		if err := transforms[i].Validate(); err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", pl.Name, err)
+		}
+	}
+	return []worker.Option{
+		worker.WithIDStrategy(pl.DocumentID),
+		worker.WithDecoder(pl.Decoder),
+		worker.WithAction(pl.Action),
+		worker.WithTransforms(transforms...),
+		worker.WithIndexTemplate(pl.Index),
+	}, nil
+}
+
+// Close flushes the bulk indexer, then closes the dead letter writer once
+// the documents that failed in the final flush have reached it.
+func (p *Processor) Close(ctx context.Context) error {
+	err := p.Bulker.Close(ctx)
+	if p.dlq != nil {
+		p.Pool.WaitDeadLetters()
+		if cerr := p.dlq.Close(); cerr != nil {
+			err = errors.Join(err, fmt.Errorf("close dead letter writer: %w", cerr))
+		}
+	}
+	return err
+}
+
+// AllIndices is the key under which IndexStats reports the statistics of a
+// shared bulk stream, which does not keep them by index.
+const AllIndices = "_all"
+
+// FlushIndex sends the queued documents of index to Elasticsearch and waits
+// for the answer. In shared bulk mode every index goes through one stream,
+// which is flushed as a whole. It returns an error wrapping
+// indexer.ErrNoIndexer if nothing is queued for index.
+func (p *Processor) FlushIndex(ctx context.Context, index string) error {
+	switch b := p.Bulker.(type) {
+	case *indexer.Bulker:
+		return b.FlushIndex(ctx, index)
+	case *indexer.Pipeline:
+		return b.Flush(ctx)
+	}
+	return errors.ErrUnsupported
+}
+
+// IndexStats returns the bulk statistics by index; see AllIndices.
+func (p *Processor) IndexStats() map[string]esutil.BulkIndexerStats {
+	switch b := p.Bulker.(type) {
+	case *indexer.Bulker:
+		return b.IndexStats()
+	case *indexer.Pipeline:
+		return map[string]esutil.BulkIndexerStats{AllIndices: b.Stats()}
+	}
+	return nil
+}
+
+// CloseInput stops accepting messages; workers exit once the input is drained.
+func (p *Processor) CloseInput() {
+	if p.sched != nil {
+		p.sched.Close()
+	}
+	close(p.inCh)
+}
+
+// readerTuning converts reader settings from the config file.
+func readerTuning(t config.ReaderTuning) (kafka.ReaderTuning, error) {
+	startOffset, err := kafka.ParseStartOffset(t.StartOffset)
+	if err != nil {
+		return kafka.ReaderTuning{}, err
+	}
+	isolation, err := kafka.ParseIsolationLevel(t.IsolationLevel)
+	if err != nil {
+		return kafka.ReaderTuning{}, err
+	}
+	balancers, err := kafka.ParseGroupBalancers(t.PartitionAssignmentStrategy)
+	if err != nil {
+		return kafka.ReaderTuning{}, err
+	}
+	commitSync := t.CommitSync != nil && *t.CommitSync
+	return kafka.ReaderTuning{
+		MinBytes:          int(t.MinBytes),
+		MaxBytes:          int(t.MaxBytes),
+		MaxWait:           t.MaxWait,
+		QueueCapacity:     t.QueueCapacity,
+		StartOffset:       startOffset,
+		CommitSync:        commitSync,
+		CommitInterval:    t.CommitInterval,
+		SessionTimeout:    t.SessionTimeout,
+		HeartbeatInterval: t.HeartbeatInterval,
+		RebalanceTimeout:  t.RebalanceTimeout,
+		IsolationLevel:    isolation,
+		GroupBalancers:    balancers,
+	}, nil
+}
+
+// topicPolicy converts a topic schedule from the config file.
+func topicPolicy(s config.TopicSchedule) worker.TopicPolicy {
+	return worker.TopicPolicy{
+		Priority:       s.Priority,
+		Weight:         s.Weight,
+		MaxConcurrency: s.MaxConcurrency,
+		QueueSize:      s.QueueSize,
+	}
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gor0utine/kafka-to-es/internal/config"
+	"github.com/gor0utine/kafka-to-es/internal/mapper"
+	"github.com/gor0utine/kafka-to-es/internal/worker"
+)
+
+// Reloader applies configuration changes to a running consumer: topic
+// mappings, per-topic scheduling and how each pipeline builds its documents
+// (transforms, index template, decoder, action and document_id) are swapped
+// in place; every other change, including the topics a pipeline claims, is
+// logged as needing a restart. Mappings changed at runtime through the admin
+// API or the control topic stay on top of the reloaded ones. A configuration that fails to load or
+// validate is rejected and the running one is kept.
+type Reloader struct {
+	path   string
+	mapper *mapper.Mapper
+
+	mu      sync.Mutex
+	routers []*Router
+	current *config.Config
+	sum     [sha256.Size]byte
+}
+
+// NewReloader creates a Reloader for the config file at path, which cfg was
+// loaded from, that applies changes to routers.
+func NewReloader(path string, cfg *config.Config, m *mapper.Mapper, routers ...*Router) *Reloader {
+	r := &Reloader{path: path, mapper: m, routers: routers, current: cfg}
+	if b, err := os.ReadFile(path); err == nil {
+		r.sum = sha256.Sum256(b)
+	}
+	return r
+}
+
+// Add applies later changes to router as well, such as the router of a
+// consumer that started after the Reloader was created.
+func (r *Reloader) Add(router *Router) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routers = append(r.routers, router)
+}
+
+// Current returns the configuration in effect.
+func (r *Reloader) Current() *config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Reload loads and validates the config file and applies it.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Remember the content even if it is rejected, so Watch only retries
+	// once the file changes again.
+	if b, err := os.ReadFile(r.path); err == nil {
+		r.sum = sha256.Sum256(b)
+	}
+	next, err := config.Load(r.path)
+	if err == nil {
+		err = next.Validate()
+	}
+	if err != nil {
+		return fmt.Errorf("rejected config reload: %w", err)
+	}
+
+	if sections := r.current.RestartRequired(next); len(sections) > 0 {
+		slog.Warn("config changes need a restart to take effect", "sections", sections)
+	}
+	r.mapper.SetBaseMappings(next.Mappings)
+	if topics := r.mapper.Overridden(); len(topics) > 0 {
+		slog.Info("runtime mapping changes kept over the config file", "topics", topics)
+	}
+	policies := make(map[string]worker.TopicPolicy, len(next.Worker.Scheduling.Topics))
+	for topic, s := range next.Worker.Scheduling.Topics {
+		policies[topic] = topicPolicy(s)
+	}
+	for _, router := range r.routers {
+		router.SetPolicies(topicPolicy(next.Worker.Scheduling.Default), policies)
+		if err := router.Reconfigure(next); err != nil {
+			slog.Error("pipeline settings not reloaded", "error", err)
+		}
+	}
+	r.current = next
+	slog.Info("config reloaded", "path", r.path, "mappings", r.mapper.GetMappings())
+	return nil
+}
+
+// Watch polls the config file every interval and reloads it when its
+// content changes, until ctx is done.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		b, err := os.ReadFile(r.path)
+		if err != nil {
+			slog.Warn("cannot read config file", "path", r.path, "error", err)
+			continue
+		}
+		sum := sha256.Sum256(b)
+		r.mu.Lock()
+		changed := sum != r.sum
+		r.mu.Unlock()
+		if !changed {
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			slog.Error("config reload failed", "error", err)
+		}
+	}
+}
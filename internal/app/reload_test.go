@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+
+	"github.com/gor0utine/kafka-to-es/internal/config"
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+	"github.com/gor0utine/kafka-to-es/internal/mapper"
+)
+
+const reloadBase = `
+kafka:
+  brokers: ["localhost:9092"]
+  group_id: g
+  topics: [orders]
+es:
+  addresses: ["http://localhost:9200"]
+worker:
+  scheduling:
+    enabled: true
+`
+
+func TestReloaderAppliesAndRejects(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(body string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(reloadBase+body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("mappings:\n  orders: orders-v1\n")
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{"http://localhost:9200"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mapper.New(cfg.Mappings)
+	router, err := NewRouter(cfg, es, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close(context.Background())
+	r := NewReloader(path, cfg, m, router)
+
+	write("mappings:\n  orders: orders-v2\n")
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if got := m.IndexForTopic("orders"); got != "orders-v2" {
+		t.Errorf("mapping after reload = %q, want orders-v2", got)
+	}
+
+	write("mappings:\n  orders: Orders-V3\n")
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected invalid reload to be rejected")
+	}
+	if got := m.IndexForTopic("orders"); got != "orders-v2" {
+		t.Errorf("mapping after rejected reload = %q, want orders-v2", got)
+	}
+	if r.Current().Mappings["orders"] != "orders-v2" {
+		t.Errorf("current config changed by a rejected reload")
+	}
+
+	write("mappings:\n  orders: orders-v2\npipelines:\n  - name: orders\n    topics: [orders]\n    index: \"{topic}-p\"\n")
+	cfg, err = config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = NewReloader(path, cfg, m)
+	router, err = NewRouter(cfg, es, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close(context.Background())
+	r.Add(router)
+	write("mappings:\n  orders: orders-v2\npipelines:\n  - name: orders\n    topics: [orders]\n    index: \"{topic}-q\"\n    transforms:\n      - op: remove\n        field: payload.secret\n")
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	item, err := router.For("orders").Pool.Item(&kafka.Message{Topic: "orders", Value: []byte(`{"secret":1,"ok":2}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Index != "orders-q" || strings.Contains(string(item.Body), "secret") {
+		t.Errorf("pipeline settings not reloaded: %s %s", item.Index, item.Body)
+	}
+
+	m.AddMapping("orders", "orders-runtime")
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if got := m.IndexForTopic("orders"); got != "orders-runtime" {
+		t.Errorf("runtime mapping after reload = %q, want orders-runtime", got)
+	}
+}
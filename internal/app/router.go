@@ -0,0 +1,203 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/elastic/go-elasticsearch/v8"
+
+	"github.com/gor0utine/kafka-to-es/internal/config"
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+	"github.com/gor0utine/kafka-to-es/internal/worker"
+)
+
+// Router sends every message to the processor of the pipeline that claims
+// its topic: by name first, then by pattern in the order the pipelines are
+// configured. Topics no pipeline claims go to the default processor, built
+// from the worker section. Router implements kafka.Sink.
+type Router struct {
+	opts     routerOptions
+	def      *Processor
+	procs    []*Processor
+	byTopic  map[string]*Processor
+	patterns []patternRoute
+}
+
+type patternRoute struct {
+	pattern string
+	proc    *Processor
+}
+
+// RouterOption configures a Router.
+type RouterOption func(*routerOptions)
+
+type routerOptions struct {
+	indices worker.Mapper
+}
+
+// WithIndexMapper makes every pipeline write to the indices chosen by m,
+// ignoring the pipelines' index templates. Replays use it to write to new
+// index versions.
+func WithIndexMapper(m worker.Mapper) RouterOption {
+	return func(o *routerOptions) {
+		o.indices = m
+	}
+}
+
+// NewRouter builds one processor per configured pipeline plus the default
+// processor, which is left out if the pipelines claim every topic of
+// kafka.topics and kafka.static_partitions. Pipelines without an index
+// template write to the indices chosen by m.
+func NewRouter(cfg *config.Config, es *elasticsearch.Client, m worker.Mapper, opts ...RouterOption) (*Router, error) {
+	var o routerOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.indices != nil {
+		m = o.indices
+	}
+
+	r := &Router{opts: o, byTopic: make(map[string]*Processor)}
+	pipelines := cfg.Pipelines
+	if needsDefault(cfg) {
+		pipelines = append([]config.PipelineConfig{cfg.DefaultPipeline()}, pipelines...)
+	}
+	for _, pl := range pipelines {
+		if o.indices != nil {
+			pl.Index = ""
+		}
+		p, err := newProcessor(cfg, es, m, pl)
+		if err != nil {
+			_ = r.Close(context.Background())
+			return nil, fmt.Errorf("pipeline %s: %w", pl.Name, err)
+		}
+		r.procs = append(r.procs, p)
+		for _, t := range pl.Topics {
+			r.byTopic[t] = p
+		}
+		for _, pattern := range pl.TopicPatterns {
+			r.patterns = append(r.patterns, patternRoute{pattern, p})
+		}
+	}
+	r.def = r.procs[0]
+	return r, nil
+}
+
+// needsDefault reports whether some topic of cfg is claimed by no pipeline.
+func needsDefault(cfg *config.Config) bool {
+	if len(cfg.Pipelines) == 0 {
+		return true
+	}
+	topics := append([]string(nil), cfg.Kafka.Topics...)
+	for topic := range cfg.Kafka.StaticPartitions {
+		topics = append(topics, topic)
+	}
+	for _, topic := range topics {
+		if cfg.PipelineFor(topic).Name == config.DefaultPipelineName {
+			return true
+		}
+	}
+	return false
+}
+
+// Default returns the processor of topics that no pipeline claims, or the
+// first pipeline's if every topic is claimed.
+func (r *Router) Default() *Processor {
+	return r.def
+}
+
+// Processors returns every processor, the default one first if there is
+// one.
+func (r *Router) Processors() []*Processor {
+	return r.procs
+}
+
+// For returns the processor that handles topic.
+func (r *Router) For(topic string) *Processor {
+	if p, ok := r.byTopic[topic]; ok {
+		return p
+	}
+	for _, pr := range r.patterns {
+		if ok, _ := path.Match(pr.pattern, topic); ok {
+			return pr.proc
+		}
+	}
+	return r.def
+}
+
+// Put implements kafka.Sink.
+func (r *Router) Put(ctx context.Context, msg *kafka.Message) error {
+	return r.For(msg.Topic).Sink.Put(ctx, msg)
+}
+
+// Start starts the worker pools.
+func (r *Router) Start(ctx context.Context) {
+	for _, p := range r.procs {
+		p.Pool.Start(ctx)
+	}
+}
+
+// CloseInput stops accepting messages on every processor.
+func (r *Router) CloseInput() {
+	for _, p := range r.procs {
+		p.CloseInput()
+	}
+}
+
+// Wait blocks until the workers of every pool have returned.
+func (r *Router) Wait() {
+	for _, p := range r.procs {
+		p.Pool.Wait()
+	}
+}
+
+// Close flushes and closes every processor's bulk indexer and dead letter
+// writer.
+func (r *Router) Close(ctx context.Context) error {
+	var errs []error
+	for _, p := range r.procs {
+		if err := p.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("pipeline %s: %w", p.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetPolicies replaces the scheduling policies of every processor that
+// schedules topics.
+func (r *Router) SetPolicies(def worker.TopicPolicy, policies map[string]worker.TopicPolicy) {
+	for _, p := range r.procs {
+		if p.sched != nil {
+			p.sched.SetPolicies(def, policies)
+		}
+	}
+}
+
+// Reconfigure applies the document settings of the pipelines in cfg, such
+// as transforms, index templates and document IDs, to the running
+// processors of the same name. Processors whose pipeline is not in cfg keep
+// their settings.
+func (r *Router) Reconfigure(cfg *config.Config) error {
+	pipelines := append([]config.PipelineConfig{cfg.DefaultPipeline()}, cfg.Pipelines...)
+	var errs []error
+	for _, p := range r.procs {
+		for _, pl := range pipelines {
+			if pl.Name != p.Name {
+				continue
+			}
+			if r.opts.indices != nil {
+				pl.Index = ""
+			}
+			opts, err := outputOptions(pl)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("pipeline %s: %w", p.Name, err))
+				break
+			}
+			p.Pool.Reconfigure(opts...)
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
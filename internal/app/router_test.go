@@ -0,0 +1,120 @@
+package app
+
+import (
+	"context"
+	"expvar"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+
+	"github.com/gor0utine/kafka-to-es/internal/config"
+	"github.com/gor0utine/kafka-to-es/internal/mapper"
+)
+
+func TestRouterRoutesByPipeline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	body := reloadBase + `
+pipelines:
+  - name: audit
+    topic_patterns: ["audit.*"]
+    index: "audit-{date:2006.01}"
+    num_workers: 1
+  - name: payments
+    topics: [payments, audit.payments]
+`
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{"http://localhost:9200"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, err := NewRouter(cfg, es, mapper.New(cfg.Mappings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close(context.Background())
+
+	for topic, want := range map[string]string{
+		"orders":         config.DefaultPipelineName,
+		"payments":       "payments",
+		"audit.login":    "audit",
+		"audit.payments": "payments",
+		"other":          config.DefaultPipelineName,
+	} {
+		if got := router.For(topic).Name; got != want {
+			t.Errorf("topic %s routed to %s, want %s", topic, got, want)
+		}
+		if got := cfg.PipelineFor(topic).Name; got != want {
+			t.Errorf("PipelineFor(%s) = %s, want %s", topic, got, want)
+		}
+	}
+	if len(router.Processors()) != 3 {
+		t.Errorf("got %d processors, want 3", len(router.Processors()))
+	}
+	if got := cfg.SubscribedTopics(); len(got) != 3 {
+		t.Errorf("SubscribedTopics() = %v, want orders and both payments topics", got)
+	}
+}
+
+func TestIsolatedPipelineRouter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	body := reloadBase + `
+pipelines:
+  - name: isolated-logs
+    topics: [logs]
+    kafka:
+      group_id: logs
+`
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	shared, isolated := cfg.Split()
+	if len(shared.Pipelines) != 0 || len(isolated) != 1 {
+		t.Fatalf("Split() = %+v, %+v", shared, isolated)
+	}
+	es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{"http://localhost:9200"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, err := NewRouter(isolated[0], es, mapper.New(cfg.Mappings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close(context.Background())
+
+	// Every topic belongs to the pipeline, so there is no default processor.
+	if procs := router.Processors(); len(procs) != 1 || procs[0].Name != "isolated-logs" || router.Default() != procs[0] {
+		t.Fatalf("unexpected processors %+v", procs)
+	}
+	consumerCfg, err := ConsumerConfig(isolated[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if consumerCfg.GroupID != "logs" || len(consumerCfg.Topics) != 1 || consumerCfg.Topics[0] != "logs" {
+		t.Errorf("unexpected consumer config: group %s, topics %v", consumerCfg.GroupID, consumerCfg.Topics)
+	}
+	pipelines := expvar.Get("kafka_to_es").(*expvar.Map).Get("pipelines").(*expvar.Map)
+	labelled := pipelines.Get("isolated-logs").(*expvar.Map)
+	for _, name := range []string{"flow_paused", "bulker_indexers_live"} {
+		if labelled.Get(name) == nil {
+			t.Errorf("metric %s is not labelled by pipeline", name)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+
+	"github.com/gor0utine/kafka-to-es/internal/config"
+	"github.com/gor0utine/kafka-to-es/internal/esclient"
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+	"github.com/gor0utine/kafka-to-es/internal/metrics"
+	"github.com/gor0utine/kafka-to-es/internal/worker"
+)
+
+const (
+	// resolveTimeout bounds the lookup of the topics matching the patterns.
+	resolveTimeout = 30 * time.Second
+	// Consumers that fail to start are retried after minRetry, doubling up
+	// to maxRetry.
+	minRetry = 5 * time.Second
+	maxRetry = 2 * time.Minute
+)
+
+// Service is one running consumer: its Elasticsearch client, the Kafka
+// consumer and the processors of its pipelines.
+type Service struct {
+	Name     string
+	Config   *config.Config
+	ES       *elasticsearch.Client
+	Router   *Router
+	Consumer *kafka.ConsumerManager
+}
+
+// StartService connects the consumer described by cfg, one of the
+// configurations returned by config.Split, and starts consuming until ctx
+// is done. Pipelines without an index template write to the indices chosen
+// by m.
+func StartService(ctx context.Context, cfg *config.Config, m worker.Mapper) (*Service, error) {
+	es, err := esclient.New(cfg.ES)
+	if err != nil {
+		return nil, fmt.Errorf("es client: %w", err)
+	}
+	consumerCfg, err := ConsumerConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("kafka config: %w", err)
+	}
+	resolveCtx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	err = ResolveTopics(resolveCtx, cfg, &consumerCfg)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("kafka topics: %w", err)
+	}
+	router, err := NewRouter(cfg, es, m)
+	if err != nil {
+		return nil, fmt.Errorf("worker config: %w", err)
+	}
+	consumerCfg.Checkpoints, err = CheckpointStore(cfg, es, router.Default().Bulker)
+	if err == nil {
+		var consumer *kafka.ConsumerManager
+		consumer, err = kafka.NewConsumerManager(consumerCfg)
+		if err == nil {
+			s := &Service{Name: cfg.Name(), Config: cfg, ES: es, Router: router, Consumer: consumer}
+			consumer.StartSink(ctx, router)
+			router.Start(ctx)
+			return s, nil
+		}
+		err = fmt.Errorf("kafka consumer: %w", err)
+	} else {
+		err = fmt.Errorf("checkpoint store: %w", err)
+	}
+	router.CloseInput()
+	return nil, errors.Join(err, router.Close(context.Background()))
+}
+
+// Close flushes the processors, then commits the final offsets and leaves
+// the consumer group, so offsets never get ahead of the documents. Cancel
+// the context passed to StartService first.
+func (s *Service) Close(ctx context.Context) error {
+	var errs []error
+	if err := s.Router.Close(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("close bulker: %w", err))
+	}
+	if err := s.Consumer.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("close consumer: %w", err))
+	}
+	s.Router.CloseInput()
+	return errors.Join(errs...)
+}
+
+// Supervisor runs several consumers in one process. Each starts on its own:
+// one that fails to start is logged and retried with backoff while the
+// others keep running.
+type Supervisor struct {
+	onStart func(*Service)
+
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	services []*Service
+}
+
+// NewSupervisor creates a Supervisor that calls onStart, if not nil, with
+// every consumer once it has started.
+func NewSupervisor(onStart func(*Service)) *Supervisor {
+	return &Supervisor{onStart: onStart}
+}
+
+// Go starts the consumer described by cfg in the background until ctx is
+// done. The consumer's "running" gauge is 1 while it runs.
+func (sv *Supervisor) Go(ctx context.Context, cfg *config.Config, m worker.Mapper) {
+	running := metrics.Pipeline(cfg.Name()).Gauge("running")
+	sv.wg.Add(1)
+	go func() {
+		defer sv.wg.Done()
+		for retry := minRetry; ; retry = min(2*retry, maxRetry) {
+			s, err := StartService(ctx, cfg, m)
+			if err == nil {
+				sv.mu.Lock()
+				sv.services = append(sv.services, s)
+				sv.mu.Unlock()
+				running.Set(1)
+				slog.Info("pipeline started", "pipeline", s.Name, "topics", s.Consumer.Topics())
+				if sv.onStart != nil {
+					sv.onStart(s)
+				}
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Error("pipeline failed to start", "pipeline", cfg.Name(), "retry_in", retry, "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry):
+			}
+		}
+	}()
+}
+
+// Services returns the consumers that are running.
+func (sv *Supervisor) Services() []*Service {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	return append([]*Service(nil), sv.services...)
+}
+
+// Service returns the running consumer of the named pipeline, or nil.
+func (sv *Supervisor) Service(name string) *Service {
+	for _, s := range sv.Services() {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Close waits for pending starts to give up, then closes every consumer
+// in parallel. Cancel the context passed to Go first.
+func (sv *Supervisor) Close(ctx context.Context) error {
+	sv.wg.Wait()
+	services := sv.Services()
+	errs := make([]error, len(services))
+	var wg sync.WaitGroup
+	for i, s := range services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Close(ctx); err != nil {
+				errs[i] = fmt.Errorf("pipeline %s: %w", s.Name, err)
+			}
+			metrics.Pipeline(s.Name).Gauge("running").Set(0)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
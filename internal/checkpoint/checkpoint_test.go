@@ -0,0 +1,192 @@
+package checkpoint
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+
+	"github.com/gor0utine/kafka-to-es/internal/indexer"
+)
+
+func TestFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	f, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("NewFile() error = %v", err)
+	}
+	if off, err := f.Offset(context.Background(), "orders", 0); err != nil || off != -1 {
+		t.Fatalf("Offset() on empty store = %d, %v; want -1", off, err)
+	}
+	if err := f.CommitOffsets(map[string]map[int]int64{"orders": {0: 10, 1: 20}}); err != nil {
+		t.Fatalf("CommitOffsets() error = %v", err)
+	}
+	if err := f.CommitOffsets(map[string]map[int]int64{"orders": {1: 25}}); err != nil {
+		t.Fatalf("CommitOffsets() error = %v", err)
+	}
+
+	reopened, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("NewFile() reopen error = %v", err)
+	}
+	for p, want := range map[int]int64{0: 10, 1: 25, 2: -1} {
+		if off, _ := reopened.Offset(context.Background(), "orders", p); off != want {
+			t.Errorf("partition %d offset = %d, want %d", p, off, want)
+		}
+	}
+}
+
+func TestElasticsearchStore(t *testing.T) {
+	var bulk []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/checkpoints/_doc/c1:orders:0":
+			_, _ = w.Write([]byte(`{"found":true,"_source":{"offset":42}}`))
+		case r.Method == http.MethodGet:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"found":false}`))
+		case r.URL.Path == "/_bulk":
+			sc := bufio.NewScanner(r.Body)
+			for sc.Scan() {
+				bulk = append(bulk, sc.Text())
+			}
+			_, _ = w.Write([]byte(`{"errors":false,"items":[]}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+	es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewElasticsearch(es, "checkpoints", "c1")
+
+	if off, err := s.Offset(context.Background(), "orders", 0); err != nil || off != 42 {
+		t.Errorf("Offset(orders, 0) = %d, %v; want 42", off, err)
+	}
+	if off, err := s.Offset(context.Background(), "orders", 1); err != nil || off != -1 {
+		t.Errorf("Offset(orders, 1) = %d, %v; want -1", off, err)
+	}
+
+	if err := s.CommitOffsets(map[string]map[int]int64{"orders": {3: 7}}); err != nil {
+		t.Fatalf("CommitOffsets() error = %v", err)
+	}
+	if len(bulk) != 2 || !strings.Contains(bulk[0], `"_id":"c1:orders:3"`) {
+		t.Fatalf("unexpected bulk body %q", bulk)
+	}
+	var doc Document
+	if err := json.Unmarshal([]byte(bulk[1]), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Topic != "orders" || doc.Partition != 3 || doc.Offset != 7 || doc.Name != "c1" {
+		t.Errorf("unexpected checkpoint document %+v", doc)
+	}
+}
+
+type fakeIndexer struct {
+	items  []indexer.Item
+	closed bool
+}
+
+func (f *fakeIndexer) Add(_ context.Context, it indexer.Item) error {
+	if f.closed {
+		return indexer.ErrBulkerClosed
+	}
+	f.items = append(f.items, it)
+	return nil
+}
+
+func (f *fakeIndexer) Close(context.Context) error {
+	f.closed = true
+	return nil
+}
+
+func TestElasticsearchStoreWithIndexer(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		requests++
+		_, _ = w.Write([]byte(`{"errors":false,"items":[]}`))
+	}))
+	defer srv.Close()
+	es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix := &fakeIndexer{}
+	s := NewElasticsearch(es, "checkpoints", "c1", WithIndexer(ix))
+
+	if err := s.CommitOffsets(map[string]map[int]int64{"orders": {0: 5}}); err != nil {
+		t.Fatalf("CommitOffsets() error = %v", err)
+	}
+	if requests != 0 || len(ix.items) != 1 {
+		t.Fatalf("expected the checkpoint in the indexer only, got %d items and %d requests", len(ix.items), requests)
+	}
+	if it := ix.items[0]; it.Index != "checkpoints" || it.ID != "c1:orders:0" {
+		t.Errorf("unexpected checkpoint item %+v", it)
+	}
+
+	_ = ix.Close(context.Background())
+	if err := s.CommitOffsets(map[string]map[int]int64{"orders": {0: 6}}); err != nil {
+		t.Fatalf("CommitOffsets() after indexer closed error = %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("expected a direct write once the indexer is closed, got %d requests", requests)
+	}
+}
+
+func TestElasticsearchStoreRetriesFailedCheckpoints(t *testing.T) {
+	ix := &fakeIndexer{}
+	s := NewElasticsearch(nil, "checkpoints", "c1", WithIndexer(ix))
+
+	if err := s.CommitOffsets(map[string]map[int]int64{"orders": {0: 5, 1: 7}}); err != nil {
+		t.Fatalf("CommitOffsets() error = %v", err)
+	}
+	for _, it := range ix.items {
+		it.OnDone(errors.New("es_rejected_execution_exception"))
+	}
+	ix.items = nil
+
+	if err := s.CommitOffsets(map[string]map[int]int64{"orders": {1: 9}}); err != nil {
+		t.Fatalf("CommitOffsets() error = %v", err)
+	}
+	got := map[string]int64{}
+	for _, it := range ix.items {
+		var doc Document
+		if err := json.Unmarshal(it.Body, &doc); err != nil {
+			t.Fatal(err)
+		}
+		got[it.ID] = doc.Offset
+	}
+	want := map[string]int64{"c1:orders:0": 5, "c1:orders:1": 9}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("second save queued %v, want %v", got, want)
+	}
+
+	// A failure of a checkpoint that was superseded is not retried.
+	first := ix.items
+	ix.items = nil
+	if err := s.CommitOffsets(map[string]map[int]int64{"orders": {0: 6}}); err != nil {
+		t.Fatalf("CommitOffsets() error = %v", err)
+	}
+	for _, it := range first {
+		it.OnDone(errors.New("es_rejected_execution_exception"))
+	}
+	ix.items = nil
+	if err := s.CommitOffsets(map[string]map[int]int64{"orders": {2: 1}}); err != nil {
+		t.Fatalf("CommitOffsets() error = %v", err)
+	}
+	if len(ix.items) != 2 {
+		t.Errorf("expected partition 1 retried next to partition 2, got %d items", len(ix.items))
+	}
+}
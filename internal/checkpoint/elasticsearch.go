@@ -0,0 +1,257 @@
+package checkpoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+
+	"github.com/gor0utine/kafka-to-es/internal/indexer"
+	"github.com/gor0utine/kafka-to-es/internal/metrics"
+)
+
+// commitTimeout bounds a single checkpoint write.
+const commitTimeout = 10 * time.Second
+
+// Elasticsearch keeps offsets as one document per partition in an index.
+// Documents are keyed by name, so several consumers can share the index.
+type Elasticsearch struct {
+	es      *elasticsearch.Client
+	index   string
+	name    string
+	indexer indexer.Indexer
+
+	failures *expvar.Int
+
+	mu sync.Mutex
+	// queued is the latest offset queued per partition, failed the ones of
+	// those the indexer could not write. Failed checkpoints are sent again
+	// with the next save.
+	queued map[string]map[int]int64
+	failed map[string]map[int]int64
+}
+
+// Option configures an Elasticsearch store.
+type Option func(*Elasticsearch)
+
+// WithIndexer writes checkpoints through ix, in the same bulk flow as the
+// documents, instead of with a request of their own. Offsets only advance
+// once the documents before them were indexed, so a checkpoint never gets
+// ahead of the data. Once ix is closed, checkpoints are written directly.
+func WithIndexer(ix indexer.Indexer) Option {
+	return func(s *Elasticsearch) {
+		s.indexer = ix
+	}
+}
+
+// Document is the checkpoint stored for one partition.
+type Document struct {
+	Name      string    `json:"name"`
+	Topic     string    `json:"topic"`
+	Partition int       `json:"partition"`
+	Offset    int64     `json:"offset"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NewElasticsearch returns a store that writes to index on behalf of the
+// consumer called name.
+func NewElasticsearch(es *elasticsearch.Client, index, name string, opts ...Option) *Elasticsearch {
+	s := &Elasticsearch{
+		es:       es,
+		index:    index,
+		name:     name,
+		failures: metrics.Default().Counter("checkpoint_write_failures"),
+		queued:   make(map[string]map[int]int64),
+		failed:   make(map[string]map[int]int64),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// DocumentID returns the _id of the checkpoint of a partition.
+func (s *Elasticsearch) DocumentID(topic string, partition int) string {
+	return s.name + ":" + topic + ":" + strconv.Itoa(partition)
+}
+
+// Offset implements kafka.CheckpointStore.
+func (s *Elasticsearch) Offset(ctx context.Context, topic string, partition int) (int64, error) {
+	res, err := s.es.Get(s.index, s.DocumentID(topic, partition), s.es.Get.WithContext(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("get checkpoint: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return -1, nil
+	}
+	if res.IsError() {
+		return 0, fmt.Errorf("get checkpoint: %s", res.String())
+	}
+	var body struct {
+		Source Document `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return 0, fmt.Errorf("decode checkpoint: %w", err)
+	}
+	return body.Source.Offset, nil
+}
+
+// CommitOffsets implements kafka.Committer. Without an indexer all offsets
+// are written in one _bulk request. Checkpoints the indexer failed to write
+// earlier are sent again unless offsets holds a newer one.
+func (s *Elasticsearch) CommitOffsets(offsets map[string]map[int]int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commitTimeout)
+	defer cancel()
+	offsets = s.withFailed(offsets)
+	if s.indexer != nil {
+		err := s.enqueue(ctx, offsets)
+		if !errors.Is(err, indexer.ErrBulkerClosed) && !errors.Is(err, indexer.ErrPipelineClosed) {
+			return err
+		}
+	}
+	return s.write(ctx, offsets)
+}
+
+// document returns the checkpoint document of a partition.
+func (s *Elasticsearch) document(topic string, partition int, offset int64, now time.Time) ([]byte, error) {
+	return json.Marshal(Document{Name: s.name, Topic: topic, Partition: partition, Offset: offset, UpdatedAt: now})
+}
+
+// enqueue adds the checkpoints to the indexer.
+func (s *Elasticsearch) enqueue(ctx context.Context, offsets map[string]map[int]int64) error {
+	now := time.Now().UTC()
+	for topic, parts := range offsets {
+		for p, off := range parts {
+			body, err := s.document(topic, p, off, now)
+			if err != nil {
+				return err
+			}
+			it := indexer.Item{Index: s.index, ID: s.DocumentID(topic, p), Body: body}
+			it.OnDone = func(err error) {
+				if err != nil {
+					s.fail(topic, p, off, err)
+				}
+			}
+			s.mu.Lock()
+			setOffset(s.queued, topic, p, off)
+			s.mu.Unlock()
+			if err := s.indexer.Add(ctx, it); err != nil {
+				return fmt.Errorf("queue checkpoint %s: %w", it.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// fail records that the checkpoint of a partition could not be written, so
+// the next save retries it. A failure is ignored once a newer offset of the
+// partition was queued.
+func (s *Elasticsearch) fail(topic string, partition int, offset int64, err error) {
+	slog.Error("checkpoint write failed", "index", s.index, "topic", topic, "partition", partition, "offset", offset, "error", err)
+	s.failures.Add(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.queued[topic][partition] != offset {
+		return
+	}
+	setOffset(s.failed, topic, partition, offset)
+}
+
+// withFailed returns offsets with the failed checkpoints added, and forgets
+// them.
+func (s *Elasticsearch) withFailed(offsets map[string]map[int]int64) map[string]map[int]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.failed) == 0 {
+		return offsets
+	}
+	merged := make(map[string]map[int]int64, len(offsets)+len(s.failed))
+	for topic, parts := range s.failed {
+		merged[topic] = make(map[int]int64, len(parts))
+		for p, off := range parts {
+			merged[topic][p] = off
+		}
+	}
+	for topic, parts := range offsets {
+		if merged[topic] == nil {
+			merged[topic] = make(map[int]int64, len(parts))
+		}
+		for p, off := range parts {
+			merged[topic][p] = off
+		}
+	}
+	s.failed = make(map[string]map[int]int64)
+	return merged
+}
+
+// setOffset stores offset for a partition in m.
+func setOffset(m map[string]map[int]int64, topic string, partition int, offset int64) {
+	if m[topic] == nil {
+		m[topic] = make(map[int]int64)
+	}
+	m[topic][partition] = offset
+}
+
+// write sends the checkpoints in one _bulk request.
+func (s *Elasticsearch) write(ctx context.Context, offsets map[string]map[int]int64) error {
+	var buf bytes.Buffer
+	now := time.Now().UTC()
+	for topic, parts := range offsets {
+		for p, off := range parts {
+			meta, err := json.Marshal(map[string]map[string]string{"index": {"_index": s.index, "_id": s.DocumentID(topic, p)}})
+			if err != nil {
+				return err
+			}
+			body, err := s.document(topic, p, off, now)
+			if err != nil {
+				return err
+			}
+			buf.Write(meta)
+			buf.WriteByte('\n')
+			buf.Write(body)
+			buf.WriteByte('\n')
+		}
+	}
+
+	res, err := s.es.Bulk(&buf, s.es.Bulk.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("write checkpoints: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("write checkpoints: %s", res.String())
+	}
+	var blk struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID    string `json:"_id"`
+			Error struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&blk); err != nil {
+		return fmt.Errorf("decode checkpoint response: %w", err)
+	}
+	if blk.Errors {
+		for _, item := range blk.Items {
+			for _, r := range item {
+				if r.Error.Type != "" {
+					return fmt.Errorf("write checkpoint %s: %s: %s", r.ID, r.Error.Type, r.Error.Reason)
+				}
+			}
+		}
+	}
+	return nil
+}
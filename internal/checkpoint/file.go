@@ -0,0 +1,89 @@
+// Package checkpoint stores consumer offsets outside Kafka, for partitions
+// that are consumed without a consumer group.
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// File keeps offsets in a local JSON file of the form
+// {"topic": {"partition": offset}}. Every commit rewrites the file
+// atomically.
+type File struct {
+	path string
+
+	mu      sync.Mutex
+	offsets map[string]map[int]int64
+}
+
+// NewFile opens the checkpoint file at path. A missing file starts empty.
+func NewFile(path string) (*File, error) {
+	f := &File{path: path, offsets: make(map[string]map[int]int64)}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read checkpoints: %w", err)
+	}
+	if err := json.Unmarshal(b, &f.offsets); err != nil {
+		return nil, fmt.Errorf("parse checkpoints %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Offset implements kafka.CheckpointStore.
+func (f *File) Offset(_ context.Context, topic string, partition int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if off, ok := f.offsets[topic][partition]; ok {
+		return off, nil
+	}
+	return -1, nil
+}
+
+// CommitOffsets implements kafka.Committer.
+func (f *File) CommitOffsets(offsets map[string]map[int]int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for topic, parts := range offsets {
+		if f.offsets[topic] == nil {
+			f.offsets[topic] = make(map[int]int64)
+		}
+		for p, off := range parts {
+			f.offsets[topic][p] = off
+		}
+	}
+	b, err := json.MarshalIndent(f.offsets, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("write checkpoints: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write checkpoints: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write checkpoints: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write checkpoints: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("write checkpoints: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+// Package cli implements the kafka-to-es command. Its subcommands share the
+// -config and logging flags, load the configuration the same way, run with
+// a context that is canceled on SIGINT or SIGTERM and exit with the same
+// codes.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/gor0utine/kafka-to-es/internal/config"
+	"github.com/gor0utine/kafka-to-es/internal/logging"
+)
+
+// Exit codes shared by every subcommand.
+const (
+	ExitOK = 0
+	// ExitFailure means the command ran but did not succeed, such as a
+	// failed check or messages that were not indexed.
+	ExitFailure = 1
+	// ExitUsage means invalid flags or arguments.
+	ExitUsage = 2
+	// ExitConfig means the configuration could not be loaded or is invalid.
+	ExitConfig = 3
+)
+
+// Name is the name of the command in usage messages.
+const Name = "kafka-to-es"
+
+// command is one subcommand. setup registers its flags on fs and returns
+// the function that runs it once they are parsed.
+type command struct {
+	name    string
+	args    string // synopsis of the arguments after the flags
+	summary string
+	help    string
+	setup   func(fs *flag.FlagSet) runner
+}
+
+// runner runs a subcommand and returns its exit code.
+type runner func(ctx context.Context, e *env) int
+
+// commands lists the subcommands in the order of the usage message.
+var commands = []*command{
+	consumeCommand,
+	produceCommand,
+	replayCommand,
+	validateCommand,
+	doctorCommand,
+	dlqCommand,
+	inspectCommand,
+}
+
+func lookup(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// env is what a subcommand runs with.
+type env struct {
+	name       string
+	configPath string
+	args       []string
+	stdout     io.Writer
+	stderr     io.Writer
+}
+
+// config loads and validates the configuration. On failure it reports the
+// problems and returns ExitConfig.
+func (e *env) config() (*config.Config, int) {
+	cfg, err := config.Load(e.configPath)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Fprintf(e.stderr, "%s: %v\n", e.name, err)
+		return nil, ExitConfig
+	}
+	return cfg, ExitOK
+}
+
+// usage reports an invalid flag or argument and returns ExitUsage.
+func (e *env) usage(format string, args ...any) int {
+	fmt.Fprintf(e.stderr, "%s %s: %s\nRun '%s %s -help' for usage.\n", Name, e.name, fmt.Sprintf(format, args...), Name, e.name)
+	return ExitUsage
+}
+
+// Main runs the subcommand named by args[0] with the remaining arguments
+// and returns the process exit code.
+func Main(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		printUsage(stderr)
+		return ExitUsage
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		if len(args) > 1 && args[0] == "help" {
+			c := lookup(args[1])
+			if c == nil {
+				fmt.Fprintf(stderr, "%s help: unknown command %q\n", Name, args[1])
+				return ExitUsage
+			}
+			fs, _, _ := newFlagSet(c, stdout)
+			fs.Usage()
+			return ExitOK
+		}
+		printUsage(stdout)
+		return ExitOK
+	}
+	c := lookup(args[0])
+	if c == nil {
+		fmt.Fprintf(stderr, "%s: unknown command %q\n\n", Name, args[0])
+		printUsage(stderr)
+		return ExitUsage
+	}
+
+	fs, shared, run := newFlagSet(c, stderr)
+	rest, err := parseArgs(fs, args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+	e := &env{name: c.name, configPath: *shared.config, args: rest, stdout: stdout, stderr: stderr}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*shared.logLevel)); err != nil {
+		return e.usage("-log-level: %v", err)
+	}
+	if err := logging.Setup(stderr, *shared.logFormat, level); err != nil {
+		return e.usage("-log-format: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return run(ctx, e)
+}
+
+// parseArgs parses the flags in args, which may also follow the arguments
+// as in "dlq list -n 5", and returns the arguments. Everything after "--" is
+// an argument.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		n := len(args) - fs.NArg()
+		if fs.NArg() == 0 || (n > 0 && args[n-1] == "--") {
+			return append(rest, fs.Args()...), nil
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// sharedFlags are the flags of every subcommand.
+type sharedFlags struct {
+	config    *string
+	logLevel  *string
+	logFormat *string
+}
+
+// newFlagSet returns the flag set of c, whose messages go to w, with the
+// shared flags and c's own registered.
+func newFlagSet(c *command, w io.Writer) (*flag.FlagSet, *sharedFlags, runner) {
+	fs := flag.NewFlagSet(Name+" "+c.name, flag.ContinueOnError)
+	fs.SetOutput(w)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s %s [flags] %s\n\n%s\n", Name, c.name, c.args, c.summary)
+		if c.help != "" {
+			fmt.Fprintf(out, "\n%s\n", strings.TrimSpace(c.help))
+		}
+		fmt.Fprintln(out, "\nFlags:")
+		fs.PrintDefaults()
+	}
+	shared := &sharedFlags{
+		config:    fs.String("config", config.DefaultPath(), "path to the configuration file (env KTE_CONFIG)"),
+		logLevel:  fs.String("log-level", "info", "lowest level logged: debug, info, warn or error"),
+		logFormat: fs.String("log-format", logging.FormatText, "log format: text or json"),
+	}
+	return fs, shared, c.setup(fs)
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags] [arguments]\n\nCommands:\n", Name)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, `
+Run '%s <command> -help' for the flags of a command. Flags may
+come before or after the arguments.
+
+Exit codes: %d success, %d failure, %d invalid flags or arguments,
+%d invalid configuration.
+`, Name, ExitOK, ExitFailure, ExitUsage, ExitConfig)
+}
+
+// logConfig logs the effective configuration with secrets redacted.
+func logConfig(cfg *config.Config) {
+	log.Printf("effective config:\n%s", cfg.Redacted())
+}
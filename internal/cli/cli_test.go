@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMainExitCodes(t *testing.T) {
+	valid := writeConfig(t, "kafka:\n  brokers: [localhost:9092]\n  group_id: g\n  topics: [orders]\nes:\n  addresses: [http://localhost:9200]\n")
+	invalid := writeConfig(t, "kafka:\n  brokers: []\n  group_id: g\n  topics: [orders]\n")
+
+	for _, tc := range []struct {
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{args: nil, code: ExitUsage, stderr: "Commands:"},
+		{args: []string{"help"}, code: ExitOK, stdout: "validate-config"},
+		{args: []string{"help", "dlq"}, code: ExitOK, stdout: "Usage: kafka-to-es dlq [flags] list|redrive"},
+		{args: []string{"help", "nope"}, code: ExitUsage, stderr: `unknown command "nope"`},
+		{args: []string{"nope"}, code: ExitUsage, stderr: `unknown command "nope"`},
+		{args: []string{"consume", "-help"}, code: ExitOK, stderr: "-batch"},
+		{args: []string{"consume", "-nope"}, code: ExitUsage, stderr: "flag provided but not defined"},
+		{args: []string{"validate-config", "-config", valid}, code: ExitOK, stdout: valid + ": ok"},
+		{args: []string{"validate-config", "-q", "-config", valid}, code: ExitOK},
+		{args: []string{"validate-config", "-config", invalid}, code: ExitConfig, stderr: "kafka.brokers"},
+		{args: []string{"validate-config", "-config", filepath.Join(t.TempDir(), "missing.yaml")}, code: ExitConfig},
+		{args: []string{"validate-config", "-config", valid, "extra"}, code: ExitUsage, stderr: "unexpected arguments"},
+		{args: []string{"validate-config", "-log-level", "loud", "-config", valid}, code: ExitUsage, stderr: "-log-level"},
+		{args: []string{"validate-config", "-schema"}, code: ExitOK, stdout: `"$schema"`},
+		{args: []string{"dlq", "-config", valid, "purge"}, code: ExitUsage, stderr: `unknown action "purge"`},
+		{args: []string{"dlq", "list", "-config", valid}, code: ExitUsage, stderr: "no dead letter topic"},
+		{args: []string{"inspect", "-config", valid}, code: ExitUsage, stderr: "no topic given"},
+		{args: []string{"inspect", "-from", "soon", "orders"}, code: ExitUsage, stderr: "-from"},
+	} {
+		var stdout, stderr bytes.Buffer
+		code := Main(tc.args, &stdout, &stderr)
+		if code != tc.code {
+			t.Errorf("%q: exit %d, want %d\nstderr: %s", tc.args, code, tc.code, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), tc.stdout) {
+			t.Errorf("%q: stdout %q does not contain %q", tc.args, stdout.String(), tc.stdout)
+		}
+		if !strings.Contains(stderr.String(), tc.stderr) {
+			t.Errorf("%q: stderr %q does not contain %q", tc.args, stderr.String(), tc.stderr)
+		}
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		n    int
+		rest []string
+	}{
+		{[]string{"-n", "5", "list"}, 5, []string{"list"}},
+		{[]string{"list", "-n", "5"}, 5, []string{"list"}},
+		{[]string{"a", "-n", "5", "b"}, 5, []string{"a", "b"}},
+		{[]string{"a", "--", "-n", "5"}, 0, []string{"a", "-n", "5"}},
+	} {
+		fs, _, _ := newFlagSet(dlqCommand, &bytes.Buffer{})
+		rest, err := parseArgs(fs, tc.args)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.args, err)
+		}
+		if n := fs.Lookup("n").Value.String(); n != strconv.Itoa(tc.n) || !slices.Equal(rest, tc.rest) {
+			t.Errorf("%q: -n %s, arguments %q; want %d, %q", tc.args, n, rest, tc.n, tc.rest)
+		}
+	}
+}
+
+func TestServeShutsDown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		serve(ctx, "test", addr, http.NotFoundHandler())
+		close(done)
+	}()
+	var res *http.Response
+	for i := 0; i < 50; i++ {
+		if res, err = http.Get("http://" + addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("server not reachable: %v", err)
+	}
+	res.Body.Close()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(httpShutdownTimeout):
+		t.Fatal("server still running after ctx was done")
+	}
+}
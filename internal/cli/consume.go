@@ -0,0 +1,262 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gor0utine/kafka-to-es/internal/admin"
+	"github.com/gor0utine/kafka-to-es/internal/app"
+	"github.com/gor0utine/kafka-to-es/internal/config"
+	"github.com/gor0utine/kafka-to-es/internal/esclient"
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+	"github.com/gor0utine/kafka-to-es/internal/mapper"
+	"github.com/gor0utine/kafka-to-es/internal/metrics"
+)
+
+const (
+	// shutdownTimeout bounds the final flush and commit of the consumer.
+	shutdownTimeout = 30 * time.Second
+	// flushTimeout bounds the final flush of a batch run or a replay.
+	flushTimeout = 5 * time.Minute
+	// httpReadTimeout and httpReadHeaderTimeout bound how long the metrics
+	// and admin servers wait for a request; httpShutdownTimeout bounds how
+	// long they wait for running requests on shutdown.
+	httpReadTimeout       = 30 * time.Second
+	httpReadHeaderTimeout = 10 * time.Second
+	httpShutdownTimeout   = 5 * time.Second
+)
+
+var consumeCommand = &command{
+	name:    "consume",
+	summary: "consume the configured topics into Elasticsearch",
+	help: `
+Runs the consumer until SIGINT or SIGTERM, then flushes the indexers and
+commits the final offsets. SIGHUP reloads the configuration file.
+
+With -batch, consumes every partition from -from up to a snapshot of the
+-until offsets without a consumer group, prints a summary and exits with 1
+if some message was not acknowledged.`,
+	setup: func(fs *flag.FlagSet) runner {
+		batch := fs.Bool("batch", false, "consume up to a snapshot of the end offsets without a consumer group, then exit")
+		from := fs.String("from", "earliest", "batch start: earliest, latest, an RFC 3339 time or topic:partition=offset,...")
+		until := fs.String("until", "latest", "batch end: latest, an RFC 3339 time or topic:partition=offset,...")
+		pipeline := fs.String("pipeline", config.DefaultPipelineName, "batch: consume the topics of this pipeline's consumer (default: the shared consumer)")
+		return func(ctx context.Context, e *env) int {
+			if len(e.args) > 0 {
+				return e.usage("unexpected arguments %q", e.args)
+			}
+			cfg, code := e.config()
+			if cfg == nil {
+				return code
+			}
+			logConfig(cfg)
+			if *batch {
+				c, err := cfg.Consumer(*pipeline)
+				if err != nil {
+					return e.usage("-pipeline: %v", err)
+				}
+				return runBatch(ctx, e, c, *from, *until)
+			}
+			return consume(ctx, cfg, e.configPath)
+		}
+	},
+}
+
+// consume runs every consumer of cfg until ctx is done.
+func consume(ctx context.Context, cfg *config.Config, configPath string) int {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Every consumer shares the mappings, so reloads reach all of them.
+	m := mapper.New(cfg.Mappings)
+	reloader := app.NewReloader(configPath, cfg, m)
+	var ctrl *admin.Controller
+	sv := app.NewSupervisor(func(s *app.Service) {
+		reloader.Add(s.Router)
+		ctrl.Started(s)
+	})
+	ctrl = admin.NewController(m, sv)
+	shared, isolated := cfg.Split()
+	if shared != nil {
+		sv.Go(ctx, shared, m)
+	}
+	for _, c := range isolated {
+		sv.Go(ctx, c, m)
+	}
+
+	if cfg.Metrics.Addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/vars", metrics.Handler())
+		mux.HandleFunc("/assignments", func(w http.ResponseWriter, r *http.Request) {
+			name := r.URL.Query().Get("pipeline")
+			if name == "" {
+				name = config.DefaultPipelineName
+			}
+			s := sv.Service(name)
+			if s == nil {
+				http.Error(w, "pipeline "+name+" is not running", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(s.Consumer.Assignments())
+		})
+		go serve(ctx, "metrics", cfg.Metrics.Addr, mux)
+	}
+
+	if cfg.Admin.Addr != "" {
+		go serve(ctx, "admin", cfg.Admin.Addr, admin.Handler(ctrl, cfg.Admin.Token.Value))
+	}
+
+	if cfg.Control.Topic != "" {
+		go func() {
+			if err := admin.RunControl(ctx, cfg, ctrl); err != nil {
+				log.Printf("control topic: %v", err)
+			}
+		}()
+	}
+
+	if *cfg.Reload.Watch {
+		go reloader.Watch(ctx, cfg.Reload.Interval)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for range hup {
+			if err := reloader.Reload(); err != nil {
+				log.Printf("%v", err)
+			}
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("received shutdown signal, draining...")
+
+	cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer shutdownCancel()
+	// Final offsets are committed once the indexers have flushed, so they
+	// never get ahead of the documents.
+	if err := sv.Close(shutdownCtx); err != nil {
+		log.Printf("error during shutdown: %v", err)
+		return ExitFailure
+	}
+	log.Println("shutdown complete")
+	return ExitOK
+}
+
+// serve runs an HTTP server for h on addr until ctx is done, then shuts it
+// down.
+func serve(ctx context.Context, name, addr string, h http.Handler) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadTimeout:       httpReadTimeout,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("%s server shutdown: %v", name, err)
+		}
+	}()
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("%s server: %v", name, err)
+	}
+}
+
+// runBatch consumes every partition from the from offsets up to a snapshot
+// of the until offsets, without a consumer group, flushes the indexer and
+// prints a summary. It returns the exit code.
+func runBatch(ctx context.Context, e *env, cfg *config.Config, from, until string) int {
+	start, err := kafka.ParseOffsetSpec(from, kafka.FirstOffset)
+	if err != nil {
+		return e.usage("-from: %v", err)
+	}
+	end, err := kafka.ParseOffsetSpec(until, kafka.LastOffset)
+	if err != nil {
+		return e.usage("-until: %v", err)
+	}
+
+	es, err := esclient.New(cfg.ES)
+	if err != nil {
+		log.Printf("es client: %v", err)
+		return ExitFailure
+	}
+	consumerCfg, err := app.ConsumerConfig(cfg)
+	if err != nil {
+		log.Printf("kafka config: %v", err)
+		return ExitFailure
+	}
+	if err := app.ResolveTopics(ctx, cfg, &consumerCfg); err != nil {
+		log.Print(err)
+		return ExitFailure
+	}
+	router, err := app.NewRouter(cfg, es, mapper.New(cfg.Mappings))
+	if err != nil {
+		log.Printf("worker config: %v", err)
+		return ExitFailure
+	}
+
+	bc := kafka.NewBoundedConsumer(consumerCfg)
+	ranges, err := bc.Resolve(ctx, start, end)
+	if err != nil {
+		log.Printf("resolve offsets: %v", err)
+		return ExitFailure
+	}
+
+	began := time.Now()
+	router.Start(ctx)
+	progress, runErr := bc.Run(ctx, ranges, router)
+	router.CloseInput()
+	router.Wait()
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	if err := router.Close(flushCtx); err != nil {
+		log.Printf("error closing bulker: %v", err)
+		runErr = err
+	}
+
+	complete := printBatchSummary(e.stdout, progress, time.Since(began))
+	if runErr != nil {
+		log.Printf("batch failed: %v", runErr)
+		return ExitFailure
+	}
+	if !complete {
+		log.Println("batch finished with unacknowledged messages")
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// printBatchSummary writes one line per partition and a total, and reports
+// whether every consumed message was acknowledged.
+func printBatchSummary(w io.Writer, progress []*kafka.RangeProgress, elapsed time.Duration) bool {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TOPIC\tPARTITION\tSTART\tEND\tCONSUMED\tACKED")
+	var consumed, acked int64
+	for _, p := range progress {
+		c, a := p.Consumed.Load(), p.Acked.Load()
+		consumed += c
+		acked += a
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\n", p.Topic, p.Partition, p.Start, p.End, c, a)
+	}
+	fmt.Fprintf(tw, "TOTAL\t\t\t\t%d\t%d\n", consumed, acked)
+	tw.Flush()
+	fmt.Fprintf(w, "finished in %s\n", elapsed.Round(time.Millisecond))
+	return consumed == acked
+}
@@ -0,0 +1,246 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+
+	"github.com/gor0utine/kafka-to-es/internal/app"
+	"github.com/gor0utine/kafka-to-es/internal/config"
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+)
+
+var dlqCommand = &command{
+	name:    "dlq",
+	args:    "list|redrive",
+	summary: "list or redrive the messages of the dead letter topics",
+	help: `
+list prints the dead-lettered messages as JSON lines, with their original
+topic, partition and offset and the error that sent them there.
+
+redrive produces them again to their original topic, or to -to, without the
+dead letter headers, and prints where to resume with -from. Run it once the
+cause of the failures is fixed.
+
+The dead letter topics are those of the pipelines of -pipeline's consumer,
+unless -topic is set.`,
+	setup: func(fs *flag.FlagSet) runner {
+		topic := fs.String("topic", "", "dead letter topic to read (default: those of the configured pipelines)")
+		pipeline := fs.String("pipeline", config.DefaultPipelineName, "use the dead letter topics of this pipeline's consumer (default: the shared consumer)")
+		from := fs.String("from", "earliest", "start: earliest, latest, an RFC 3339 time or topic:partition=offset,...")
+		n := fs.Int("n", 0, "stop after this many messages (0: all up to the current end)")
+		to := fs.String("to", "", "redrive: produce to this topic instead of the original one")
+		dryRun := fs.Bool("dry-run", false, "redrive: print what would be produced without producing it")
+		return func(ctx context.Context, e *env) int {
+			if len(e.args) == 0 {
+				return e.usage("no action given (want list or redrive)")
+			}
+			action := e.args[0]
+			if len(e.args) > 1 {
+				return e.usage("unexpected arguments %q", e.args[1:])
+			}
+			if action != "list" && action != "redrive" {
+				return e.usage("unknown action %q (want list or redrive)", action)
+			}
+			start, err := kafka.ParseOffsetSpec(*from, kafka.FirstOffset)
+			if err != nil {
+				return e.usage("-from: %v", err)
+			}
+			cfg, code := e.config()
+			if cfg == nil {
+				return code
+			}
+			if cfg, err = cfg.Consumer(*pipeline); err != nil {
+				return e.usage("-pipeline: %v", err)
+			}
+			topics := dlqTopics(cfg)
+			if *topic != "" {
+				topics = []string{*topic}
+			}
+			if len(topics) == 0 {
+				return e.usage("no dead letter topic configured; set -topic")
+			}
+			cc, err := app.ConsumerConfig(cfg)
+			if err != nil {
+				log.Printf("kafka config: %v", err)
+				return ExitFailure
+			}
+			if action == "list" {
+				return listDLQ(ctx, e, cc, topics, start, *n)
+			}
+			return redrive(ctx, e, cc, topics, start, *n, *to, *dryRun)
+		}
+	},
+}
+
+// dlqTopics returns the sorted dead letter topics of the pipelines of cfg.
+func dlqTopics(cfg *config.Config) []string {
+	var topics []string
+	for _, pl := range append([]config.PipelineConfig{cfg.DefaultPipeline()}, cfg.Pipelines...) {
+		if pl.DLQ.Topic != "" && !slices.Contains(topics, pl.DLQ.Topic) {
+			topics = append(topics, pl.DLQ.Topic)
+		}
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// deadLetter is the output line of a dead-lettered message.
+type deadLetter struct {
+	Topic     string    `json:"topic"`
+	Partition int       `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Time      time.Time `json:"time"`
+	Key       string    `json:"key,omitempty"`
+	Value     string    `json:"value"`
+	Original  *position `json:"original,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	// RedrivenTo is the topic a redrive produced the message to.
+	RedrivenTo string `json:"redriven_to,omitempty"`
+}
+
+type position struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+// parseDeadLetter reads the dead letter headers of msg.
+func parseDeadLetter(msg *kafka.Message) deadLetter {
+	d := deadLetter{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Time:      msg.Time,
+		Key:       string(msg.Key),
+		Value:     string(msg.Value),
+	}
+	var orig position
+	for _, h := range msg.Headers {
+		switch h.Key {
+		case kafka.HeaderDLQTopic:
+			orig.Topic = string(h.Value)
+		case kafka.HeaderDLQPartition:
+			orig.Partition, _ = strconv.Atoi(string(h.Value))
+		case kafka.HeaderDLQOffset:
+			orig.Offset, _ = strconv.ParseInt(string(h.Value), 10, 64)
+		case kafka.HeaderDLQError:
+			d.Error = string(h.Value)
+		}
+	}
+	if orig.Topic != "" {
+		d.Original = &orig
+	}
+	return d
+}
+
+func listDLQ(ctx context.Context, e *env, cc kafka.ConsumerConfig, topics []string, start kafka.OffsetSpec, n int) int {
+	enc := json.NewEncoder(e.stdout)
+	err := readTopics(ctx, cc, topics, start, n, func(msg *kafka.Message) error {
+		return enc.Encode(parseDeadLetter(msg))
+	})
+	if err != nil {
+		log.Printf("dlq list: %v", err)
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// redrive produces the dead-lettered messages again without the dead letter
+// headers. Messages that do not say where they came from are skipped unless
+// to is set.
+func redrive(ctx context.Context, e *env, cc kafka.ConsumerConfig, topics []string, start kafka.OffsetSpec, n int, to string, dryRun bool) int {
+	w := &kafkago.Writer{
+		Addr:         kafkago.TCP(cc.Brokers...),
+		Balancer:     &kafkago.Hash{},
+		RequiredAcks: kafkago.RequireAll,
+		Transport:    kafka.NewTransport(cc.TLS, cc.SASL, cc.Timeouts),
+	}
+	defer w.Close()
+
+	enc := json.NewEncoder(e.stdout)
+	next := make(map[string]map[int]int64)
+	var redriven, skipped int
+	err := readTopics(ctx, cc, topics, start, n, func(msg *kafka.Message) error {
+		d := parseDeadLetter(msg)
+		target := to
+		if target == "" && d.Original != nil {
+			target = d.Original.Topic
+		}
+		if target == "" {
+			log.Printf("%s/%d@%d: no original topic, skipped", msg.Topic, msg.Partition, msg.Offset)
+			skipped++
+		} else if !dryRun {
+			err := w.WriteMessages(ctx, kafkago.Message{
+				Topic:   target,
+				Key:     msg.Key,
+				Value:   msg.Value,
+				Headers: withoutDLQHeaders(msg.Headers),
+				Time:    msg.Time,
+			})
+			if err != nil {
+				return fmt.Errorf("%s/%d@%d: produce to %s: %w", msg.Topic, msg.Partition, msg.Offset, target, err)
+			}
+		}
+		if target != "" {
+			d.RedrivenTo = target
+			redriven++
+		}
+		if next[msg.Topic] == nil {
+			next[msg.Topic] = make(map[int]int64)
+		}
+		next[msg.Topic][msg.Partition] = msg.Offset + 1
+		return enc.Encode(d)
+	})
+	verb := "redriven"
+	if dryRun {
+		verb = "would be redriven"
+	}
+	log.Printf("%d messages %s, %d skipped", redriven, verb, skipped)
+	if len(next) > 0 {
+		log.Printf("resume with -from %s", formatOffsets(next))
+	}
+	if err != nil {
+		log.Printf("dlq redrive: %v", err)
+		return ExitFailure
+	}
+	if skipped > 0 {
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// withoutDLQHeaders returns headers without the ones added by the dead
+// letter writer.
+func withoutDLQHeaders(headers []kafkago.Header) []kafkago.Header {
+	var out []kafkago.Header
+	for _, h := range headers {
+		switch h.Key {
+		case kafka.HeaderDLQTopic, kafka.HeaderDLQPartition, kafka.HeaderDLQOffset, kafka.HeaderDLQError:
+			continue
+		}
+		out = append(out, h)
+	}
+	return out
+}
+
+// formatOffsets formats offsets as accepted by kafka.ParseOffsetSpec.
+func formatOffsets(offsets map[string]map[int]int64) string {
+	var parts []string
+	for topic, partitions := range offsets {
+		for partition, offset := range partitions {
+			parts = append(parts, fmt.Sprintf("%s:%d=%d", topic, partition, offset))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
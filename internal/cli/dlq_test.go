@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	kafkago "github.com/segmentio/kafka-go"
+
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+)
+
+func TestParseDeadLetter(t *testing.T) {
+	msg := &kafka.Message{
+		Topic:     "orders-dlq",
+		Partition: 1,
+		Offset:    7,
+		Key:       []byte("k"),
+		Value:     []byte("not json"),
+		Headers: []kafkago.Header{
+			{Key: "trace", Value: []byte("abc")},
+			{Key: kafka.HeaderDLQTopic, Value: []byte("orders")},
+			{Key: kafka.HeaderDLQPartition, Value: []byte("2")},
+			{Key: kafka.HeaderDLQOffset, Value: []byte("120")},
+			{Key: kafka.HeaderDLQError, Value: []byte("decode: invalid character")},
+		},
+	}
+	d := parseDeadLetter(msg)
+	want := position{Topic: "orders", Partition: 2, Offset: 120}
+	if d.Original == nil || *d.Original != want || d.Error != "decode: invalid character" || d.Value != "not json" {
+		t.Errorf("parseDeadLetter() = %+v", d)
+	}
+	if h := withoutDLQHeaders(msg.Headers); !reflect.DeepEqual(h, msg.Headers[:1]) {
+		t.Errorf("withoutDLQHeaders() = %v", h)
+	}
+
+	if d := parseDeadLetter(&kafka.Message{Topic: "x"}); d.Original != nil {
+		t.Errorf("message without headers has an origin: %+v", d.Original)
+	}
+}
+
+func TestFormatOffsetsRoundTrip(t *testing.T) {
+	offsets := map[string]map[int]int64{"orders-dlq": {0: 12, 1: 3}, "users-dlq": {0: 1}}
+	s := formatOffsets(offsets)
+	if s != "orders-dlq:0=12,orders-dlq:1=3,users-dlq:0=1" {
+		t.Fatalf("formatOffsets() = %q", s)
+	}
+	spec, err := kafka.ParseOffsetSpec(s, kafka.FirstOffset)
+	if err != nil || !reflect.DeepEqual(spec.Offsets, offsets) {
+		t.Errorf("ParseOffsetSpec(%q) = %+v, %v", s, spec.Offsets, err)
+	}
+}
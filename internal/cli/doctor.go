@@ -0,0 +1,392 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+
+	"github.com/gor0utine/kafka-to-es/internal/app"
+	"github.com/gor0utine/kafka-to-es/internal/config"
+	"github.com/gor0utine/kafka-to-es/internal/esclient"
+	"github.com/gor0utine/kafka-to-es/internal/indexer"
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+	"github.com/gor0utine/kafka-to-es/internal/mapper"
+	"github.com/gor0utine/kafka-to-es/internal/worker"
+)
+
+var doctorCommand = &command{
+	name:    "doctor",
+	summary: "check connectivity and permissions before consuming",
+	help: `
+Checks, for the shared consumer and every isolated pipeline, what the
+consumer needs before it starts and prints a PASS, WARN or FAIL line for
+each:
+
+  - the Kafka brokers are reachable
+  - the topics, pattern matches and dead letter topics exist, and their
+    partition counts
+  - the consumer group's committed offsets can be read, and its lag
+  - Elasticsearch is reachable, its version and the cluster health
+  - the user may write to every index the topics map to
+  - the index templates and ingest pipelines those indices use exist
+
+Exits with 1 if any check fails. Warnings do not fail the run.`,
+	setup: func(fs *flag.FlagSet) runner {
+		timeout := fs.Duration("timeout", 10*time.Second, "time limit of each check")
+		return func(ctx context.Context, e *env) int {
+			if len(e.args) > 0 {
+				return e.usage("unexpected arguments %q", e.args)
+			}
+			cfg, code := e.config()
+			if cfg == nil {
+				return code
+			}
+			r := &report{w: e.stdout}
+			for _, c := range consumers(cfg) {
+				fmt.Fprintf(e.stdout, "consumer %s\n", c.Name())
+				d := &doctor{cfg: c, timeout: *timeout, report: r}
+				d.run(ctx)
+				fmt.Fprintln(e.stdout)
+			}
+			if r.failed > 0 {
+				fmt.Fprintf(e.stdout, "%d of %d checks failed\n", r.failed, r.total)
+				return ExitFailure
+			}
+			fmt.Fprintf(e.stdout, "all %d checks passed", r.total)
+			if r.warned > 0 {
+				fmt.Fprintf(e.stdout, ", %d with warnings", r.warned)
+			}
+			fmt.Fprintln(e.stdout)
+			return ExitOK
+		}
+	},
+}
+
+// consumers returns the configurations of the consumers cfg runs: the
+// shared one, if any, then those of the isolated pipelines.
+func consumers(cfg *config.Config) []*config.Config {
+	shared, isolated := cfg.Split()
+	if shared == nil {
+		return isolated
+	}
+	return append([]*config.Config{shared}, isolated...)
+}
+
+// report prints one line per check.
+type report struct {
+	w                     io.Writer
+	total, failed, warned int
+}
+
+func (r *report) line(status, name, detail string) {
+	r.total++
+	fmt.Fprintf(r.w, "  %-4s  %-32s %s\n", status, name, detail)
+}
+
+func (r *report) pass(name, format string, args ...any) {
+	r.line("PASS", name, fmt.Sprintf(format, args...))
+}
+
+func (r *report) warn(name, format string, args ...any) {
+	r.warned++
+	r.line("WARN", name, fmt.Sprintf(format, args...))
+}
+
+func (r *report) fail(name string, err error) {
+	r.failed++
+	r.line("FAIL", name, err.Error())
+}
+
+// doctor runs the checks of one consumer.
+type doctor struct {
+	cfg     *config.Config
+	timeout time.Duration
+	report  *report
+
+	// topics are the topics the consumer reads: those that exist, or the
+	// configured ones if Kafka could not be asked.
+	topics []string
+}
+
+func (d *doctor) run(ctx context.Context) {
+	d.checkKafka(ctx)
+	d.checkES(ctx)
+}
+
+// checkKafka checks the brokers, the topics and the consumer group.
+func (d *doctor) checkKafka(ctx context.Context) {
+	d.topics = d.cfg.SubscribedTopics()
+	for t := range d.cfg.Kafka.StaticPartitions {
+		if !slices.Contains(d.topics, t) {
+			d.topics = append(d.topics, t)
+		}
+	}
+	cc, err := app.ConsumerConfig(d.cfg)
+	if err != nil {
+		d.report.fail("kafka config", err)
+		return
+	}
+	probe := kafka.NewProbe(cc)
+	cctx, cancel := context.WithTimeout(ctx, d.timeout)
+	cluster, err := probe.Cluster(cctx)
+	cancel()
+	if err != nil {
+		d.report.fail("kafka brokers", fmt.Errorf("%s: %w", strings.Join(cc.Brokers, ","), err))
+		return
+	}
+	d.report.pass("kafka brokers", "%d brokers, controller %s", cluster.Brokers, cluster.Controller)
+
+	partitions := make(map[string][]int)
+	reported := make(map[string]bool)
+	topic := func(name, kind string) bool {
+		ids, ok := cluster.Partitions[name]
+		if reported[name] {
+			return ok
+		}
+		reported[name] = true
+		if !ok {
+			d.report.fail(kind+" "+name, errors.New("does not exist or is not authorized"))
+			return false
+		}
+		d.report.pass(kind+" "+name, "%d partitions", len(ids))
+		return true
+	}
+	for _, t := range d.cfg.SubscribedTopics() {
+		if topic(t, "topic") {
+			partitions[t] = cluster.Partitions[t]
+		}
+	}
+	for _, pattern := range d.cfg.TopicPatterns() {
+		matched := cluster.Match(pattern)
+		for _, t := range matched {
+			partitions[t] = cluster.Partitions[t]
+		}
+		if len(matched) == 0 {
+			d.report.fail("topic pattern "+pattern, errors.New("matches no topic"))
+			continue
+		}
+		d.report.pass("topic pattern "+pattern, "matches %s", strings.Join(matched, ", "))
+	}
+	for _, t := range sortedKeys(d.cfg.Kafka.StaticPartitions) {
+		want := d.cfg.Kafka.StaticPartitions[t]
+		if !topic(t, "topic") {
+			continue
+		}
+		var ids []int
+		for _, p := range want {
+			if !slices.Contains(cluster.Partitions[t], p) {
+				d.report.fail("static partitions "+t, fmt.Errorf("partition %d does not exist", p))
+				ids = nil
+				break
+			}
+			ids = append(ids, p)
+		}
+		if ids != nil {
+			partitions[t] = ids
+		}
+	}
+	for _, t := range dlqTopics(d.cfg) {
+		topic(t, "dead letter topic")
+	}
+	if d.cfg.Control.Topic != "" {
+		topic(d.cfg.Control.Topic, "control topic")
+	}
+	if d.cfg.Control.StatusTopic != "" {
+		topic(d.cfg.Control.StatusTopic, "status topic")
+	}
+	d.topics = sortedKeys(partitions)
+
+	if len(d.cfg.Kafka.StaticPartitions) > 0 || len(partitions) == 0 {
+		return
+	}
+	cctx, cancel = context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	name := "group " + d.cfg.Kafka.GroupID
+	lags, err := probe.GroupLag(cctx, d.cfg.Kafka.GroupID, partitions)
+	if err != nil {
+		d.report.fail(name, err)
+		return
+	}
+	var total int64
+	var uncommitted int
+	for _, l := range lags {
+		total += l.Lag()
+		if l.Committed < 0 {
+			uncommitted++
+		}
+	}
+	if uncommitted > 0 {
+		d.report.pass(name, "lag %d on %d partitions, %d without a committed offset", total, len(lags), uncommitted)
+		return
+	}
+	d.report.pass(name, "lag %d on %d partitions", total, len(lags))
+}
+
+// checkES checks the cluster, the privileges on the target indices and the
+// templates and ingest pipelines they use.
+func (d *doctor) checkES(ctx context.Context) {
+	es, err := esclient.New(d.cfg.ES)
+	if err != nil {
+		d.report.fail("elasticsearch config", err)
+		return
+	}
+	cctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	info, err := esclient.Info(cctx, es)
+	if err != nil {
+		d.report.fail("elasticsearch", err)
+		return
+	}
+	d.report.pass("elasticsearch", "cluster %s, version %s", info.Name, info.Version)
+
+	switch h, err := esclient.ClusterHealth(cctx, es); {
+	case err != nil:
+		d.report.fail("cluster health", err)
+	case h.Status == "red":
+		d.report.fail("cluster health", fmt.Errorf("red: %d unassigned shards on %d nodes", h.UnassignedShards, h.Nodes))
+	case h.Status == "yellow":
+		d.report.warn("cluster health", "yellow: %d unassigned shards on %d nodes", h.UnassignedShards, h.Nodes)
+	default:
+		d.report.pass("cluster health", "%s, %d nodes", h.Status, h.Nodes)
+	}
+
+	privileges := d.indices()
+	d.checkPrivileges(ctx, es, privileges)
+	d.checkTemplates(ctx, es, privileges)
+}
+
+// indices returns the privileges the consumer needs on every index it
+// writes to. Index templates are expanded for the current time.
+func (d *doctor) indices() map[string][]string {
+	m := mapper.New(d.cfg.Mappings)
+	now := time.Now()
+	out := make(map[string][]string)
+	add := func(index string, privs ...string) {
+		for _, p := range privs {
+			if !slices.Contains(out[index], p) {
+				out[index] = append(out[index], p)
+			}
+		}
+	}
+	for _, t := range d.topics {
+		pl := d.cfg.PipelineFor(t)
+		index := m.IndexForTopic(t)
+		if pl.Index != "" {
+			index = worker.ExpandIndex(pl.Index, &kafka.Message{Topic: t, Time: now})
+		}
+		add(index, actionPrivilege(pl.Action))
+	}
+	if cp := d.cfg.Kafka.Checkpoint; cp.Store == config.CheckpointElasticsearch && (cp.ExactlyOnce || len(d.cfg.Kafka.StaticPartitions) > 0) {
+		add(cp.Index, "read", "index")
+	}
+	return out
+}
+
+// actionPrivilege returns the index privilege a bulk action needs.
+func actionPrivilege(action string) string {
+	switch action {
+	case indexer.ActionCreate:
+		return "create_doc"
+	case indexer.ActionDelete:
+		return "delete"
+	default:
+		return "index"
+	}
+}
+
+func (d *doctor) checkPrivileges(ctx context.Context, es *elasticsearch.Client, privileges map[string][]string) {
+	if len(privileges) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	missing, err := esclient.MissingPrivileges(ctx, es, privileges)
+	if errors.Is(err, esclient.ErrSecurityDisabled) {
+		d.report.warn("index privileges", "security is disabled, every index is writable")
+		return
+	}
+	if err != nil {
+		d.report.fail("index privileges", err)
+		return
+	}
+	for _, index := range sortedKeys(privileges) {
+		if lacking := missing[index]; len(lacking) > 0 {
+			d.report.fail("write "+index, fmt.Errorf("missing privileges: %s", strings.Join(lacking, ", ")))
+			continue
+		}
+		d.report.pass("write "+index, "%s", strings.Join(privileges[index], ", "))
+	}
+}
+
+// checkTemplates reports the template each missing index will be created
+// from and checks that the ingest pipelines of the indices and templates
+// exist.
+func (d *doctor) checkTemplates(ctx context.Context, es *elasticsearch.Client, indices map[string][]string) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	usedBy := make(map[string][]string) // pipeline -> indices
+	for _, index := range sortedKeys(indices) {
+		name := "template " + index
+		exists, err := esclient.IndexExists(ctx, es, index)
+		if err != nil {
+			d.report.fail(name, err)
+			continue
+		}
+		var pipelines []string
+		if exists {
+			if pipelines, err = esclient.IndexPipelines(ctx, es, index); err != nil {
+				d.report.fail(name, err)
+				continue
+			}
+			d.report.pass(name, "index exists")
+		} else {
+			t, err := esclient.MatchingTemplate(ctx, es, index)
+			switch {
+			case err != nil:
+				d.report.fail(name, err)
+				continue
+			case t == nil:
+				d.report.warn(name, "no index template matches, the index will get dynamic mappings")
+			default:
+				d.report.pass(name, "created from %s (%s)", t.Name, strings.Join(t.Patterns, ", "))
+				pipelines = t.Pipelines
+			}
+		}
+		for _, p := range pipelines {
+			usedBy[p] = append(usedBy[p], index)
+		}
+	}
+	if len(usedBy) == 0 {
+		return
+	}
+	ids := sortedKeys(usedBy)
+	missing, err := esclient.MissingPipelines(ctx, es, ids)
+	if err != nil {
+		d.report.fail("ingest pipelines", err)
+		return
+	}
+	for _, id := range ids {
+		if slices.Contains(missing, id) {
+			d.report.fail("ingest pipeline "+id, fmt.Errorf("does not exist, used by %s", strings.Join(usedBy[id], ", ")))
+			continue
+		}
+		d.report.pass("ingest pipeline "+id, "used by %s", strings.Join(usedBy[id], ", "))
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
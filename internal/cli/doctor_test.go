@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDoctorReport(t *testing.T) {
+	es := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/":
+			_, _ = w.Write([]byte(`{"cluster_name":"logs","version":{"number":"8.14.0"}}`))
+		case r.URL.Path == "/_cluster/health":
+			_, _ = w.Write([]byte(`{"status":"yellow","number_of_nodes":1,"unassigned_shards":2}`))
+		case r.URL.Path == "/_security/user/_has_privileges":
+			_, _ = w.Write([]byte(`{"index":{"orders-v1":{"index":true},"users":{"index":false}}}`))
+		case r.Method == http.MethodHead:
+			if r.URL.Path != "/orders-v1" {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case r.URL.Path == "/orders-v1/_settings":
+			_, _ = w.Write([]byte(`{"orders-v1":{"settings":{"index.default_pipeline":"geoip"}}}`))
+		case r.URL.Path == "/_index_template":
+			_, _ = w.Write([]byte(`{"index_templates":[]}`))
+		case r.URL.Path == "/_ingest/pipeline/geoip":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer es.Close()
+	path := writeConfig(t, "kafka:\n  brokers: [127.0.0.1:1]\n  group_id: g\n  topics: [orders, users]\n  dial_timeout: 200ms\nes:\n  addresses: ["+es.URL+"]\nmappings:\n  orders: orders-v1\n")
+
+	var stdout, stderr bytes.Buffer
+	if code := Main([]string{"doctor", "-config", path, "-timeout", "2s"}, &stdout, &stderr); code != ExitFailure {
+		t.Errorf("exit %d, want %d\n%s", code, ExitFailure, stderr.String())
+	}
+	out := stdout.String()
+	for _, want := range []string{
+		"consumer default",
+		"FAIL  kafka brokers",
+		"PASS  elasticsearch                    cluster logs, version 8.14.0",
+		"WARN  cluster health                   yellow: 2 unassigned shards on 1 nodes",
+		"PASS  write orders-v1",
+		"FAIL  write users                      missing privileges: index",
+		"PASS  template orders-v1               index exists",
+		"WARN  template users                   no index template matches",
+		"FAIL  ingest pipeline geoip            does not exist, used by orders-v1",
+		"3 of 8 checks failed",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report does not contain %q:\n%s", want, out)
+		}
+	}
+}
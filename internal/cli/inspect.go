@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+
+	"github.com/gor0utine/kafka-to-es/internal/app"
+	"github.com/gor0utine/kafka-to-es/internal/config"
+	"github.com/gor0utine/kafka-to-es/internal/esclient"
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+	"github.com/gor0utine/kafka-to-es/internal/mapper"
+)
+
+var inspectCommand = &command{
+	name:    "inspect",
+	args:    "topic...",
+	summary: "show the documents that messages would become, without indexing them",
+	help: `
+Reads messages from the topics and runs them through the decoder, transforms,
+index and document ID of their pipeline. Each message is printed as a JSON
+line with the target index, ID, bulk action and document, or the error that
+would send it to the dead letter topic. Nothing is written to Elasticsearch
+and no offsets are committed. Exits with 1 if some message would fail.`,
+	setup: func(fs *flag.FlagSet) runner {
+		from := fs.String("from", "earliest", "start: earliest, latest, an RFC 3339 time or topic:partition=offset,...")
+		n := fs.Int("n", 10, "number of messages to show (0: all up to the current end)")
+		pipeline := fs.String("pipeline", config.DefaultPipelineName, "use this pipeline's consumer (default: the shared consumer)")
+		return func(ctx context.Context, e *env) int {
+			if len(e.args) == 0 {
+				return e.usage("no topic given")
+			}
+			start, err := kafka.ParseOffsetSpec(*from, kafka.FirstOffset)
+			if err != nil {
+				return e.usage("-from: %v", err)
+			}
+			cfg, code := e.config()
+			if cfg == nil {
+				return code
+			}
+			if cfg, err = cfg.Consumer(*pipeline); err != nil {
+				return e.usage("-pipeline: %v", err)
+			}
+			return inspect(ctx, e, cfg, e.args, start, *n)
+		}
+	},
+}
+
+// inspection is the output line of one message.
+type inspection struct {
+	Topic     string          `json:"topic"`
+	Partition int             `json:"partition"`
+	Offset    int64           `json:"offset"`
+	Key       string          `json:"key,omitempty"`
+	Pipeline  string          `json:"pipeline"`
+	Index     string          `json:"index,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Action    string          `json:"action,omitempty"`
+	Document  json.RawMessage `json:"document,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+func inspect(ctx context.Context, e *env, cfg *config.Config, topics []string, start kafka.OffsetSpec, n int) int {
+	es, err := esclient.New(cfg.ES)
+	if err != nil {
+		log.Printf("es client: %v", err)
+		return ExitFailure
+	}
+	cc, err := app.ConsumerConfig(cfg)
+	if err != nil {
+		log.Printf("kafka config: %v", err)
+		return ExitFailure
+	}
+	router, err := app.NewRouter(cfg, es, mapper.New(cfg.Mappings))
+	if err != nil {
+		log.Printf("worker config: %v", err)
+		return ExitFailure
+	}
+	defer router.Close(context.Background())
+
+	enc := json.NewEncoder(e.stdout)
+	failed := false
+	err = readTopics(ctx, cc, topics, start, n, func(msg *kafka.Message) error {
+		p := router.For(msg.Topic)
+		out := inspection{
+			Topic:     msg.Topic,
+			Partition: msg.Partition,
+			Offset:    msg.Offset,
+			Key:       string(msg.Key),
+			Pipeline:  p.Name,
+		}
+		item, err := p.Pool.Item(msg)
+		if err != nil {
+			out.Error = err.Error()
+			failed = true
+		} else {
+			out.Index, out.ID, out.Action, out.Document = item.Index, item.ID, item.Action, item.Body
+		}
+		return enc.Encode(out)
+	})
+	if err != nil {
+		log.Printf("inspect: %v", err)
+		return ExitFailure
+	}
+	if failed {
+		return ExitFailure
+	}
+	return ExitOK
+}
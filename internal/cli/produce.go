@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+
+	"github.com/gor0utine/kafka-to-es/internal/app"
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+)
+
+var produceCommand = &command{
+	name:    "produce",
+	summary: "produce demo events to the configured topics",
+	help: `
+Sends a JSON event to a random topic every -interval until interrupted or
+-count events have been sent. Meant for local testing.`,
+	setup: func(fs *flag.FlagSet) runner {
+		topics := fs.String("topics", "", "comma-separated topics to produce to (default: kafka.topics)")
+		interval := fs.Duration("interval", time.Second, "time between events")
+		count := fs.Int("count", 0, "stop after this many events (0: until interrupted)")
+		return func(ctx context.Context, e *env) int {
+			if len(e.args) > 0 {
+				return e.usage("unexpected arguments %q", e.args)
+			}
+			if *interval <= 0 {
+				return e.usage("-interval must be positive")
+			}
+			cfg, code := e.config()
+			if cfg == nil {
+				return code
+			}
+			logConfig(cfg)
+			names := cfg.Kafka.Topics
+			if *topics != "" {
+				names = strings.Split(*topics, ",")
+			}
+			if len(names) == 0 {
+				return e.usage("no topics: set kafka.topics or -topics")
+			}
+			cc, err := app.ConsumerConfig(cfg)
+			if err != nil {
+				log.Printf("kafka config: %v", err)
+				return ExitFailure
+			}
+			writers := createWriters(cc.Brokers, names, kafka.NewTransport(cc.TLS, cc.SASL, cc.Timeouts))
+			defer closeWriters(writers)
+			return produce(ctx, writers, names, *interval, *count)
+		}
+	},
+}
+
+// Event represents a message to be sent to Kafka.
+type Event struct {
+	ID        int       `json:"id"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// produce sends an event to a random topic every interval until ctx is done
+// or count events have been sent, if count is positive.
+func produce(ctx context.Context, writers map[string]*kafkago.Writer, topics []string, interval time.Duration, count int) int {
+	log.Println("Kafka producer started. Sending messages...")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	failed := false
+	for id := 1; count <= 0 || id <= count; id++ {
+		select {
+		case <-ctx.Done():
+			log.Println("Producer shutting down.")
+			return ExitOK
+		case <-ticker.C:
+		}
+		topic := topics[rand.IntN(len(topics))]
+		event := Event{
+			ID:        id,
+			Message:   fmt.Sprintf("Hello from %s!", topic),
+			Timestamp: time.Now(),
+		}
+		value, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("Failed to marshal event: %v", err)
+			failed = true
+			continue
+		}
+		err = writers[topic].WriteMessages(ctx, kafkago.Message{
+			Key:   []byte(fmt.Sprintf("%d", event.ID)),
+			Value: value,
+		})
+		if err != nil {
+			log.Printf("Failed to write message: %v", err)
+			failed = true
+		} else {
+			log.Printf("Produced to %s: %+v", topic, event)
+		}
+	}
+	if failed {
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// createWriters initializes a writer for each topic.
+func createWriters(brokers, topics []string, transport *kafkago.Transport) map[string]*kafkago.Writer {
+	writers := make(map[string]*kafkago.Writer, len(topics))
+	for _, t := range topics {
+		writers[t] = &kafkago.Writer{
+			Addr:         kafkago.TCP(brokers...),
+			Topic:        t,
+			Balancer:     &kafkago.LeastBytes{},
+			RequiredAcks: kafkago.RequireAll,
+			Transport:    transport,
+		}
+	}
+	return writers
+}
+
+// closeWriters closes all Kafka writers.
+func closeWriters(writers map[string]*kafkago.Writer) {
+	var wg sync.WaitGroup
+	for _, w := range writers {
+		wg.Add(1)
+		go func(writer *kafkago.Writer) {
+			defer wg.Done()
+			_ = writer.Close()
+		}(w)
+	}
+	wg.Wait()
+}
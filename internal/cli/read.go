@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+)
+
+// readTopics reads topics from start up to a snapshot of their end offsets
+// and calls fn for each message, one at a time, until limit messages have
+// been read if limit is positive. Partitions are read concurrently, so the
+// messages of different partitions interleave. It stops at the first error
+// of fn and returns it.
+func readTopics(ctx context.Context, cc kafka.ConsumerConfig, topics []string, start kafka.OffsetSpec, limit int, fn func(*kafka.Message) error) error {
+	cc.Topics = topics
+	cc.StaticPartitions = nil
+	cc.Flow = nil
+	bc := kafka.NewBoundedConsumer(cc)
+	ranges, err := bc.Resolve(ctx, start, kafka.OffsetSpec{Position: kafka.LastOffset})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s := &readSink{fn: fn, limit: limit, stop: cancel}
+	_, err = bc.Run(ctx, ranges, s)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return s.err
+	}
+	return err
+}
+
+// readSink hands messages to fn and cancels the read once it has seen
+// limit messages or fn fails.
+type readSink struct {
+	fn    func(*kafka.Message) error
+	limit int
+	stop  context.CancelFunc
+
+	mu      sync.Mutex
+	n       int
+	stopped bool
+	err     error
+}
+
+// Put implements kafka.Sink.
+func (s *readSink) Put(ctx context.Context, msg *kafka.Message) error {
+	defer msg.Release()
+	defer msg.Ack()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return context.Canceled
+	}
+	if err := s.fn(msg); err != nil {
+		s.stopped, s.err = true, err
+		s.stop()
+		return err
+	}
+	s.n++
+	if s.limit > 0 && s.n >= s.limit {
+		s.stopped = true
+		s.stop()
+	}
+	return nil
+}
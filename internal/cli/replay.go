@@ -0,0 +1,318 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+
+	"github.com/gor0utine/kafka-to-es/internal/app"
+	"github.com/gor0utine/kafka-to-es/internal/config"
+	"github.com/gor0utine/kafka-to-es/internal/esclient"
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+	"github.com/gor0utine/kafka-to-es/internal/mapper"
+)
+
+const (
+	// ackTimeout bounds the wait for Elasticsearch to acknowledge a pass.
+	ackTimeout = 5 * time.Minute
+	// maxPasses limits the catch-up passes before giving up on the swap.
+	maxPasses = 20
+)
+
+var replayCommand = &command{
+	name:    "replay",
+	summary: "re-index topics into new versioned indices and swap their aliases",
+	help: `
+Re-indexes topics from a point in time into new indices named
+<alias>-<version> and, once they have caught up with the live topics,
+atomically points each topic's alias at its new index. The alias is the
+index the live consumer writes to.`,
+	setup: func(fs *flag.FlagSet) runner {
+		from := fs.String("from", "earliest", "replay start: earliest, an RFC 3339 time or topic:partition=offset,...")
+		topics := fs.String("topics", "", "comma-separated topics to replay (default: all configured topics)")
+		pipeline := fs.String("pipeline", config.DefaultPipelineName, "replay the topics of this pipeline's consumer (default: the shared consumer)")
+		version := fs.String("version", time.Now().UTC().Format("20060102150405"), "suffix of the new indices")
+		swap := fs.Bool("swap", true, "swap the aliases to the new indices once caught up")
+		replaceIndex := fs.Bool("replace-index", false, "delete a concrete index that has the alias name when swapping")
+		return func(ctx context.Context, e *env) int {
+			if len(e.args) > 0 {
+				return e.usage("unexpected arguments %q", e.args)
+			}
+			start, err := kafka.ParseOffsetSpec(*from, kafka.FirstOffset)
+			if err != nil {
+				return e.usage("-from: %v", err)
+			}
+			cfg, code := e.config()
+			if cfg == nil {
+				return code
+			}
+			logConfig(cfg)
+			consumerCfg, err := cfg.Consumer(*pipeline)
+			if err != nil {
+				return e.usage("-pipeline: %v", err)
+			}
+			r := &replay{
+				cfg:          consumerCfg,
+				version:      *version,
+				swap:         *swap,
+				replaceIndex: *replaceIndex,
+			}
+			if *topics != "" {
+				r.topics = strings.Split(*topics, ",")
+			}
+			return r.run(ctx, e, start)
+		}
+	},
+}
+
+// replay holds the state of one replay run.
+type replay struct {
+	cfg          *config.Config
+	version      string
+	swap         bool
+	replaceIndex bool
+	topics       []string // overrides the configured topics
+
+	es      *elasticsearch.Client
+	aliases map[string]string // topic -> alias
+	indices map[string]string // topic -> versioned index
+}
+
+// run replays the configured topics and returns the exit code.
+func (r *replay) run(ctx context.Context, e *env, start kafka.OffsetSpec) int {
+	es, err := esclient.New(r.cfg.ES)
+	if err != nil {
+		log.Printf("es client: %v", err)
+		return ExitFailure
+	}
+	r.es = es
+	consumerCfg, err := app.ConsumerConfig(r.cfg)
+	if err != nil {
+		log.Printf("kafka config: %v", err)
+		return ExitFailure
+	}
+
+	if r.topics != nil {
+		consumerCfg.Topics = r.topics
+	} else if err := app.ResolveTopics(ctx, r.cfg, &consumerCfg); err != nil {
+		log.Print(err)
+		return ExitFailure
+	}
+	live := mapper.New(r.cfg.Mappings)
+	r.aliases, r.indices, err = versionedIndices(r.cfg, consumerCfg.Topics, live, r.version)
+	if err != nil {
+		return e.usage("%v", err)
+	}
+	router, err := app.NewRouter(r.cfg, es, live, app.WithIndexMapper(mapper.New(r.indices)))
+	if err != nil {
+		log.Printf("worker config: %v", err)
+		return ExitFailure
+	}
+
+	for _, topic := range consumerCfg.Topics {
+		if err := esclient.CreateIndex(ctx, es, r.indices[topic]); err != nil {
+			log.Print(err)
+			return ExitFailure
+		}
+		log.Printf("replaying %s into %s (alias %s)", topic, r.indices[topic], r.aliases[topic])
+	}
+
+	began := time.Now()
+	router.Start(ctx)
+	bc := kafka.NewBoundedConsumer(consumerCfg)
+	progress, runErr := r.catchUp(ctx, bc, router, start)
+	router.CloseInput()
+	router.Wait()
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	if err := router.Close(flushCtx); err != nil {
+		log.Printf("error closing bulker: %v", err)
+		runErr = errors.Join(runErr, err)
+	}
+
+	printReplaySummary(e.stdout, progress, time.Since(began))
+	if runErr != nil {
+		log.Printf("replay failed: %v", runErr)
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// catchUp consumes from start to the end of every partition in passes,
+// re-reading the end offsets after each one, until a pass leaves nothing to
+// replay. Only then are the aliases swapped: a pass after the swap would
+// write messages the live consumer may already have overwritten with newer
+// ones. It returns the accumulated progress.
+func (r *replay) catchUp(ctx context.Context, bc *kafka.BoundedConsumer, sink kafka.Sink, start kafka.OffsetSpec) ([]*kafka.RangeProgress, error) {
+	var total []*kafka.RangeProgress
+	end := kafka.OffsetSpec{Position: kafka.LastOffset}
+	for pass := 1; ; pass++ {
+		ranges, err := bc.Resolve(ctx, start, end)
+		if err != nil {
+			return total, fmt.Errorf("resolve offsets: %w", err)
+		}
+		lag := remaining(ranges)
+		if lag == 0 {
+			if !r.swap {
+				return total, nil
+			}
+			return total, r.swapAliases(ctx)
+		}
+		if pass > maxPasses {
+			return total, fmt.Errorf("still %d messages behind after %d passes, aliases not swapped", lag, maxPasses)
+		}
+
+		log.Printf("pass %d: %d messages to replay", pass, lag)
+		progress, err := bc.Run(ctx, ranges, sink)
+		total = append(total, progress...)
+		if err != nil {
+			return total, err
+		}
+		if err := waitAcked(ctx, progress); err != nil {
+			return total, err
+		}
+		if !r.swap {
+			return total, nil
+		}
+		start = nextStart(ranges)
+	}
+}
+
+// swapAliases points every alias at its versioned index in one atomic
+// request.
+func (r *replay) swapAliases(ctx context.Context) error {
+	targets := make(map[string]string, len(r.aliases))
+	for topic, alias := range r.aliases {
+		targets[alias] = r.indices[topic]
+	}
+	previous, err := esclient.SwapAliases(ctx, r.es, targets, r.replaceIndex)
+	if err != nil {
+		if errors.Is(err, esclient.ErrIndexNotAlias) {
+			return fmt.Errorf("swap aliases: %w (use -replace-index to delete it)", err)
+		}
+		return fmt.Errorf("swap aliases: %w", err)
+	}
+	for alias, index := range targets {
+		log.Printf("alias %s now points to %s (was %v)", alias, index, previous[alias])
+	}
+	return nil
+}
+
+// versionedIndices returns the alias and the new index of every topic. The
+// alias is the index the live consumer writes to: the topic's pipeline index
+// or its mapping. Index templates with placeholders cannot be replayed, as
+// they spread a topic over several indices.
+func versionedIndices(cfg *config.Config, topics []string, m *mapper.Mapper, version string) (aliases, indices map[string]string, err error) {
+	aliases = make(map[string]string, len(topics))
+	indices = make(map[string]string, len(topics))
+	for _, topic := range topics {
+		alias := cfg.PipelineFor(topic).Index
+		if strings.Contains(alias, "{") {
+			return nil, nil, fmt.Errorf("topic %s: cannot replay into index template %q", topic, alias)
+		}
+		if alias == "" {
+			alias = m.IndexForTopic(topic)
+		}
+		aliases[topic] = alias
+		indices[topic] = alias + "-" + version
+	}
+	return aliases, indices, nil
+}
+
+// remaining returns the number of offsets left across ranges.
+func remaining(ranges []kafka.PartitionRange) int64 {
+	var n int64
+	for _, r := range ranges {
+		if r.End > r.Start {
+			n += r.End - r.Start
+		}
+	}
+	return n
+}
+
+// nextStart returns a spec that resumes every partition at the end of its
+// range. Partitions created since start from the beginning.
+func nextStart(ranges []kafka.PartitionRange) kafka.OffsetSpec {
+	spec := kafka.OffsetSpec{Position: kafka.FirstOffset, Offsets: make(map[string]map[int]int64)}
+	for _, r := range ranges {
+		if spec.Offsets[r.Topic] == nil {
+			spec.Offsets[r.Topic] = make(map[int]int64)
+		}
+		spec.Offsets[r.Topic][r.Partition] = r.End
+	}
+	return spec
+}
+
+// waitAcked blocks until every consumed message of a pass has been
+// acknowledged by the indexer.
+func waitAcked(ctx context.Context, progress []*kafka.RangeProgress) error {
+	ctx, cancel := context.WithTimeout(ctx, ackTimeout)
+	defer cancel()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		pending := false
+		for _, p := range progress {
+			if p.Acked.Load() < p.Consumed.Load() {
+				pending = true
+				break
+			}
+		}
+		if !pending {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for acknowledgements: %w", ctx.Err())
+		}
+	}
+}
+
+// printReplaySummary writes the totals of every partition across all passes.
+func printReplaySummary(w io.Writer, progress []*kafka.RangeProgress, elapsed time.Duration) {
+	type row struct {
+		topic           string
+		partition       int
+		start, end      int64
+		consumed, acked int64
+	}
+	rows := make(map[string]*row)
+	var keys []string
+	for _, p := range progress {
+		k := fmt.Sprintf("%s/%d", p.Topic, p.Partition)
+		rw, ok := rows[k]
+		if !ok {
+			rw = &row{topic: p.Topic, partition: p.Partition, start: p.Start}
+			rows[k] = rw
+			keys = append(keys, k)
+		}
+		rw.end = p.End
+		rw.consumed += p.Consumed.Load()
+		rw.acked += p.Acked.Load()
+	}
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TOPIC\tPARTITION\tSTART\tEND\tCONSUMED\tACKED")
+	var consumed, acked int64
+	for _, k := range keys {
+		rw := rows[k]
+		consumed += rw.consumed
+		acked += rw.acked
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\n", rw.topic, rw.partition, rw.start, rw.end, rw.consumed, rw.acked)
+	}
+	fmt.Fprintf(tw, "TOTAL\t\t\t\t%d\t%d\n", consumed, acked)
+	tw.Flush()
+	fmt.Fprintf(w, "finished in %s\n", elapsed.Round(time.Millisecond))
+}
@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/gor0utine/kafka-to-es/internal/config"
+)
+
+var validateCommand = &command{
+	name:    "validate-config",
+	summary: "check the configuration file without connecting to anything",
+	help: `
+Checks the configuration file, including KTE_* environment overrides, and
+exits with 3 if it has any problem. It does not connect to Kafka or
+Elasticsearch. With -schema it prints the JSON Schema of the configuration
+file instead.`,
+	setup: func(fs *flag.FlagSet) runner {
+		quiet := fs.Bool("q", false, "print nothing when the configuration is valid")
+		schema := fs.Bool("schema", false, "print the JSON Schema of the configuration file and exit")
+		return func(_ context.Context, e *env) int {
+			if len(e.args) > 0 {
+				return e.usage("unexpected arguments %q", e.args)
+			}
+			if *schema {
+				b, err := config.JSONSchema()
+				if err != nil {
+					fmt.Fprintln(e.stderr, err)
+					return ExitFailure
+				}
+				_, _ = e.stdout.Write(b)
+				return ExitOK
+			}
+			if _, code := e.config(); code != ExitOK {
+				return code
+			}
+			if !*quiet {
+				fmt.Fprintf(e.stdout, "%s: ok\n", e.configPath)
+			}
+			return ExitOK
+		}
+	},
+}
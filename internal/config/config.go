@@ -3,6 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"path"
+	"reflect"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -14,33 +17,618 @@ type Config struct {
 	ES       ESConfig          `yaml:"es"`
 	Mappings map[string]string `yaml:"mappings"`
 	Worker   WorkerConfig      `yaml:"worker"`
+	Flow     FlowConfig        `yaml:"flow"`
+	Metrics  MetricsConfig     `yaml:"metrics"`
+	Admin    AdminConfig       `yaml:"admin"`
+	Control  ControlConfig     `yaml:"control"`
+	Reload   ReloadConfig      `yaml:"reload"`
+	// Pipelines route topics through their own worker pool and bulk
+	// indexer. Topics not claimed by a pipeline use the worker section and
+	// Mappings.
+	Pipelines []PipelineConfig `yaml:"pipelines"`
+
+	// name is the pipeline whose consumer this configuration describes,
+	// set by Split.
+	name string
+	// file and lines locate values for validation errors.
+	file  string
+	lines map[string]int
 }
 
 // KafkaConfig holds Kafka connection and consumer settings.
 type KafkaConfig struct {
-	Brokers []string `yaml:"brokers"`
-	GroupID string   `yaml:"group_id"`
-	Topics  []string `yaml:"topics"`
+	Brokers []string   `yaml:"brokers"`
+	GroupID string     `yaml:"group_id"`
+	Topics  []string   `yaml:"topics"`
+	TLS     TLSConfig  `yaml:"tls"`
+	SASL    SASLConfig `yaml:"sasl"`
+
+	// ReaderTuning applies to all topics; TopicOverrides replaces individual
+	// settings per topic.
+	ReaderTuning   `yaml:",inline"`
+	TopicOverrides map[string]ReaderTuning `yaml:"topic_overrides"`
+	// RetryInterval is the pause before a failed fetch or commit is retried.
+	RetryInterval   time.Duration `yaml:"retry_interval"`
+	RetryIntervalMs int           `yaml:"retry_interval_ms"`
+	// DialTimeout bounds connecting to a broker, including the TLS and SASL
+	// handshakes (default 10s).
+	DialTimeout time.Duration `yaml:"dial_timeout"`
+	// RequestTimeout bounds metadata and offset lookups (default 10s).
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// WriteTimeout bounds writes to dead letter topics (default 10s).
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	// SingleGroup consumes all topics through one group member instead of
+	// one member per topic.
+	SingleGroup bool `yaml:"single_group"`
+	// CommitThreshold commits acknowledged offsets early once this many
+	// messages have been processed since the last commit.
+	CommitThreshold int `yaml:"commit_threshold"`
+	// StaticPartitions pins this instance to the listed partitions of each
+	// topic instead of joining group_id. Offsets are kept in Checkpoint.
+	StaticPartitions map[string][]int `yaml:"static_partitions"`
+	Checkpoint       CheckpointConfig `yaml:"checkpoint"`
+}
+
+// CheckpointConfig selects where offsets of static partitions are stored.
+type CheckpointConfig struct {
+	// Store is "file" or "elasticsearch".
+	Store string `yaml:"store"`
+	// Path is the checkpoint file for the file store.
+	Path string `yaml:"path"`
+	// Index is the checkpoint index for the elasticsearch store.
+	Index string `yaml:"index"`
+	// Name identifies this consumer's checkpoints in a shared index.
+	// Defaults to group_id.
+	Name string `yaml:"name"`
+	// ExactlyOnce writes checkpoints to Index through the bulk indexer,
+	// alongside the documents, and resumes every partition from its
+	// checkpoint instead of the group offset. It requires the elasticsearch
+	// store and a deterministic document_id.
+	ExactlyOnce bool `yaml:"exactly_once"`
+}
+
+// ReaderTuning controls how topics are fetched and committed. Unset values
+// fall back to the global setting, then to the built-in defaults. Each
+// duration also has a legacy key counting milliseconds or seconds.
+type ReaderTuning struct {
+	// StartOffset is "earliest" or "latest" and applies when the group has
+	// no committed offset.
+	StartOffset   string        `yaml:"start_offset"`
+	MinBytes      ByteSize      `yaml:"min_bytes"`
+	MaxBytes      ByteSize      `yaml:"max_bytes"`
+	MaxWait       time.Duration `yaml:"max_wait"`
+	MaxWaitMs     int           `yaml:"max_wait_ms"`
+	QueueCapacity int           `yaml:"queue_capacity"`
+	// CommitSync commits as soon as a message has been indexed. By default
+	// offsets are batched and committed every CommitInterval (global only)
+	// or after CommitThreshold messages.
+	CommitSync            *bool         `yaml:"commit_sync"`
+	CommitInterval        time.Duration `yaml:"commit_interval"`
+	CommitIntervalMs      int           `yaml:"commit_interval_ms"`
+	SessionTimeout        time.Duration `yaml:"session_timeout"`
+	SessionTimeoutSecs    int           `yaml:"session_timeout_seconds"`
+	HeartbeatInterval     time.Duration `yaml:"heartbeat_interval"`
+	HeartbeatIntervalSecs int           `yaml:"heartbeat_interval_seconds"`
+	RebalanceTimeout      time.Duration `yaml:"rebalance_timeout"`
+	RebalanceTimeoutSecs  int           `yaml:"rebalance_timeout_seconds"`
+	// IsolationLevel is "read_uncommitted" or "read_committed".
+	IsolationLevel string `yaml:"isolation_level"`
+	// PartitionAssignmentStrategy lists "range", "round_robin" or
+	// "rack_affinity" in order of preference.
+	PartitionAssignmentStrategy []string `yaml:"partition_assignment_strategy"`
+}
+
+// Merge returns t with every field that is set in o replaced by o's value.
+func (t ReaderTuning) Merge(o ReaderTuning) ReaderTuning {
+	out := reflect.ValueOf(&t).Elem()
+	ov := reflect.ValueOf(o)
+	for i := 0; i < ov.NumField(); i++ {
+		if !ov.Field(i).IsZero() {
+			out.Field(i).Set(ov.Field(i))
+		}
+	}
+	return t
+}
+
+// withDurations returns t with the durations set from their legacy keys
+// where only those are used, so that merging prefers the more specific
+// setting whichever key it uses.
+func (t ReaderTuning) withDurations() ReaderTuning {
+	t.MaxWait = legacyDuration(t.MaxWait, t.MaxWaitMs, time.Millisecond, 0)
+	t.CommitInterval = legacyDuration(t.CommitInterval, t.CommitIntervalMs, time.Millisecond, 0)
+	t.SessionTimeout = legacyDuration(t.SessionTimeout, t.SessionTimeoutSecs, time.Second, 0)
+	t.HeartbeatInterval = legacyDuration(t.HeartbeatInterval, t.HeartbeatIntervalSecs, time.Second, 0)
+	t.RebalanceTimeout = legacyDuration(t.RebalanceTimeout, t.RebalanceTimeoutSecs, time.Second, 0)
+	return t
+}
+
+// withDurations returns k with the durations set from their legacy keys.
+func (k KafkaConfig) withDurations() KafkaConfig {
+	k.ReaderTuning = k.ReaderTuning.withDurations()
+	if k.TopicOverrides != nil {
+		overrides := make(map[string]ReaderTuning, len(k.TopicOverrides))
+		for topic, t := range k.TopicOverrides {
+			overrides[topic] = t.withDurations()
+		}
+		k.TopicOverrides = overrides
+	}
+	k.RetryInterval = legacyDuration(k.RetryInterval, k.RetryIntervalMs, time.Millisecond, 0)
+	k.SASL.TokenRefresh = legacyDuration(k.SASL.TokenRefresh, k.SASL.TokenRefreshSecs, time.Second, 0)
+	return k
+}
+
+// TuningFor returns the effective reader tuning for a topic.
+func (k KafkaConfig) TuningFor(topic string) ReaderTuning {
+	if o, ok := k.TopicOverrides[topic]; ok {
+		return k.ReaderTuning.Merge(o)
+	}
+	return k.ReaderTuning
+}
+
+// SASLConfig holds Kafka SASL authentication settings.
+type SASLConfig struct {
+	// Mechanism is one of PLAIN, SCRAM-SHA-256, SCRAM-SHA-512 or OAUTHBEARER.
+	Mechanism string `yaml:"mechanism"`
+	Username  string `yaml:"username"`
+	Password  Secret `yaml:"password"`
+	// TokenFile holds the OAUTHBEARER token and is re-read every
+	// TokenRefresh so it can be rotated without a restart.
+	TokenFile        string        `yaml:"token_file"`
+	TokenRefresh     time.Duration `yaml:"token_refresh"`
+	TokenRefreshSecs int           `yaml:"token_refresh_seconds"`
 }
 
 // ESConfig holds Elasticsearch connection settings.
 type ESConfig struct {
 	Addresses []string `yaml:"addresses"`
-	Username  string   `yaml:"username"`
-	Password  string   `yaml:"password"`
+	// CloudID connects to an Elastic Cloud deployment instead of Addresses.
+	CloudID  string `yaml:"cloud_id"`
+	Username string `yaml:"username"`
+	Password Secret `yaml:"password"`
+	// APIKey is the base64-encoded "id:key" pair. It takes precedence over
+	// ServiceToken and basic auth.
+	APIKey       Secret `yaml:"api_key"`
+	ServiceToken Secret `yaml:"service_token"`
+
+	TLS TLSConfig `yaml:"tls"`
+	// CertificateFingerprint pins the server certificate by its SHA-256 hex
+	// fingerprint, as printed by Elasticsearch on first start.
+	CertificateFingerprint string `yaml:"certificate_fingerprint"`
+
+	// CompressRequestBody gzips request bodies, which pays off for bulk
+	// traffic over slow or metered links.
+	CompressRequestBody bool `yaml:"compress_request_body"`
+	CompressionLevel    int  `yaml:"compression_level"`
+
+	MaxIdleConnsPerHost int `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int `yaml:"max_conns_per_host"`
+	// DialTimeout and TLSHandshakeTimeout bound connecting to a node
+	// (default 30s and 10s).
+	DialTimeout               time.Duration `yaml:"dial_timeout"`
+	TLSHandshakeTimeout       time.Duration `yaml:"tls_handshake_timeout"`
+	IdleConnTimeout           time.Duration `yaml:"idle_conn_timeout"`
+	IdleConnTimeoutSecs       int           `yaml:"idle_conn_timeout_seconds"`
+	ResponseHeaderTimeout     time.Duration `yaml:"response_header_timeout"`
+	ResponseHeaderTimeoutSecs int           `yaml:"response_header_timeout_seconds"`
+	RetryOnStatus             []int         `yaml:"retry_on_status"`
+	MaxRetries                int           `yaml:"max_retries"`
+	DisableRetry              bool          `yaml:"disable_retry"`
+	DiscoverNodesOnStart      bool          `yaml:"discover_nodes_on_start"`
+	DiscoverNodesInterval     time.Duration `yaml:"discover_nodes_interval"`
+	DiscoverNodesIntervalSecs int           `yaml:"discover_nodes_interval_seconds"`
+}
+
+// withDurations returns e with the durations set from their legacy keys.
+func (e ESConfig) withDurations() ESConfig {
+	e.IdleConnTimeout = legacyDuration(e.IdleConnTimeout, e.IdleConnTimeoutSecs, time.Second, 0)
+	e.ResponseHeaderTimeout = legacyDuration(e.ResponseHeaderTimeout, e.ResponseHeaderTimeoutSecs, time.Second, 0)
+	e.DiscoverNodesInterval = legacyDuration(e.DiscoverNodesInterval, e.DiscoverNodesIntervalSecs, time.Second, 0)
+	return e
 }
 
 // WorkerConfig holds worker and batching settings.
 type WorkerConfig struct {
 	NumWorkers        int           `yaml:"num_workers"`
 	BatchSize         int           `yaml:"batch_size"`
-	BatchBytes        int           `yaml:"batch_bytes"`
+	BatchBytes        ByteSize      `yaml:"batch_bytes"`
+	FlushInterval     time.Duration `yaml:"flush_interval"`
 	FlushIntervalSecs int           `yaml:"flush_interval_seconds"`
-	FlushInterval     time.Duration `yaml:"-"`
+	// BulkMode selects the indexing backend: "per_index" keeps one
+	// BulkIndexer per index, "shared" sends every index through one stream.
+	BulkMode         string   `yaml:"bulk_mode"`
+	BulkConcurrency  int      `yaml:"bulk_concurrency"`
+	MaxBufferedBytes ByteSize `yaml:"max_buffered_bytes"`
+	// IndexerIdleTTL evicts per-index BulkIndexers that received no items
+	// for this long. Zero keeps them until shutdown.
+	IndexerIdleTTL     time.Duration `yaml:"indexer_idle_ttl"`
+	IndexerIdleTTLSecs int           `yaml:"indexer_idle_ttl_seconds"`
+	// Dispatch routes messages to workers: "shared" (any worker),
+	// "partition" or "key". The last two keep per-partition or per-key
+	// order all the way to Elasticsearch.
+	Dispatch string `yaml:"dispatch"`
+	// DocumentID is "uuid" for a random _id per message, "key" to use the
+	// message key, so the latest message per key wins, or "offset" to derive
+	// it from topic, partition and offset.
+	DocumentID string `yaml:"document_id"`
+	// Decoder is "json", "string" or "base64" and sets how message values
+	// become the document payload.
+	Decoder string `yaml:"decoder"`
+	// Action is the bulk action: "index", "create", "update" (an upsert of
+	// the whole document) or "delete". Update and delete need a
+	// deterministic document_id.
+	Action string `yaml:"action"`
+	// DLQ receives messages that cannot be decoded or indexed.
+	DLQ DLQConfig `yaml:"dlq"`
+	// Scheduling gives every topic its own queue and share of the workers.
+	Scheduling SchedulingConfig `yaml:"scheduling"`
+}
+
+// DLQConfig names the dead letter topic. An empty topic disables it, and
+// failed messages are only logged.
+type DLQConfig struct {
+	Topic string `yaml:"topic"`
+}
+
+// PipelineConfig sends a set of topics through their own worker pool and
+// bulk indexer. Unset values fall back to the worker section.
+type PipelineConfig struct {
+	Name   string   `yaml:"name"`
+	Topics []string `yaml:"topics"`
+	// TopicPatterns are glob patterns, such as "orders.*", matched against
+	// the topics on the cluster at startup.
+	TopicPatterns []string `yaml:"topic_patterns"`
+	// Index is the target index template. It may contain {topic},
+	// {partition} and {date:LAYOUT} with a Go time layout, for example
+	// "logs-{topic}-{date:2006.01.02}". Empty uses Mappings.
+	Index      string            `yaml:"index"`
+	Decoder    string            `yaml:"decoder"`
+	DocumentID string            `yaml:"document_id"`
+	Action     string            `yaml:"action"`
+	Transforms []TransformConfig `yaml:"transforms"`
+
+	NumWorkers        int           `yaml:"num_workers"`
+	BatchBytes        ByteSize      `yaml:"batch_bytes"`
+	FlushInterval     time.Duration `yaml:"flush_interval"`
+	FlushIntervalSecs int           `yaml:"flush_interval_seconds"`
+	DLQ               DLQConfig     `yaml:"dlq"`
+
+	// Kafka and ES isolate the pipeline: it runs its own consumer, flow
+	// control and Elasticsearch client, so it can read another cluster or
+	// group and write to another Elasticsearch, and a pipeline that fails
+	// does not hold up the others. Keys that are not set fall back to the
+	// top-level sections.
+	Kafka *KafkaConfig `yaml:"kafka"`
+	ES    *ESConfig    `yaml:"es"`
+}
+
+// claims reports whether the pipeline consumes topic, by name or pattern.
+func (p PipelineConfig) claims(topic string) bool {
+	for _, t := range p.Topics {
+		if t == topic {
+			return true
+		}
+	}
+	for _, pattern := range p.TopicPatterns {
+		if ok, _ := path.Match(pattern, topic); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Isolated reports whether the pipeline runs its own consumer.
+func (p PipelineConfig) Isolated() bool {
+	return p.Kafka != nil || p.ES != nil
+}
+
+// TransformConfig edits documents before they are indexed. Op is "set"
+// (Field = Value), "remove" (Field) or "rename" (Field to To). Fields are
+// dotted paths such as "payload.user.id".
+type TransformConfig struct {
+	Op    string      `yaml:"op"`
+	Field string      `yaml:"field"`
+	To    string      `yaml:"to"`
+	Value interface{} `yaml:"value"`
+}
+
+// DefaultPipelineName names the pipeline of topics that no pipeline claims.
+const DefaultPipelineName = "default"
+
+// DefaultPipeline returns the pipeline for topics that no entry of Pipelines
+// claims, built from the worker section.
+func (c *Config) DefaultPipeline() PipelineConfig {
+	p := PipelineConfig{Name: DefaultPipelineName}
+	p.inherit(c.Worker)
+	return p
+}
+
+// inherit fills the unset values of p from w.
+func (p *PipelineConfig) inherit(w WorkerConfig) {
+	if p.Decoder == "" {
+		p.Decoder = w.Decoder
+	}
+	if p.DocumentID == "" {
+		p.DocumentID = w.DocumentID
+	}
+	if p.Action == "" {
+		p.Action = w.Action
+	}
+	if p.NumWorkers == 0 {
+		p.NumWorkers = w.NumWorkers
+	}
+	if p.BatchBytes == 0 {
+		p.BatchBytes = w.BatchBytes
+	}
+	p.FlushInterval = legacyDuration(p.FlushInterval, p.FlushIntervalSecs, time.Second, w.FlushInterval)
+	if p.DLQ.Topic == "" {
+		p.DLQ = w.DLQ
+	}
+}
+
+// PipelineFor returns the pipeline that handles topic: the one naming it,
+// else the first one with a matching pattern, else the default pipeline.
+func (c *Config) PipelineFor(topic string) PipelineConfig {
+	for _, p := range c.Pipelines {
+		for _, t := range p.Topics {
+			if t == topic {
+				return p
+			}
+		}
+	}
+	for _, p := range c.Pipelines {
+		for _, pattern := range p.TopicPatterns {
+			if ok, _ := path.Match(pattern, topic); ok {
+				return p
+			}
+		}
+	}
+	return c.DefaultPipeline()
+}
+
+// SubscribedTopics returns kafka.topics followed by the topics named by
+// pipelines, without duplicates. Topics matching pipeline patterns are only
+// known once the cluster is asked.
+func (c *Config) SubscribedTopics() []string {
+	seen := make(map[string]bool)
+	var topics []string
+	add := func(ts []string) {
+		for _, t := range ts {
+			if !seen[t] {
+				seen[t] = true
+				topics = append(topics, t)
+			}
+		}
+	}
+	add(c.Kafka.Topics)
+	for _, p := range c.Pipelines {
+		add(p.Topics)
+	}
+	return topics
+}
+
+// TopicPatterns returns the topic patterns of every pipeline.
+func (c *Config) TopicPatterns() []string {
+	var patterns []string
+	for _, p := range c.Pipelines {
+		patterns = append(patterns, p.TopicPatterns...)
+	}
+	return patterns
+}
+
+// Name returns the pipeline whose consumer c describes: an isolated
+// pipeline for the configurations returned by Split, else the default one.
+func (c *Config) Name() string {
+	if c.name == "" {
+		return DefaultPipelineName
+	}
+	return c.name
+}
+
+// Split divides c into the configurations of the consumers it runs: the
+// shared consumer of kafka.topics and the pipelines that are not isolated,
+// then one consumer per isolated pipeline. shared is nil if no topic is left
+// to it.
+func (c *Config) Split() (shared *Config, isolated []*Config) {
+	s := *c
+	s.Pipelines = nil
+	for _, pl := range c.Pipelines {
+		if pl.Isolated() {
+			isolated = append(isolated, c.standalone(pl))
+		} else {
+			s.Pipelines = append(s.Pipelines, pl)
+		}
+	}
+	if len(s.Kafka.Topics) > 0 || len(s.Kafka.StaticPartitions) > 0 || len(s.Pipelines) > 0 {
+		shared = &s
+	}
+	return shared, isolated
+}
+
+// Consumer returns the configuration of the consumer that runs the named
+// pipeline: its own if the pipeline is isolated, else the shared one.
+func (c *Config) Consumer(name string) (*Config, error) {
+	shared, isolated := c.Split()
+	for _, s := range isolated {
+		if s.name == name {
+			return s, nil
+		}
+	}
+	if name != DefaultPipelineName {
+		found := false
+		for _, pl := range c.Pipelines {
+			found = found || pl.Name == name
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown pipeline %q", name)
+		}
+	}
+	if shared == nil {
+		return nil, fmt.Errorf("no topics outside the isolated pipelines")
+	}
+	return shared, nil
+}
+
+// standalone returns the configuration of isolated pipeline pl: its kafka
+// and es sections merged over the top-level ones, with pl as the only
+// pipeline. Metrics, the admin API and the control topic are served once
+// per process from the top-level configuration.
+func (c *Config) standalone(pl PipelineConfig) *Config {
+	s := *c
+	s.name = pl.Name
+	if pl.Kafka != nil {
+		overlay(reflect.ValueOf(&s.Kafka).Elem(), reflect.ValueOf(pl.Kafka.withDurations()))
+		if pl.Kafka.Checkpoint.Name == "" {
+			s.Kafka.Checkpoint.Name = s.Kafka.GroupID
+		}
+	}
+	if pl.ES != nil {
+		overlay(reflect.ValueOf(&s.ES).Elem(), reflect.ValueOf(pl.ES.withDurations()))
+	}
+	s.Kafka.Topics = nil
+	s.Kafka.StaticPartitions = nil
+	s.Kafka.Checkpoint.ExactlyOnce = false
+	pl.Kafka, pl.ES = nil, nil
+	s.Pipelines = []PipelineConfig{pl}
+	s.Metrics = MetricsConfig{}
+	s.Admin = AdminConfig{}
+	s.Control = ControlConfig{}
+	return &s
+}
+
+// overlay replaces every value of dst that is set in src. Structs are merged
+// field by field and maps key by key; other values, secrets included, are
+// replaced whole.
+func overlay(dst, src reflect.Value) {
+	switch {
+	case src.Kind() == reflect.Struct && src.Type() != secretType:
+		for i := 0; i < src.NumField(); i++ {
+			if src.Type().Field(i).IsExported() {
+				overlay(dst.Field(i), src.Field(i))
+			}
+		}
+	case src.Kind() == reflect.Map:
+		if src.Len() == 0 {
+			return
+		}
+		m := reflect.MakeMap(src.Type())
+		for _, k := range dst.MapKeys() {
+			m.SetMapIndex(k, dst.MapIndex(k))
+		}
+		for _, k := range src.MapKeys() {
+			m.SetMapIndex(k, src.MapIndex(k))
+		}
+		dst.Set(m)
+	case !src.IsZero():
+		dst.Set(src)
+	}
+}
+
+// SchedulingConfig enables per-topic queues between the consumer and the
+// workers. Topics missing from Topics use Default; unset fields of a topic
+// entry fall back to Default as well.
+type SchedulingConfig struct {
+	Enabled bool                     `yaml:"enabled"`
+	Default TopicSchedule            `yaml:"default"`
+	Topics  map[string]TopicSchedule `yaml:"topics"`
+}
+
+// TopicSchedule sets how a topic shares the worker pool.
+type TopicSchedule struct {
+	// Priority serves higher values strictly first.
+	Priority int `yaml:"priority"`
+	// Weight is the share among topics of equal priority (default 1).
+	Weight int `yaml:"weight"`
+	// MaxConcurrency caps the workers busy with this topic (0 = no cap).
+	MaxConcurrency int `yaml:"max_concurrency"`
+	// QueueSize is the number of messages buffered (default 1000).
+	QueueSize int `yaml:"queue_size"`
 }
 
+// FlowConfig bounds the messages fetched from Kafka but not yet indexed. Fetching pauses above either limit and resumes once both are
+// below LowWatermark times the limit.
+type FlowConfig struct {
+	MaxInflightBytes    ByteSize `yaml:"max_inflight_bytes"`
+	MaxInflightMessages int64    `yaml:"max_inflight_messages"`
+	LowWatermark        float64  `yaml:"low_watermark"`
+}
+
+// MetricsConfig holds the metrics endpoint settings.
+type MetricsConfig struct {
+	// Addr is the listen address for /debug/vars. Empty disables the endpoint.
+	Addr string `yaml:"addr"`
+}
+
+// AdminConfig holds the admin API settings.
+type AdminConfig struct {
+	// Addr is the listen address of the admin API. Empty disables it.
+	Addr string `yaml:"addr"`
+	// Token authenticates requests, sent as "Authorization: Bearer <token>".
+	// It is required when Addr is set.
+	Token Secret `yaml:"token"`
+}
+
+// ControlConfig enables the control topic, through which runtime commands
+// reach every instance. It uses the connection settings of the kafka
+// section.
+type ControlConfig struct {
+	// Topic is a compacted topic of commands read in full by every
+	// instance. Empty disables it.
+	Topic string `yaml:"topic"`
+	// StatusTopic receives an acknowledgement of every command applied.
+	// Empty disables acknowledgements.
+	StatusTopic string `yaml:"status_topic"`
+	// InstanceID names this instance in acknowledgements (default: the host
+	// name).
+	InstanceID string `yaml:"instance_id"`
+}
+
+// ReloadConfig controls how a running consumer picks up configuration
+// changes. SIGHUP always triggers a reload.
+type ReloadConfig struct {
+	// Watch polls the config file and reloads it when it changes (default
+	// true).
+	Watch        *bool         `yaml:"watch"`
+	Interval     time.Duration `yaml:"interval"`
+	IntervalSecs int           `yaml:"interval_seconds"`
+}
+
+// Checkpoint stores accepted by CheckpointConfig.Store.
+const (
+	CheckpointFile          = "file"
+	CheckpointElasticsearch = "elasticsearch"
+)
+
+// Bulk modes accepted by WorkerConfig.BulkMode.
+const (
+	BulkModePerIndex = "per_index"
+	BulkModeShared   = "shared"
+)
+
 // SetDefaults sets sensible defaults for missing config values.
 func (c *Config) SetDefaults() {
+	c.Kafka = c.Kafka.withDurations()
+	if c.Kafka.DialTimeout == 0 {
+		c.Kafka.DialTimeout = 10 * time.Second
+	}
+	if c.Kafka.RequestTimeout == 0 {
+		c.Kafka.RequestTimeout = 10 * time.Second
+	}
+	if c.Kafka.WriteTimeout == 0 {
+		c.Kafka.WriteTimeout = 10 * time.Second
+	}
+	if c.Kafka.SASL.TokenRefresh == 0 {
+		c.Kafka.SASL.TokenRefresh = time.Minute
+	}
+	c.ES = c.ES.withDurations()
+	if c.ES.DialTimeout == 0 {
+		c.ES.DialTimeout = 30 * time.Second
+	}
+	if c.ES.TLSHandshakeTimeout == 0 {
+		c.ES.TLSHandshakeTimeout = 10 * time.Second
+	}
+	if c.ES.IdleConnTimeout == 0 {
+		c.ES.IdleConnTimeout = 90 * time.Second
+	}
 	if c.Worker.NumWorkers == 0 {
 		c.Worker.NumWorkers = 4
 	}
@@ -50,22 +638,157 @@ func (c *Config) SetDefaults() {
 	if c.Worker.BatchBytes == 0 {
 		c.Worker.BatchBytes = 5_000_000
 	}
-	if c.Worker.FlushIntervalSecs == 0 {
-		c.Worker.FlushIntervalSecs = 2
+	c.Worker.FlushInterval = legacyDuration(c.Worker.FlushInterval, c.Worker.FlushIntervalSecs, time.Second, 2*time.Second)
+	if c.Worker.BulkMode == "" {
+		c.Worker.BulkMode = BulkModePerIndex
+	}
+	if c.Worker.BulkConcurrency == 0 {
+		c.Worker.BulkConcurrency = c.Worker.NumWorkers
+	}
+	c.Worker.IndexerIdleTTL = legacyDuration(c.Worker.IndexerIdleTTL, c.Worker.IndexerIdleTTLSecs, time.Second, 0)
+	if c.Flow.MaxInflightBytes == 0 {
+		c.Flow.MaxInflightBytes = 64 << 20
+	}
+	if c.Flow.MaxInflightMessages == 0 {
+		c.Flow.MaxInflightMessages = 5000
+	}
+	if c.Flow.LowWatermark == 0 {
+		c.Flow.LowWatermark = 0.5
+	}
+	if c.Worker.Dispatch == "" {
+		c.Worker.Dispatch = "shared"
+	}
+	if c.Worker.DocumentID == "" {
+		c.Worker.DocumentID = "uuid"
+	}
+	if c.Worker.Decoder == "" {
+		c.Worker.Decoder = "json"
+	}
+	if c.Worker.Action == "" {
+		c.Worker.Action = "index"
+	}
+	for i := range c.Pipelines {
+		c.Pipelines[i].inherit(c.Worker)
+	}
+	if c.Reload.Watch == nil {
+		watch := true
+		c.Reload.Watch = &watch
+	}
+	c.Reload.Interval = legacyDuration(c.Reload.Interval, c.Reload.IntervalSecs, time.Second, 5*time.Second)
+	if c.Kafka.Checkpoint.Store == "" {
+		c.Kafka.Checkpoint.Store = CheckpointFile
+	}
+	if c.Kafka.Checkpoint.Path == "" {
+		c.Kafka.Checkpoint.Path = "checkpoints.json"
+	}
+	if c.Kafka.Checkpoint.Index == "" {
+		c.Kafka.Checkpoint.Index = "kafka-to-es-checkpoints"
+	}
+	if c.Kafka.Checkpoint.Name == "" {
+		c.Kafka.Checkpoint.Name = c.Kafka.GroupID
 	}
-	c.Worker.FlushInterval = time.Duration(c.Worker.FlushIntervalSecs) * time.Second
 }
 
-// Load reads and parses the YAML config file at the given path.
+// Load reads and parses the YAML config file at the given path. ${VAR}
+// references in values are expanded, and KTE_* environment variables
+// override the fields they name. Unknown keys are rejected with a
+// *ValidationError; call Validate to check the values.
 func Load(path string) (*Config, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("read config: %w", err)
 	}
-	var c Config
-	if err := yaml.Unmarshal(b, &c); err != nil {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
 		return nil, fmt.Errorf("unmarshal config: %w", err)
 	}
+	if err := expandVars(&doc, os.LookupEnv); err != nil {
+		return nil, fmt.Errorf("expand config: %w", err)
+	}
+	c := Config{file: path, lines: make(map[string]int)}
+	recordLines(&doc, "", c.lines)
+	unknown := &problems{lines: c.lines}
+	checkKnownFields(&doc, reflect.TypeOf(c), "", unknown)
+	if err := unknown.err(path); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) > 0 {
+		if err := doc.Decode(&c); err != nil {
+			return nil, fmt.Errorf("unmarshal config: %w", err)
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(&c).Elem(), EnvPrefix, os.LookupEnv); err != nil {
+		return nil, fmt.Errorf("environment override %w", err)
+	}
+	if err := c.resolveSecrets(); err != nil {
+		return nil, err
+	}
 	c.SetDefaults()
 	return &c, nil
 }
+
+// RestartRequired returns the top-level sections whose changes from c to o
+// only take effect after a restart. Mappings, per-topic scheduling and the
+// reload settings are applied to a running consumer.
+func (c *Config) RestartRequired(o *Config) []string {
+	a, b := c.withoutReloadable(), o.withoutReloadable()
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	var sections []string
+	for i := 0; i < av.NumField(); i++ {
+		f := av.Type().Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if !reflect.DeepEqual(av.Field(i).Interface(), bv.Field(i).Interface()) {
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			sections = append(sections, name)
+		}
+	}
+	return sections
+}
+
+// withoutReloadable returns a copy of c with the reloadable values cleared.
+func (c *Config) withoutReloadable() Config {
+	out := *c
+	out.Mappings = nil
+	out.Worker.Scheduling.Default = TopicSchedule{}
+	out.Worker.Scheduling.Topics = nil
+	out.Reload = ReloadConfig{}
+	out.Worker.Decoder, out.Worker.DocumentID, out.Worker.Action = "", "", ""
+	out.Pipelines = make([]PipelineConfig, len(c.Pipelines))
+	for i, pl := range c.Pipelines {
+		pl.Transforms, pl.Index = nil, ""
+		pl.Decoder, pl.DocumentID, pl.Action = "", "", ""
+		out.Pipelines[i] = pl
+	}
+	out.file, out.lines = "", nil
+	return out
+}
+
+// resolveSecrets loads every secret that references an env var or a file.
+func (c *Config) resolveSecrets() error {
+	secrets := map[string]*Secret{
+		"es.password":         &c.ES.Password,
+		"es.api_key":          &c.ES.APIKey,
+		"es.service_token":    &c.ES.ServiceToken,
+		"kafka.sasl.password": &c.Kafka.SASL.Password,
+		"admin.token":         &c.Admin.Token,
+	}
+	for i, pl := range c.Pipelines {
+		path := fmt.Sprintf("pipelines[%d]", i)
+		if pl.Kafka != nil {
+			secrets[path+".kafka.sasl.password"] = &pl.Kafka.SASL.Password
+		}
+		if pl.ES != nil {
+			secrets[path+".es.password"] = &pl.ES.Password
+			secrets[path+".es.api_key"] = &pl.ES.APIKey
+			secrets[path+".es.service_token"] = &pl.ES.ServiceToken
+		}
+	}
+	for name, s := range secrets {
+		if err := s.resolve(); err != nil {
+			return fmt.Errorf("resolve %s: %w", name, err)
+		}
+	}
+	return nil
+}
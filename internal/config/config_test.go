@@ -0,0 +1,196 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSecretUnmarshal(t *testing.T) {
+	var v struct {
+		Plain Secret `yaml:"plain"`
+		Env   Secret `yaml:"env"`
+		File  Secret `yaml:"file"`
+	}
+	src := "plain: hunter2\nenv: {env: MY_SECRET}\nfile: {file: /run/secret}\n"
+	if err := yaml.Unmarshal([]byte(src), &v); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if v.Plain.Value != "hunter2" || v.Env.Env != "MY_SECRET" || v.File.File != "/run/secret" {
+		t.Errorf("unexpected secrets: %+v", v)
+	}
+	if v.Plain.String() != "[REDACTED]" {
+		t.Errorf("String() = %q, want redacted", v.Plain.String())
+	}
+}
+
+func TestLoadResolvesSecrets(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(tokenFile, []byte("file-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_ES_PASSWORD", "env-password")
+
+	path := writeConfig(t, `
+es:
+  username: elastic
+  password: {env: TEST_ES_PASSWORD}
+  service_token: {file: `+tokenFile+`}
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ES.Password.Value != "env-password" {
+		t.Errorf("password = %q", cfg.ES.Password.Value)
+	}
+	if cfg.ES.ServiceToken.Value != "file-token" {
+		t.Errorf("service token = %q", cfg.ES.ServiceToken.Value)
+	}
+}
+
+func TestLoadMissingSecretEnv(t *testing.T) {
+	path := writeConfig(t, "es:\n  api_key: {env: TEST_UNSET_API_KEY}\n")
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected error for unset secret env var")
+	}
+}
+
+func TestTuningForMergesOverrides(t *testing.T) {
+	path := writeConfig(t, `
+kafka:
+  topics: [a, b]
+  start_offset: earliest
+  max_wait_ms: 500
+  commit_sync: true
+  topic_overrides:
+    b:
+      start_offset: latest
+      commit_sync: false
+      commit_interval_ms: 1000
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	a := cfg.Kafka.TuningFor("a")
+	if a.StartOffset != "earliest" || a.MaxWaitMs != 500 || !*a.CommitSync {
+		t.Errorf("unexpected tuning for a: %+v", a)
+	}
+	b := cfg.Kafka.TuningFor("b")
+	if b.StartOffset != "latest" || b.MaxWaitMs != 500 || *b.CommitSync || b.CommitIntervalMs != 1000 {
+		t.Errorf("unexpected tuning for b: %+v", b)
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	a := &Config{Mappings: map[string]string{"a": "x"}}
+	b := &Config{Mappings: map[string]string{"a": "y"}}
+	b.Worker.Scheduling.Topics = map[string]TopicSchedule{"a": {Weight: 2}}
+	a.Pipelines = []PipelineConfig{{Name: "p", Topics: []string{"a"}, Index: "x-{topic}"}}
+	b.Pipelines = []PipelineConfig{{Name: "p", Topics: []string{"a"}, DocumentID: "key", Transforms: []TransformConfig{{Op: "remove", Field: "payload.b"}}}}
+	if got := a.RestartRequired(b); len(got) != 0 {
+		t.Errorf("RestartRequired() = %v for reloadable changes only", got)
+	}
+	b.Worker.NumWorkers = 8
+	if got := a.RestartRequired(b); len(got) != 1 || got[0] != "worker" {
+		t.Errorf("RestartRequired() = %v, want [worker]", got)
+	}
+	b.Worker.NumWorkers = 0
+	b.Pipelines[0].Topics = []string{"a", "b"}
+	if got := a.RestartRequired(b); len(got) != 1 || got[0] != "pipelines" {
+		t.Errorf("RestartRequired() = %v, want [pipelines]", got)
+	}
+}
+
+func TestSplitIsolatedPipelines(t *testing.T) {
+	t.Setenv("TEST_LOGS_PASSWORD", "s3cret")
+	path := writeConfig(t, `
+kafka:
+  brokers: ["main:9092"]
+  group_id: main
+  topics: [orders]
+  max_wait: 1s
+  session_timeout: 20s
+es:
+  addresses: ["http://es-main:9200"]
+  username: elastic
+pipelines:
+  - name: payments
+    topics: [payments]
+  - name: logs
+    topics: [app-logs]
+    num_workers: 2
+    kafka:
+      brokers: ["logs:9092"]
+      group_id: logs-to-es
+      max_wait_ms: 250
+    es:
+      addresses: ["http://es-logs:9200"]
+      password: {env: TEST_LOGS_PASSWORD}
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	shared, isolated := cfg.Split()
+	if shared == nil || shared.Name() != DefaultPipelineName {
+		t.Fatalf("shared = %+v", shared)
+	}
+	if len(shared.Pipelines) != 1 || shared.Pipelines[0].Name != "payments" {
+		t.Errorf("shared pipelines = %+v", shared.Pipelines)
+	}
+	if len(isolated) != 1 {
+		t.Fatalf("got %d isolated consumers, want 1", len(isolated))
+	}
+	logs := isolated[0]
+	if logs.Name() != "logs" || len(logs.Pipelines) != 1 || logs.Pipelines[0].Isolated() {
+		t.Errorf("unexpected isolated consumer %s: %+v", logs.Name(), logs.Pipelines)
+	}
+	k := logs.Kafka
+	if k.Brokers[0] != "logs:9092" || k.GroupID != "logs-to-es" || len(k.Topics) != 0 {
+		t.Errorf("kafka not merged: %+v", k)
+	}
+	if k.MaxWait != 250*time.Millisecond || k.SessionTimeout != 20*time.Second {
+		t.Errorf("tuning not merged: max_wait %v, session_timeout %v", k.MaxWait, k.SessionTimeout)
+	}
+	if k.Checkpoint.Name != "logs-to-es" {
+		t.Errorf("checkpoint name = %q, want the pipeline's group", k.Checkpoint.Name)
+	}
+	if logs.ES.Addresses[0] != "http://es-logs:9200" || logs.ES.Username != "elastic" || logs.ES.Password.Value != "s3cret" {
+		t.Errorf("es not merged: %+v", logs.ES)
+	}
+	if logs.Pipelines[0].NumWorkers != 2 || logs.Metrics.Addr != "" {
+		t.Errorf("unexpected pipeline or metrics settings: %+v", logs)
+	}
+	if got := shared.SubscribedTopics(); len(got) != 2 {
+		t.Errorf("shared topics = %v, want orders and payments", got)
+	}
+
+	if c, err := cfg.Consumer("logs"); err != nil || c.Name() != "logs" {
+		t.Errorf("Consumer(logs) = %v, %v", c, err)
+	}
+	if c, err := cfg.Consumer("payments"); err != nil || c.Name() != DefaultPipelineName {
+		t.Errorf("Consumer(payments) = %v, %v", c, err)
+	}
+	if _, err := cfg.Consumer("missing"); err == nil {
+		t.Error("expected an error for an unknown pipeline")
+	}
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the name of every environment variable that overrides a
+// config field. The rest of the name is the field's YAML path in upper case,
+// joined by underscores: KTE_KAFKA_BROKERS, KTE_WORKER_NUM_WORKERS,
+// KTE_ES_TLS_CA_FILE.
+const EnvPrefix = "KTE"
+
+// DefaultPath returns the config file named by KTE_CONFIG, or config.yaml.
+func DefaultPath() string {
+	if p, ok := os.LookupEnv(EnvPrefix + "_CONFIG"); ok && p != "" {
+		return p
+	}
+	return "config.yaml"
+}
+
+// varPattern matches ${VAR} and ${VAR:-default}; $${ escapes a literal ${.
+var varPattern = regexp.MustCompile(`\$(\$)?\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// expandVars replaces ${VAR} references in every scalar value of a YAML
+// document with the environment variable's value. A reference without a
+// default to an unset variable is an error.
+func expandVars(n *yaml.Node, lookup func(string) (string, bool)) error {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		var missing []string
+		n.Value = varPattern.ReplaceAllStringFunc(n.Value, func(m string) string {
+			sub := varPattern.FindStringSubmatch(m)
+			if sub[1] != "" {
+				return m[1:]
+			}
+			if v, ok := lookup(sub[2]); ok {
+				return v
+			}
+			if strings.Contains(m, ":-") {
+				return sub[3]
+			}
+			missing = append(missing, sub[2])
+			return ""
+		})
+		if len(missing) > 0 {
+			return fmt.Errorf("line %d: environment variable %s is not set", n.Line, strings.Join(missing, ", "))
+		}
+	case yaml.MappingNode:
+		// Only values are expanded, never keys.
+		for i := 1; i < len(n.Content); i += 2 {
+			if err := expandVars(n.Content[i], lookup); err != nil {
+				return err
+			}
+		}
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, c := range n.Content {
+			if err := expandVars(c, lookup); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var secretType = reflect.TypeOf(Secret{})
+
+// applyEnv overrides the fields of the struct v from environment variables
+// named prefix plus the field's YAML path. Values are parsed as YAML, so
+// maps and structs can be given as flow mappings; lists also accept a plain
+// comma-separated string. Strings and secrets are taken verbatim.
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		fv := v.Field(i)
+		if opts == "inline" {
+			if err := applyEnv(fv, prefix, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		key := prefix + "_" + strings.ToUpper(name)
+		if s, ok := lookup(key); ok {
+			if err := setFromEnv(fv, s); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			continue
+		}
+		if fv.Kind() == reflect.Struct && fv.Type() != secretType {
+			if err := applyEnv(fv, key, lookup); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setFromEnv replaces a field with the value of an environment variable.
+func setFromEnv(fv reflect.Value, s string) error {
+	switch {
+	case fv.Type() == secretType:
+		fv.Set(reflect.ValueOf(Secret{Value: s}))
+		return nil
+	case fv.Kind() == reflect.String:
+		fv.SetString(s)
+		return nil
+	}
+
+	var n yaml.Node
+	if fv.Kind() == reflect.Slice && !strings.HasPrefix(strings.TrimSpace(s), "[") {
+		n = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: item})
+			}
+		}
+	} else {
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(s), &doc); err != nil {
+			return err
+		}
+		if len(doc.Content) == 0 {
+			fv.Set(reflect.Zero(fv.Type()))
+			return nil
+		}
+		n = *doc.Content[0]
+	}
+	fresh := reflect.New(fv.Type())
+	if err := n.Decode(fresh.Interface()); err != nil {
+		return err
+	}
+	fv.Set(fresh.Elem())
+	return nil
+}
+
+// MarshalYAML writes the secret redacted, so a marshalled config is safe to
+// log.
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// Redacted returns the configuration as YAML with every secret redacted.
+func (c *Config) Redacted() string {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("<unprintable config: %v>", err)
+	}
+	return string(b)
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadEnvOverrides(t *testing.T) {
+	path := writeConfig(t, `
+kafka:
+  brokers: ["file:9092"]
+  group_id: from-file
+  start_offset: earliest
+mappings:
+  a: index-a
+worker:
+  num_workers: 2
+`)
+	t.Setenv("KTE_KAFKA_BROKERS", "b1:9092, b2:9092")
+	t.Setenv("KTE_KAFKA_TOPICS", "[t1, t2]")
+	t.Setenv("KTE_KAFKA_START_OFFSET", "latest")
+	t.Setenv("KTE_KAFKA_COMMIT_SYNC", "true")
+	t.Setenv("KTE_KAFKA_TLS_CA_FILE", "/etc/ca.pem")
+	t.Setenv("KTE_KAFKA_STATIC_PARTITIONS", "{orders: [0, 2]}")
+	t.Setenv("KTE_ES_PASSWORD", "s3cret")
+	t.Setenv("KTE_WORKER_NUM_WORKERS", "8")
+	t.Setenv("KTE_WORKER_SCHEDULING_DEFAULT_WEIGHT", "3")
+	t.Setenv("KTE_MAPPINGS", "{b: index-b}")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := strings.Join(cfg.Kafka.Brokers, ","); got != "b1:9092,b2:9092" {
+		t.Errorf("brokers = %q", got)
+	}
+	if got := strings.Join(cfg.Kafka.Topics, ","); got != "t1,t2" {
+		t.Errorf("topics = %q", got)
+	}
+	if cfg.Kafka.GroupID != "from-file" {
+		t.Errorf("group_id = %q, want the file value", cfg.Kafka.GroupID)
+	}
+	if cfg.Kafka.StartOffset != "latest" {
+		t.Errorf("inline start_offset = %q", cfg.Kafka.StartOffset)
+	}
+	if cfg.Kafka.CommitSync == nil || !*cfg.Kafka.CommitSync {
+		t.Errorf("commit_sync = %v", cfg.Kafka.CommitSync)
+	}
+	if cfg.Kafka.TLS.CAFile != "/etc/ca.pem" {
+		t.Errorf("tls.ca_file = %q", cfg.Kafka.TLS.CAFile)
+	}
+	if p := cfg.Kafka.StaticPartitions["orders"]; len(p) != 2 || p[1] != 2 {
+		t.Errorf("static_partitions = %v", cfg.Kafka.StaticPartitions)
+	}
+	if cfg.ES.Password.Value != "s3cret" {
+		t.Errorf("es.password = %q", cfg.ES.Password.Value)
+	}
+	if cfg.Worker.NumWorkers != 8 || cfg.Worker.Scheduling.Default.Weight != 3 {
+		t.Errorf("worker = %+v", cfg.Worker)
+	}
+	if len(cfg.Mappings) != 1 || cfg.Mappings["b"] != "index-b" {
+		t.Errorf("mappings = %v, want the env map only", cfg.Mappings)
+	}
+}
+
+func TestLoadEnvOverrideInvalid(t *testing.T) {
+	path := writeConfig(t, "worker:\n  num_workers: 2\n")
+	t.Setenv("KTE_WORKER_NUM_WORKERS", "many")
+	_, err := Load(path)
+	if err == nil || !strings.Contains(err.Error(), "KTE_WORKER_NUM_WORKERS") {
+		t.Fatalf("Load() error = %v, want one naming the variable", err)
+	}
+}
+
+func TestLoadExpandsVariables(t *testing.T) {
+	t.Setenv("TEST_BROKER", "kafka:9092")
+	path := writeConfig(t, `
+kafka:
+  brokers: ["${TEST_BROKER}"]
+  group_id: "${TEST_UNSET_GROUP:-default-group}"
+es:
+  username: "$${literal}"
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Kafka.Brokers[0] != "kafka:9092" || cfg.Kafka.GroupID != "default-group" {
+		t.Errorf("kafka = %+v", cfg.Kafka)
+	}
+	if cfg.ES.Username != "${literal}" {
+		t.Errorf("escaped value = %q", cfg.ES.Username)
+	}
+
+	path = writeConfig(t, "kafka:\n  group_id: ${TEST_UNSET_GROUP}\n")
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "TEST_UNSET_GROUP") {
+		t.Fatalf("Load() error = %v, want unset variable error", err)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	path := writeConfig(t, "es:\n  username: elastic\n  password: hunter2\n")
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := cfg.Redacted()
+	if strings.Contains(out, "hunter2") {
+		t.Errorf("secret leaked into effective config:\n%s", out)
+	}
+	if !strings.Contains(out, "password: '[REDACTED]'") || !strings.Contains(out, "username: elastic") {
+		t.Errorf("unexpected effective config:\n%s", out)
+	}
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// SchemaID is where the published JSON Schema of the config file lives,
+// relative to the repository root.
+const SchemaID = "config.schema.json"
+
+// enums lists the accepted values of string fields, keyed by struct and
+// field name.
+var enums = map[string][]string{
+	"ReaderTuning.StartOffset":    {"earliest", "first", "latest", "last"},
+	"ReaderTuning.IsolationLevel": {"read_uncommitted", "read_committed"},
+	"SASLConfig.Mechanism":        {"PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512", "OAUTHBEARER"},
+	"CheckpointConfig.Store":      {CheckpointFile, CheckpointElasticsearch},
+	"WorkerConfig.BulkMode":       {BulkModePerIndex, BulkModeShared},
+	"WorkerConfig.Dispatch":       {"shared", "partition", "key"},
+	"WorkerConfig.DocumentID":     {"uuid", "key", "offset"},
+	"WorkerConfig.Decoder":        {"json", "string", "base64"},
+	"WorkerConfig.Action":         {"index", "create", "update", "delete"},
+	"PipelineConfig.DocumentID":   {"uuid", "key", "offset"},
+	"PipelineConfig.Decoder":      {"json", "string", "base64"},
+	"PipelineConfig.Action":       {"index", "create", "update", "delete"},
+	"TransformConfig.Op":          {"set", "remove", "rename"},
+}
+
+var (
+	durationSchema = map[string]any{
+		"type":        "string",
+		"pattern":     `^-?([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$|^0$`,
+		"description": `Go duration such as "500ms", "30s" or "2m"`,
+	}
+	byteSizeSchema = map[string]any{
+		"anyOf": []any{
+			map[string]any{"type": "integer"},
+			map[string]any{
+				"type":    "string",
+				"pattern": `^[0-9][0-9_]*(\.[0-9]+)?\s*(([kKmMgGtT][iI]?)?[bB])?$`,
+			},
+		},
+		"description": `size in bytes, or with a unit such as "5MB" or "64MiB"`,
+	}
+	secretSchema = map[string]any{
+		"anyOf": []any{
+			map[string]any{"type": "string"},
+			map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"value": map[string]any{"type": "string"},
+					"env":   map[string]any{"type": "string", "description": "environment variable holding the secret"},
+					"file":  map[string]any{"type": "string", "description": "file holding the secret"},
+				},
+				"additionalProperties": false,
+			},
+		},
+	}
+)
+
+// JSONSchema returns a JSON Schema (draft 2020-12) of the config file for
+// editors and linters. It is derived from the Config struct, so unknown keys
+// are rejected as they are by Load.
+func JSONSchema() ([]byte, error) {
+	s := typeSchema(reflect.TypeOf(Config{}))
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["$id"] = SchemaID
+	s["title"] = "kafka-to-es configuration"
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	switch t {
+	case durationType:
+		return durationSchema
+	case byteSizeType:
+		return byteSizeSchema
+	case reflect.TypeOf(Secret{}):
+		return secretSchema
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		props := make(map[string]any)
+		structProperties(t, props)
+		return map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+	}
+	// Interfaces take any value.
+	return map[string]any{}
+}
+
+// structProperties adds the schema of every YAML key of t to props.
+func structProperties(t reflect.Type, props map[string]any) {
+	fields := yamlFields(t)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if opts == "inline" {
+			structProperties(f.Type, props)
+			continue
+		}
+		if name == "-" || name == "" {
+			continue
+		}
+		s := typeSchema(f.Type)
+		if values, ok := enums[t.Name()+"."+f.Name]; ok {
+			s = map[string]any{"type": "string", "enum": values}
+		}
+		for _, suffix := range legacySuffixes {
+			base, ok := strings.CutSuffix(name, suffix)
+			if ok && fields[base] == durationType {
+				s = map[string]any{
+					"type":        "integer",
+					"deprecated":  true,
+					"description": "legacy form of " + base + ", in " + strings.TrimPrefix(suffix, "_"),
+				}
+			}
+		}
+		props[name] = s
+	}
+}
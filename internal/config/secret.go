@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Secret is a sensitive value such as a password or token. In YAML it is
+// either a plain string or a mapping naming where to read it from:
+//
+//	password: "changeme"
+//	password: {env: ES_PASSWORD}
+//	password: {file: /run/secrets/es-password}
+//
+// Load resolves env and file references into Value.
+type Secret struct {
+	Value string `yaml:"value"`
+	Env   string `yaml:"env"`
+	File  string `yaml:"file"`
+}
+
+// UnmarshalYAML accepts both the scalar and the mapping form.
+func (s *Secret) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		return n.Decode(&s.Value)
+	}
+	type plain Secret
+	return n.Decode((*plain)(s))
+}
+
+// IsSet reports whether the secret has a value or a reference to one.
+func (s Secret) IsSet() bool {
+	return s.Value != "" || s.Env != "" || s.File != ""
+}
+
+// String redacts the value so secrets don't leak into logs.
+func (s Secret) String() string {
+	if s.Value == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+// resolve reads the secret from its env or file reference, if any.
+func (s *Secret) resolve() error {
+	switch {
+	case s.Env != "":
+		v, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return fmt.Errorf("environment variable %s is not set", s.Env)
+		}
+		s.Value = v
+	case s.File != "":
+		b, err := os.ReadFile(s.File)
+		if err != nil {
+			return fmt.Errorf("read secret file: %w", err)
+		}
+		s.Value = strings.TrimSpace(string(b))
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ByteSize is a size in bytes. In YAML it is a plain number of bytes or a
+// number with a unit: B, KB, MB, GB, TB (powers of 1000) or KiB, MiB, GiB,
+// TiB (powers of 1024), such as "5MB" or "64MiB".
+type ByteSize int64
+
+var byteUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"kb":  1000,
+	"mb":  1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+}
+
+// ParseByteSize parses a size such as "512", "5MB" or "1.5GiB".
+func ParseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.' && r != '_' && r != '-'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	num, unit := s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+	mult, ok := byteUnits[unit]
+	if !ok || num == "" {
+		return 0, fmt.Errorf("invalid size %q: want a number of bytes or a unit such as 5MB or 64MiB", s)
+	}
+	num = strings.ReplaceAll(num, "_", "")
+	if n, err := strconv.ParseInt(num, 10, 64); err == nil {
+		return ByteSize(n * mult), nil
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", s, err)
+	}
+	return ByteSize(f * float64(mult)), nil
+}
+
+// UnmarshalYAML accepts a number of bytes or a string with a unit.
+func (b *ByteSize) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: size must be a scalar", n.Line)
+	}
+	v, err := ParseByteSize(n.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", n.Line, err)
+	}
+	*b = v
+	return nil
+}
+
+// String formats b with the largest binary unit that divides it evenly.
+func (b ByteSize) String() string {
+	for _, u := range []struct {
+		name string
+		size int64
+	}{{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}} {
+		if b != 0 && int64(b)%u.size == 0 {
+			return strconv.FormatInt(int64(b)/u.size, 10) + u.name
+		}
+	}
+	return strconv.FormatInt(int64(b), 10)
+}
+
+// MarshalYAML writes b as an integer, which every version of the config
+// format understands.
+func (b ByteSize) MarshalYAML() (interface{}, error) {
+	return int64(b), nil
+}
+
+// legacyDuration returns d if it is set, else legacy counted in unit if
+// that is set, else def. Durations are configured as Go duration strings;
+// the legacy keys hold plain numbers of seconds or milliseconds.
+func legacyDuration(d time.Duration, legacy int, unit, def time.Duration) time.Duration {
+	switch {
+	case d != 0:
+		return d
+	case legacy != 0:
+		return time.Duration(legacy) * unit
+	}
+	return def
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	cases := map[string]ByteSize{
+		"512":       512,
+		"5_000_000": 5_000_000,
+		"5MB":       5_000_000,
+		"5 mb":      5_000_000,
+		"64MiB":     64 << 20,
+		"1.5GiB":    3 << 29,
+		"10kb":      10_000,
+		"2TiB":      2 << 40,
+		"100B":      100,
+	}
+	for in, want := range cases {
+		got, err := ParseByteSize(in)
+		if err != nil || got != want {
+			t.Errorf("ParseByteSize(%q) = %d, %v, want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "MB", "5XB", "five"} {
+		if _, err := ParseByteSize(in); err == nil {
+			t.Errorf("ParseByteSize(%q) succeeded, want error", in)
+		}
+	}
+	if s := ByteSize(64 << 20).String(); s != "64MiB" {
+		t.Errorf("String() = %q, want 64MiB", s)
+	}
+}
+
+func TestDurationAndLegacyKeys(t *testing.T) {
+	path := writeConfig(t, `
+kafka:
+  topics: [a, b]
+  max_wait: 250ms
+  session_timeout_seconds: 30
+  topic_overrides:
+    b:
+      max_wait_ms: 100
+worker:
+  flush_interval: 1m30s
+  batch_bytes: 2MiB
+  indexer_idle_ttl_seconds: 600
+reload:
+  interval_seconds: 7
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if a := cfg.Kafka.TuningFor("a"); a.MaxWait != 250*time.Millisecond || a.SessionTimeout != 30*time.Second {
+		t.Errorf("unexpected tuning for a: %+v", a)
+	}
+	if b := cfg.Kafka.TuningFor("b"); b.MaxWait != 100*time.Millisecond {
+		t.Errorf("legacy override lost to the global key: %v", b.MaxWait)
+	}
+	w := cfg.Worker
+	if w.FlushInterval != 90*time.Second || w.BatchBytes != 2<<20 || w.IndexerIdleTTL != 10*time.Minute {
+		t.Errorf("unexpected worker settings: %+v", w)
+	}
+	if cfg.Reload.Interval != 7*time.Second || cfg.Kafka.SASL.TokenRefresh != time.Minute {
+		t.Errorf("unexpected durations: reload %v, token refresh %v", cfg.Reload.Interval, cfg.Kafka.SASL.TokenRefresh)
+	}
+	if cfg.Kafka.DialTimeout != 10*time.Second || cfg.ES.DialTimeout != 30*time.Second {
+		t.Errorf("unexpected default timeouts: kafka %v, es %v", cfg.Kafka.DialTimeout, cfg.ES.DialTimeout)
+	}
+}
+
+func TestValidateDurationKeys(t *testing.T) {
+	path := writeConfig(t, `kafka:
+  brokers: ["k:9092"]
+  group_id: g
+  topics: [a]
+  request_timeout: -1s
+worker:
+  flush_interval: 2s
+  flush_interval_seconds: 2
+  indexer_idle_ttl_seconds: -5
+es:
+  addresses: ["http://es:9200"]
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	var verr *ValidationError
+	if !errors.As(cfg.Validate(), &verr) {
+		t.Fatalf("Validate() = %v, want *ValidationError", cfg.Validate())
+	}
+	want := map[string]int{
+		"kafka.request_timeout":           5,
+		"worker.flush_interval_seconds":   8,
+		"worker.indexer_idle_ttl_seconds": 9,
+	}
+	if len(verr.Errors) != len(want) {
+		t.Errorf("got %d errors, want %d: %v", len(verr.Errors), len(want), verr)
+	}
+	for _, e := range verr.Errors {
+		if want[e.Path] != e.Line {
+			t.Errorf("unexpected error %v", e)
+		}
+	}
+
+	bad := writeConfig(t, "worker:\n  batch_bytes: 5XB\n")
+	if _, err := Load(bad); err == nil {
+		t.Error("expected an invalid size to fail the load")
+	}
+}
@@ -0,0 +1,662 @@
+package config
+
+import (
+	"fmt"
+	pathpkg "path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FieldError is a problem with one configuration value.
+type FieldError struct {
+	// Path is the YAML path of the value, such as "kafka.brokers[1]".
+	Path string
+	// Line is the line in the config file, or 0 if the value was not set
+	// in the file.
+	Line int
+	Msg  string
+}
+
+func (e FieldError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Path, e.Msg)
+	}
+	return e.Path + ": " + e.Msg
+}
+
+// ValidationError lists every problem found in a configuration.
+type ValidationError struct {
+	// File is the config file the errors refer to, if any.
+	File   string
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d configuration error(s)", len(e.Errors))
+	if e.File != "" {
+		fmt.Fprintf(&sb, " in %s", e.File)
+	}
+	for _, fe := range e.Errors {
+		sb.WriteString("\n  ")
+		sb.WriteString(fe.Error())
+	}
+	return sb.String()
+}
+
+// problems collects field errors, looking up line numbers as it goes.
+type problems struct {
+	lines map[string]int
+	errs  []FieldError
+}
+
+func (p *problems) add(path, format string, args ...any) {
+	p.errs = append(p.errs, FieldError{Path: path, Line: p.lines[path], Msg: fmt.Sprintf(format, args...)})
+}
+
+func (p *problems) err(file string) error {
+	if len(p.errs) == 0 {
+		return nil
+	}
+	return &ValidationError{File: file, Errors: p.errs}
+}
+
+// recordLines stores the line of every key and list item under path.
+func recordLines(n *yaml.Node, path string, lines map[string]int) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			recordLines(c, path, lines)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			p := joinPath(path, n.Content[i].Value)
+			lines[p] = n.Content[i].Line
+			recordLines(n.Content[i+1], p, lines)
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			p := path + "[" + strconv.Itoa(i) + "]"
+			lines[p] = c.Line
+			recordLines(c, p, lines)
+		}
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// checkKnownFields reports every mapping key that does not correspond to a
+// field of t.
+func checkKnownFields(n *yaml.Node, t reflect.Type, path string, p *problems) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if n.Kind == yaml.DocumentNode {
+		for _, c := range n.Content {
+			checkKnownFields(c, t, path, p)
+		}
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			ft, ok := fields[key]
+			if !ok {
+				p.add(joinPath(path, key), "unknown field")
+				continue
+			}
+			checkKnownFields(n.Content[i+1], ft, joinPath(path, key), p)
+		}
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			checkKnownFields(n.Content[i+1], t.Elem(), joinPath(path, n.Content[i].Value), p)
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, c := range n.Content {
+			checkKnownFields(c, t.Elem(), path+"["+strconv.Itoa(i)+"]", p)
+		}
+	}
+}
+
+// yamlFields maps the YAML keys of a struct, including inlined structs, to
+// their types.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		switch {
+		case name == "-":
+		case opts == "inline":
+			for k, v := range yamlFields(f.Type) {
+				fields[k] = v
+			}
+		case name == "":
+			fields[strings.ToLower(f.Name)] = f.Type
+		default:
+			fields[name] = f.Type
+		}
+	}
+	return fields
+}
+
+// Validate checks the configuration for values that cannot work and returns
+// a *ValidationError listing all of them. Call it after Load, which also
+// applies the defaults.
+func (c *Config) Validate() error {
+	p := &problems{lines: c.lines}
+
+	k := c.Kafka
+	static := len(k.StaticPartitions) > 0
+	// The top-level connection settings are only needed if some topic is
+	// left to the shared consumer; isolated pipelines check their own.
+	shared, isolated := c.Split()
+	needShared := shared != nil || len(isolated) == 0
+	if len(k.Brokers) == 0 && needShared {
+		p.add("kafka.brokers", "at least one broker is required")
+	}
+	for i, b := range k.Brokers {
+		if strings.TrimSpace(b) == "" {
+			p.add(fmt.Sprintf("kafka.brokers[%d]", i), "must not be empty")
+		}
+	}
+	subscribed := make(map[string]bool)
+	if static {
+		for _, topic := range sortedKeys(k.StaticPartitions) {
+			subscribed[topic] = true
+			for i, part := range k.StaticPartitions[topic] {
+				if part < 0 {
+					p.add(fmt.Sprintf("kafka.static_partitions.%s[%d]", topic, i), "partition must not be negative")
+				}
+			}
+		}
+		switch k.Checkpoint.Store {
+		case CheckpointFile, CheckpointElasticsearch:
+		default:
+			p.add("kafka.checkpoint.store", "must be %q or %q", CheckpointFile, CheckpointElasticsearch)
+		}
+	} else {
+		if len(k.Topics) == 0 && len(c.SubscribedTopics()) == 0 && len(c.TopicPatterns()) == 0 {
+			p.add("kafka.topics", "at least one topic is required")
+		}
+		if k.GroupID == "" && needShared {
+			p.add("kafka.group_id", "is required unless static_partitions is set")
+		}
+	}
+	for i, topic := range k.Topics {
+		path := fmt.Sprintf("kafka.topics[%d]", i)
+		switch {
+		case strings.TrimSpace(topic) == "":
+			p.add(path, "must not be empty")
+		case subscribed[topic] && !static:
+			p.add(path, "topic %q is listed twice", topic)
+		}
+		if !static {
+			subscribed[topic] = true
+		}
+	}
+	claimed := c.validatePipelines(p, subscribed, static)
+	c.validateIsolated(p, shared, isolated)
+	if k.Checkpoint.ExactlyOnce {
+		if len(c.Pipelines) > 0 {
+			p.add("kafka.checkpoint.exactly_once", "is not supported together with pipelines")
+		}
+		if k.Checkpoint.Store != CheckpointElasticsearch {
+			p.add("kafka.checkpoint.exactly_once", "requires store %q", CheckpointElasticsearch)
+		}
+		if c.Worker.DocumentID == "uuid" {
+			p.add("worker.document_id", "exactly_once requires a deterministic document_id (key or offset)")
+		}
+	}
+	validateTuning(p, "kafka", k.ReaderTuning)
+	for _, topic := range sortedKeys(k.TopicOverrides) {
+		path := "kafka.topic_overrides." + topic
+		if !claimed(topic) {
+			p.add(path, "topic %q is not subscribed", topic)
+		}
+		validateTuning(p, path, k.TopicOverrides[topic])
+	}
+	if k.CommitThreshold < 0 {
+		p.add("kafka.commit_threshold", "must not be negative")
+	}
+
+	for _, topic := range sortedKeys(c.Mappings) {
+		path := "mappings." + topic
+		if !claimed(topic) {
+			p.add(path, "topic %q is not subscribed", topic)
+		}
+		if err := CheckIndexName(c.Mappings[topic]); err != nil {
+			p.add(path, "invalid index name %q: %v", c.Mappings[topic], err)
+		}
+	}
+
+	if c.Admin.Addr != "" && !c.Admin.Token.IsSet() {
+		p.add("admin.token", "is required when admin.addr is set")
+	}
+
+	if ctl := c.Control; ctl.Topic != "" {
+		if subscribed[ctl.Topic] || claimed(ctl.Topic) {
+			p.add("control.topic", "topic %q is also consumed as data", ctl.Topic)
+		}
+		if len(k.Brokers) == 0 {
+			p.add("control.topic", "requires kafka.brokers")
+		}
+		if ctl.StatusTopic == ctl.Topic {
+			p.add("control.status_topic", "must differ from control.topic")
+		}
+	} else if ctl.StatusTopic != "" {
+		p.add("control.status_topic", "requires control.topic")
+	}
+
+	w := c.Worker
+	for path, v := range map[string]int64{
+		"worker.num_workers":      int64(w.NumWorkers),
+		"worker.batch_size":       int64(w.BatchSize),
+		"worker.batch_bytes":      int64(w.BatchBytes),
+		"worker.bulk_concurrency": int64(w.BulkConcurrency),
+	} {
+		if v <= 0 {
+			p.add(path, "must be positive")
+		}
+	}
+	oneOf(p, "worker.bulk_mode", w.BulkMode, BulkModePerIndex, BulkModeShared)
+	oneOf(p, "worker.dispatch", w.Dispatch, "shared", "partition", "key")
+	validateOutput(p, "worker", w.Decoder, w.Action, w.DocumentID)
+	validateSchedule(p, "worker.scheduling.default", w.Scheduling.Default)
+	for _, topic := range sortedKeys(w.Scheduling.Topics) {
+		path := "worker.scheduling.topics." + topic
+		if !claimed(topic) {
+			p.add(path, "topic %q is not subscribed", topic)
+		}
+		validateSchedule(p, path, w.Scheduling.Topics[topic])
+	}
+
+	if c.Flow.MaxInflightMessages < 0 {
+		p.add("flow.max_inflight_messages", "must not be negative")
+	}
+	if c.Flow.LowWatermark <= 0 || c.Flow.LowWatermark > 1 {
+		p.add("flow.low_watermark", "must be greater than 0 and at most 1")
+	}
+
+	if len(c.ES.Addresses) == 0 && c.ES.CloudID == "" && needShared {
+		p.add("es.addresses", "an address or es.cloud_id is required")
+	}
+	if c.ES.MaxRetries < 0 {
+		p.add("es.max_retries", "must not be negative")
+	}
+	validateTLS(p, "kafka.tls", c.Kafka.TLS)
+	validateTLS(p, "es.tls", c.ES.TLS)
+
+	validateUnits(p, reflect.ValueOf(*c), "")
+
+	sort.SliceStable(p.errs, func(i, j int) bool {
+		a, b := p.errs[i], p.errs[j]
+		if (a.Line == 0) != (b.Line == 0) {
+			return a.Line != 0
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Path < b.Path
+	})
+	return p.err(c.file)
+}
+
+// validatePipelines checks the pipelines section and adds the topics it
+// names to subscribed. The returned function reports whether a topic is
+// consumed, either by name or through a pipeline's pattern.
+func (c *Config) validatePipelines(p *problems, subscribed map[string]bool, static bool) func(string) bool {
+	names := make(map[string]bool)
+	owner := make(map[string]string)
+	listed := make(map[string]bool, len(c.Kafka.Topics))
+	for _, topic := range c.Kafka.Topics {
+		listed[topic] = true
+	}
+	var patterns []string
+	for i, pl := range c.Pipelines {
+		path := fmt.Sprintf("pipelines[%d]", i)
+		// Isolated pipelines do not use the top-level static partitions.
+		static := static && !pl.Isolated()
+		switch {
+		case pl.Name == "":
+			p.add(path+".name", "is required")
+		case pl.Name == DefaultPipelineName:
+			p.add(path+".name", "%q is reserved for topics without a pipeline", pl.Name)
+		case names[pl.Name]:
+			p.add(path+".name", "pipeline %q is defined twice", pl.Name)
+		}
+		names[pl.Name] = true
+		if len(pl.Topics) == 0 && len(pl.TopicPatterns) == 0 {
+			p.add(path+".topics", "topics or topic_patterns is required")
+		}
+		for j, topic := range pl.Topics {
+			tpath := fmt.Sprintf("%s.topics[%d]", path, j)
+			switch {
+			case strings.TrimSpace(topic) == "":
+				p.add(tpath, "must not be empty")
+			case owner[topic] != "":
+				p.add(tpath, "topic %q already belongs to pipeline %q", topic, owner[topic])
+			case static && !subscribed[topic]:
+				p.add(tpath, "topic %q is not in kafka.static_partitions", topic)
+			case pl.Isolated() && listed[topic]:
+				p.add(tpath, "topic %q is also in kafka.topics; an isolated pipeline consumes its topics itself", topic)
+			}
+			owner[topic] = pl.Name
+			if !static {
+				subscribed[topic] = true
+			}
+		}
+		for j, pattern := range pl.TopicPatterns {
+			ppath := fmt.Sprintf("%s.topic_patterns[%d]", path, j)
+			if static {
+				p.add(ppath, "patterns cannot be used with kafka.static_partitions")
+			}
+			if _, err := pathpkg.Match(pattern, ""); err != nil || pattern == "" {
+				p.add(ppath, "invalid pattern %q", pattern)
+			}
+			patterns = append(patterns, pattern)
+		}
+		if pl.Index != "" {
+			if err := CheckIndexName(placeholders.ReplaceAllString(pl.Index, "x")); err != nil {
+				p.add(path+".index", "invalid index template %q: %v", pl.Index, err)
+			}
+		}
+		validateOutput(p, path, pl.Decoder, pl.Action, pl.DocumentID)
+		for j, t := range pl.Transforms {
+			tpath := fmt.Sprintf("%s.transforms[%d]", path, j)
+			oneOf(p, tpath+".op", t.Op, "set", "remove", "rename")
+			if t.Field == "" {
+				p.add(tpath+".field", "is required")
+			}
+			if t.Op == "rename" && t.To == "" {
+				p.add(tpath+".to", "is required for rename")
+			}
+		}
+		if pl.NumWorkers <= 0 {
+			p.add(path+".num_workers", "must be positive")
+		}
+		if pl.BatchBytes <= 0 {
+			p.add(path+".batch_bytes", "must be positive")
+		}
+		if pl.Kafka != nil {
+			validatePipelineKafka(p, path+".kafka", pl)
+		}
+		if pl.ES != nil {
+			if pl.ES.MaxRetries < 0 {
+				p.add(path+".es.max_retries", "must not be negative")
+			}
+			validateTLS(p, path+".es.tls", pl.ES.TLS)
+		}
+	}
+	return func(topic string) bool {
+		if subscribed[topic] {
+			return true
+		}
+		for _, pattern := range patterns {
+			if ok, _ := pathpkg.Match(pattern, topic); ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// validatePipelineKafka checks the kafka section of isolated pipeline pl.
+func validatePipelineKafka(p *problems, path string, pl PipelineConfig) {
+	k := pl.Kafka
+	if len(k.Topics) > 0 {
+		p.add(path+".topics", "list the topics in the pipeline itself")
+	}
+	if len(k.StaticPartitions) > 0 {
+		p.add(path+".static_partitions", "is not supported in a pipeline")
+	}
+	if k.Checkpoint.ExactlyOnce {
+		p.add(path+".checkpoint.exactly_once", "is not supported in a pipeline")
+	}
+	for i, b := range k.Brokers {
+		if strings.TrimSpace(b) == "" {
+			p.add(fmt.Sprintf("%s.brokers[%d]", path, i), "must not be empty")
+		}
+	}
+	validateTuning(p, path, k.ReaderTuning)
+	for _, topic := range sortedKeys(k.TopicOverrides) {
+		opath := path + ".topic_overrides." + topic
+		if !pl.claims(topic) {
+			p.add(opath, "topic %q is not consumed by the pipeline", topic)
+		}
+		validateTuning(p, opath, k.TopicOverrides[topic])
+	}
+	validateTLS(p, path+".tls", k.TLS)
+	if k.CommitThreshold < 0 {
+		p.add(path+".commit_threshold", "must not be negative")
+	}
+}
+
+// validateIsolated checks the effective connection settings of every
+// isolated pipeline, and that no two consumers share a consumer group on the
+// same brokers.
+func (c *Config) validateIsolated(p *problems, shared *Config, isolated []*Config) {
+	groups := make(map[string]string)
+	group := func(s *Config) string {
+		brokers := append([]string(nil), s.Kafka.Brokers...)
+		sort.Strings(brokers)
+		return strings.Join(brokers, ",") + "/" + s.Kafka.GroupID
+	}
+	if shared != nil && len(shared.Kafka.StaticPartitions) == 0 {
+		groups[group(shared)] = "the top-level kafka section"
+	}
+	for _, s := range isolated {
+		path := "pipelines"
+		for i, pl := range c.Pipelines {
+			if pl.Name == s.name {
+				path = fmt.Sprintf("pipelines[%d]", i)
+			}
+		}
+		if len(s.Kafka.Brokers) == 0 {
+			p.add(path+".kafka.brokers", "at least one broker is required here or in kafka.brokers")
+		}
+		if s.Kafka.GroupID == "" {
+			p.add(path+".kafka.group_id", "is required here or in kafka.group_id")
+		} else if other, ok := groups[group(s)]; ok {
+			p.add(path+".kafka.group_id", "consumer group %q on the same brokers is already used by %s", s.Kafka.GroupID, other)
+		} else {
+			groups[group(s)] = fmt.Sprintf("pipeline %q", s.name)
+		}
+		if len(s.ES.Addresses) == 0 && s.ES.CloudID == "" {
+			p.add(path+".es.addresses", "an address or cloud_id is required here or in es")
+		}
+	}
+}
+
+// placeholders matches the placeholders of an index template.
+var placeholders = regexp.MustCompile(`\{(topic|partition|date:[^}]*)\}`)
+
+// validateOutput checks how documents are decoded and written.
+func validateOutput(p *problems, path, decoder, action, documentID string) {
+	oneOf(p, path+".decoder", decoder, "json", "string", "base64")
+	oneOf(p, path+".action", action, "index", "create", "update", "delete")
+	oneOf(p, path+".document_id", documentID, "uuid", "key", "offset")
+	if (action == "update" || action == "delete") && documentID == "uuid" {
+		p.add(path+".action", "%s requires a deterministic document_id (key or offset)", action)
+	}
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	byteSizeType = reflect.TypeOf(ByteSize(0))
+)
+
+// legacySuffixes name the keys that set a duration as a plain number.
+var legacySuffixes = []string{"_seconds", "_ms"}
+
+// validateUnits reports negative durations and sizes anywhere in v, and
+// durations that are set through both their key and its legacy key.
+func validateUnits(p *problems, v reflect.Value, path string) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			validateUnits(p, v.Elem(), path)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			validateUnits(p, v.Index(i), path+"["+strconv.Itoa(i)+"]")
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			validateUnits(p, v.MapIndex(k), joinPath(path, k.String()))
+		}
+	case reflect.Struct:
+		t := v.Type()
+		fields := yamlFields(t)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if opts == "inline" {
+				validateUnits(p, v.Field(i), path)
+				continue
+			}
+			if name == "-" || name == "" {
+				continue
+			}
+			fpath := joinPath(path, name)
+			if f.Type != durationType && f.Type != byteSizeType {
+				validateUnits(p, v.Field(i), fpath)
+				continue
+			}
+			report := fpath
+			for _, suffix := range legacySuffixes {
+				if _, ok := fields[name+suffix]; !ok {
+					continue
+				}
+				legacy := joinPath(path, name+suffix)
+				_, setLegacy := p.lines[legacy]
+				_, setNew := p.lines[fpath]
+				if setLegacy && setNew {
+					p.add(legacy, "set either %s or %s, not both", name, name+suffix)
+				} else if setLegacy {
+					report = legacy
+				}
+			}
+			if v.Field(i).Int() < 0 {
+				p.add(report, "must not be negative")
+			}
+		}
+	}
+}
+
+func validateTuning(p *problems, path string, t ReaderTuning) {
+	oneOf(p, path+".start_offset", strings.ToLower(t.StartOffset), "", "earliest", "first", "latest", "last")
+	oneOf(p, path+".isolation_level", strings.ToLower(t.IsolationLevel), "", "read_uncommitted", "read_committed")
+	for i, s := range t.PartitionAssignmentStrategy {
+		switch strings.ToLower(strings.ReplaceAll(s, "-", "_")) {
+		case "range", "round_robin", "roundrobin", "rack_affinity":
+		default:
+			p.add(fmt.Sprintf("%s.partition_assignment_strategy[%d]", path, i), "unknown strategy %q", s)
+		}
+	}
+	if t.QueueCapacity < 0 {
+		p.add(path+".queue_capacity", "must not be negative")
+	}
+}
+
+// validateTLS checks that a client certificate and its key are set
+// together.
+func validateTLS(p *problems, path string, t TLSConfig) {
+	switch {
+	case t.KeyFile != "" && t.CertFile == "":
+		p.add(path+".key_file", "key_file requires cert_file")
+	case t.CertFile != "" && t.KeyFile == "":
+		p.add(path+".cert_file", "cert_file requires key_file")
+	}
+}
+
+func validateSchedule(p *problems, path string, s TopicSchedule) {
+	if s.Weight < 0 {
+		p.add(path+".weight", "must not be negative")
+	}
+	if s.MaxConcurrency < 0 {
+		p.add(path+".max_concurrency", "must not be negative")
+	}
+	if s.QueueSize < 0 {
+		p.add(path+".queue_size", "must not be negative")
+	}
+}
+
+// oneOf reports v unless it is one of allowed.
+func oneOf(p *problems, path, v string, allowed ...string) {
+	for _, a := range allowed {
+		if v == a {
+			return
+		}
+	}
+	var quoted []string
+	for _, a := range allowed {
+		if a != "" {
+			quoted = append(quoted, strconv.Quote(a))
+		}
+	}
+	p.add(path, "got %q, want one of %s", v, strings.Join(quoted, ", "))
+}
+
+// CheckIndexName reports whether name follows the Elasticsearch index
+// naming rules.
+func CheckIndexName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("must not be empty")
+	case name == "." || name == "..":
+		return fmt.Errorf("must not be . or ..")
+	case len(name) > 255:
+		return fmt.Errorf("longer than 255 bytes")
+	case strings.ContainsAny(name[:1], "-_+"):
+		return fmt.Errorf("must not start with -, _ or +")
+	case strings.ToLower(name) != name:
+		return fmt.Errorf("must be lowercase")
+	}
+	if i := strings.IndexAny(name, "\\/*?\"<>| ,#:"); i >= 0 {
+		return fmt.Errorf("must not contain %q", name[i])
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,346 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	path := writeConfig(t, `kafka:
+  brokers: ["k:9092"]
+  grup_id: typo
+worker:
+  num_workers: 2
+  sched:
+    enabled: true
+`)
+	_, err := Load(path)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Load() error = %v, want *ValidationError", err)
+	}
+	if len(verr.Errors) != 2 {
+		t.Fatalf("got %d errors, want 2: %v", len(verr.Errors), err)
+	}
+	if e := verr.Errors[0]; e.Path != "kafka.grup_id" || e.Line != 3 {
+		t.Errorf("first error = %+v", e)
+	}
+	if e := verr.Errors[1]; e.Path != "worker.sched" || e.Line != 6 {
+		t.Errorf("second error = %+v", e)
+	}
+}
+
+func TestValidateCollectsErrors(t *testing.T) {
+	path := writeConfig(t, `kafka:
+  brokers: []
+  group_id: g
+  topics: ["orders", "orders"]
+  start_offset: middle
+  topic_overrides:
+    payments:
+      max_bytes: 100
+mappings:
+  orders: Orders
+  payments: payments
+worker:
+  num_workers: -1
+  dispatch: random
+es:
+  addresses: ["http://es:9200"]
+admin:
+  addr: ":9091"
+  token: ""
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	err = cfg.Validate()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Validate() error = %v, want *ValidationError", err)
+	}
+	want := map[string]int{
+		"kafka.brokers":                  2,
+		"kafka.topics[1]":                4,
+		"kafka.start_offset":             5,
+		"kafka.topic_overrides.payments": 7,
+		"mappings.orders":                10,
+		"mappings.payments":              11,
+		"worker.num_workers":             13,
+		"worker.dispatch":                14,
+		"admin.token":                    19,
+	}
+	got := make(map[string]int)
+	for _, e := range verr.Errors {
+		got[e.Path] = e.Line
+	}
+	for path, line := range want {
+		if l, ok := got[path]; !ok || l != line {
+			t.Errorf("missing error for %s at line %d (got line %d, present %v)", path, line, l, ok)
+		}
+	}
+	if !strings.Contains(err.Error(), "line 10: mappings.orders: invalid index name") {
+		t.Errorf("unexpected message:\n%v", err)
+	}
+}
+
+func TestValidatePipelines(t *testing.T) {
+	path := writeConfig(t, `kafka:
+  brokers: ["k:9092"]
+  group_id: g
+  topics: ["orders"]
+mappings:
+  audit.login: audit
+pipelines:
+  - name: audit
+    topic_patterns: ["audit.*"]
+    index: "audit-{topic}-{date:2006.01.02}"
+    action: update
+    document_id: key
+  - name: audit
+    topics: ["orders"]
+    index: "Bad-{topic}"
+    decoder: xml
+  - name: deletes
+    topics: ["orders"]
+    action: delete
+    transforms:
+      - op: rename
+        field: payload.id
+es:
+  addresses: ["http://es:9200"]
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	err = cfg.Validate()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Validate() error = %v, want *ValidationError", err)
+	}
+	want := map[string]int{
+		"pipelines[1].name":             13,
+		"pipelines[1].index":            15,
+		"pipelines[1].decoder":          0,
+		"pipelines[2].topics[0]":        18,
+		"pipelines[2].action":           19,
+		"pipelines[2].transforms[0].to": 0,
+	}
+	got := make(map[string]int)
+	for _, e := range verr.Errors {
+		got[e.Path] = e.Line
+	}
+	for path, line := range want {
+		if l, ok := got[path]; !ok || (line > 0 && l != line) {
+			t.Errorf("missing error for %s at line %d (got line %d, present %v)", path, line, l, ok)
+		}
+	}
+	if len(verr.Errors) != len(want) {
+		t.Errorf("got %d errors, want %d:\n%v", len(verr.Errors), len(want), err)
+	}
+	if p := cfg.Pipelines[0]; p.NumWorkers != cfg.Worker.NumWorkers || p.Decoder != "json" || p.FlushInterval != cfg.Worker.FlushInterval {
+		t.Errorf("pipeline did not inherit the worker defaults: %+v", p)
+	}
+}
+
+func TestValidateExactlyOnceDocumentID(t *testing.T) {
+	path := writeConfig(t, `kafka:
+  brokers: ["k:9092"]
+  static_partitions:
+    orders: [0]
+  checkpoint:
+    store: elasticsearch
+    exactly_once: true
+worker:
+  document_id: uuid
+es:
+  addresses: ["http://es:9200"]
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	var verr *ValidationError
+	if err := cfg.Validate(); !errors.As(err, &verr) {
+		t.Fatalf("Validate() error = %v, want *ValidationError", err)
+	}
+	for _, e := range verr.Errors {
+		if e.Path == "worker.document_id" {
+			if e.Line != 9 {
+				t.Errorf("document_id error at line %d, want 9", e.Line)
+			}
+			return
+		}
+	}
+	t.Errorf("missing error for worker.document_id:\n%v", verr)
+}
+
+func TestValidateControl(t *testing.T) {
+	base := "kafka:\n  brokers: [k:9092]\n  group_id: g\n  topics: [orders]\nes:\n  addresses: [\"http://es:9200\"]\n"
+	for body, want := range map[string]string{
+		"control:\n  topic: control\n  status_topic: control-status\n": "",
+		"control:\n  topic: orders\n":                                  "line 8: control.topic: topic \"orders\" is also consumed as data",
+		"control:\n  topic: control\n  status_topic: control\n":        "line 9: control.status_topic: must differ from control.topic",
+		"control:\n  status_topic: control-status\n":                   "line 8: control.status_topic: requires control.topic",
+	} {
+		cfg, err := Load(writeConfig(t, base+body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cfg.Validate()
+		switch {
+		case want == "" && err != nil:
+			t.Errorf("%s: Validate() error = %v", body, err)
+		case want != "" && (err == nil || !strings.Contains(err.Error(), want)):
+			t.Errorf("%s: Validate() error = %v, want %q", body, err, want)
+		}
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	base := "kafka:\n  brokers: [k:9092]\n  group_id: g\n  topics: [orders]\nes:\n  addresses: [\"http://es:9200\"]\n  tls:\n"
+	for body, want := range map[string]string{
+		"    cert_file: /c.pem\n    key_file: /k.pem\n": "",
+		"    key_file: /k.pem\n":                        "line 8: es.tls.key_file: key_file requires cert_file",
+		"    cert_file: /c.pem\n":                       "line 8: es.tls.cert_file: cert_file requires key_file",
+	} {
+		cfg, err := Load(writeConfig(t, base+body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cfg.Validate()
+		switch {
+		case want == "" && err != nil:
+			t.Errorf("%s: Validate() error = %v", body, err)
+		case want != "" && (err == nil || !strings.Contains(err.Error(), want)):
+			t.Errorf("%s: Validate() error = %v, want %q", body, err, want)
+		}
+	}
+}
+
+func TestValidateRepoConfig(t *testing.T) {
+	cfg, err := Load("../../config.yaml")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestCheckIndexName(t *testing.T) {
+	for _, name := range []string{"logs-2024.05", "a", "index_b"} {
+		if err := CheckIndexName(name); err != nil {
+			t.Errorf("CheckIndexName(%q) = %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "..", "Logs", "-x", "_x", "a b", "a/b", "a:b", "a*", strings.Repeat("a", 256)} {
+		if err := CheckIndexName(name); err == nil {
+			t.Errorf("CheckIndexName(%q) accepted", name)
+		}
+	}
+}
+
+func TestValidateIsolatedPipelines(t *testing.T) {
+	path := writeConfig(t, `kafka:
+  brokers: ["k:9092"]
+  group_id: g
+  topics: ["orders"]
+pipelines:
+  - name: same-group
+    topics: ["orders", "clicks"]
+    kafka:
+      max_wait: 1s
+  - name: static
+    topics: ["logs"]
+    kafka:
+      group_id: logs
+      topics: ["logs"]
+      static_partitions: {logs: [0]}
+      topic_overrides:
+        other: {max_bytes: 1MB}
+  - name: elsewhere
+    topics: ["metrics"]
+    kafka:
+      brokers: ["other:9092"]
+      group_id: g
+    es:
+      max_retries: -1
+es:
+  addresses: ["http://es:9200"]
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	err = cfg.Validate()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Validate() error = %v, want *ValidationError", err)
+	}
+	want := map[string]int{
+		"pipelines[0].topics[0]":                   7,
+		"pipelines[0].kafka.group_id":              0,
+		"pipelines[1].kafka.topics":                14,
+		"pipelines[1].kafka.static_partitions":     15,
+		"pipelines[1].kafka.topic_overrides.other": 17,
+		"pipelines[2].es.max_retries":              24,
+	}
+	got := make(map[string]int)
+	for _, e := range verr.Errors {
+		got[e.Path] = e.Line
+	}
+	for path, line := range want {
+		if l, ok := got[path]; !ok || l != line {
+			t.Errorf("missing error for %s at line %d (got line %d, present %v)", path, line, l, ok)
+		}
+	}
+	if len(verr.Errors) != len(want) {
+		t.Errorf("got %d errors, want %d:\n%v", len(verr.Errors), len(want), err)
+	}
+
+	// Without topics of its own, the top-level section only supplies
+	// defaults and needs no brokers or group.
+	path = writeConfig(t, `pipelines:
+  - name: logs
+    topics: ["logs"]
+    kafka:
+      brokers: ["k:9092"]
+      group_id: logs
+    es:
+      addresses: ["http://es:9200"]
+  - name: metrics
+    topics: ["metrics"]
+    kafka:
+      brokers: ["k:9092"]
+`)
+	cfg, err = Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	err = cfg.Validate()
+	if !errors.As(err, &verr) {
+		t.Fatalf("Validate() error = %v, want *ValidationError", err)
+	}
+	if len(verr.Errors) != 2 || verr.Errors[0].Path != "pipelines[1].es.addresses" || verr.Errors[1].Path != "pipelines[1].kafka.group_id" {
+		t.Errorf("unexpected errors:\n%v", err)
+	}
+}
+
+func TestSchemaIsPublished(t *testing.T) {
+	want, err := JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("../../" + SchemaID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s is out of date; run make schema", SchemaID)
+	}
+}
@@ -0,0 +1,130 @@
+package esclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// ErrIndexNotAlias is returned by SwapAliases when the alias name is taken by
+// a concrete index.
+var ErrIndexNotAlias = errors.New("name is an index, not an alias")
+
+// CreateIndex creates an index, applying any matching index templates. An
+// index that already exists is not an error.
+func CreateIndex(ctx context.Context, es *elasticsearch.Client, name string) error {
+	res, err := es.Indices.Create(name, es.Indices.Create.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("create index %s: %w", name, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() && !strings.Contains(res.String(), "resource_already_exists_exception") {
+		return fmt.Errorf("create index %s: %s", name, res.String())
+	}
+	return nil
+}
+
+// AliasIndices returns the indices an alias points to, sorted. It returns
+// ErrIndexNotAlias if name is a concrete index.
+func AliasIndices(ctx context.Context, es *elasticsearch.Client, name string) ([]string, error) {
+	res, err := es.Indices.GetAlias(es.Indices.GetAlias.WithName(name), es.Indices.GetAlias.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("get alias %s: %w", name, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		exists, err := es.Indices.Exists([]string{name}, es.Indices.Exists.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("check index %s: %w", name, err)
+		}
+		exists.Body.Close()
+		if exists.StatusCode == http.StatusOK {
+			return nil, fmt.Errorf("%s: %w", name, ErrIndexNotAlias)
+		}
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("get alias %s: %s", name, res.String())
+	}
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode alias %s: %w", name, err)
+	}
+	indices := make([]string, 0, len(body))
+	for idx := range body {
+		indices = append(indices, idx)
+	}
+	sort.Strings(indices)
+	return indices, nil
+}
+
+// SwapAlias points alias at index in one atomic request, removing it from
+// every index it pointed to before. If the alias name is taken by a concrete
+// index, that index is deleted in the same request when replaceIndex is set
+// and ErrIndexNotAlias is returned otherwise. It returns the indices the
+// alias pointed to before.
+func SwapAlias(ctx context.Context, es *elasticsearch.Client, alias, index string, replaceIndex bool) ([]string, error) {
+	previous, err := SwapAliases(ctx, es, map[string]string{alias: index}, replaceIndex)
+	if err != nil {
+		return nil, err
+	}
+	return previous[alias], nil
+}
+
+// SwapAliases is like SwapAlias for several aliases, given as alias ->
+// index, and moves all of them in a single atomic request: either every
+// alias points at its new index afterwards or none does. It returns the
+// indices each alias pointed to before.
+func SwapAliases(ctx context.Context, es *elasticsearch.Client, targets map[string]string, replaceIndex bool) (map[string][]string, error) {
+	aliases := make([]string, 0, len(targets))
+	for alias := range targets {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	var actions []map[string]any
+	previous := make(map[string][]string, len(targets))
+	for _, alias := range aliases {
+		index := targets[alias]
+		indices, err := AliasIndices(ctx, es, alias)
+		switch {
+		case errors.Is(err, ErrIndexNotAlias) && replaceIndex:
+			indices = []string{alias}
+			actions = append(actions, map[string]any{"remove_index": map[string]string{"index": alias}})
+		case err != nil:
+			return nil, err
+		default:
+			for _, idx := range indices {
+				if idx != index {
+					actions = append(actions, map[string]any{"remove": map[string]string{"index": idx, "alias": alias}})
+				}
+			}
+		}
+		previous[alias] = indices
+		actions = append(actions, map[string]any{"add": map[string]any{"index": index, "alias": alias, "is_write_index": true}})
+	}
+	if len(actions) == 0 {
+		return previous, nil
+	}
+
+	body, err := json.Marshal(map[string]any{"actions": actions})
+	if err != nil {
+		return nil, err
+	}
+	res, err := es.Indices.UpdateAliases(bytes.NewReader(body), es.Indices.UpdateAliases.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("update aliases: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("update aliases: %s", res.String())
+	}
+	return previous, nil
+}
@@ -0,0 +1,133 @@
+package esclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gor0utine/kafka-to-es/internal/config"
+)
+
+// aliasServer fakes the alias endpoints. aliases maps index -> alias; a
+// concrete index named like the alias is listed in indices.
+func aliasServer(t *testing.T, aliases map[string]string, indices map[string]bool, actions *[]map[string]map[string]any) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		switch {
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/_alias/"):
+			name := strings.TrimPrefix(r.URL.Path, "/_alias/")
+			body := make(map[string]any)
+			for idx, a := range aliases {
+				if a == name {
+					body[idx] = map[string]any{"aliases": map[string]any{name: map[string]any{}}}
+				}
+			}
+			if len(body) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{}`))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(body)
+		case r.Method == http.MethodHead:
+			if !indices[r.URL.Path[1:]] {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case r.Method == http.MethodPost && r.URL.Path == "/_aliases":
+			b, _ := io.ReadAll(r.Body)
+			var req struct {
+				Actions []map[string]map[string]any `json:"actions"`
+			}
+			if err := json.Unmarshal(b, &req); err != nil {
+				t.Errorf("decode actions: %v", err)
+			}
+			*actions = req.Actions
+			_, _ = w.Write([]byte(`{"acknowledged":true}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+}
+
+func TestSwapAliasMovesAlias(t *testing.T) {
+	var actions []map[string]map[string]any
+	srv := aliasServer(t, map[string]string{"orders-1": "orders", "orders-2": "orders"}, nil, &actions)
+	defer srv.Close()
+	es, err := New(config.ESConfig{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous, err := SwapAlias(context.Background(), es, "orders", "orders-3", false)
+	if err != nil {
+		t.Fatalf("SwapAlias() error = %v", err)
+	}
+	if want := []string{"orders-1", "orders-2"}; !reflect.DeepEqual(previous, want) {
+		t.Errorf("previous = %v, want %v", previous, want)
+	}
+	if len(actions) != 3 {
+		t.Fatalf("got %d actions, want 3: %v", len(actions), actions)
+	}
+	for i, idx := range []string{"orders-1", "orders-2"} {
+		if got := actions[i]["remove"]["index"]; got != idx {
+			t.Errorf("action %d removes %v, want %s", i, got, idx)
+		}
+	}
+	if got := actions[2]["add"]["index"]; got != "orders-3" {
+		t.Errorf("add action targets %v, want orders-3", got)
+	}
+}
+
+func TestSwapAliasConcreteIndex(t *testing.T) {
+	var actions []map[string]map[string]any
+	srv := aliasServer(t, nil, map[string]bool{"orders": true}, &actions)
+	defer srv.Close()
+	es, err := New(config.ESConfig{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := SwapAlias(context.Background(), es, "orders", "orders-2", false); !errors.Is(err, ErrIndexNotAlias) {
+		t.Fatalf("SwapAlias() error = %v, want ErrIndexNotAlias", err)
+	}
+	if actions != nil {
+		t.Fatalf("aliases updated without replaceIndex: %v", actions)
+	}
+
+	if _, err := SwapAlias(context.Background(), es, "orders", "orders-2", true); err != nil {
+		t.Fatalf("SwapAlias(replaceIndex) error = %v", err)
+	}
+	if len(actions) != 2 || actions[0]["remove_index"]["index"] != "orders" || actions[1]["add"]["alias"] != "orders" {
+		t.Errorf("unexpected actions %v", actions)
+	}
+}
+
+func TestSwapAliasesSingleRequest(t *testing.T) {
+	var actions []map[string]map[string]any
+	srv := aliasServer(t, map[string]string{"orders-1": "orders", "users-1": "users"}, nil, &actions)
+	defer srv.Close()
+	es, err := New(config.ESConfig{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous, err := SwapAliases(context.Background(), es, map[string]string{"users": "users-2", "orders": "orders-2"}, false)
+	if err != nil {
+		t.Fatalf("SwapAliases() error = %v", err)
+	}
+	want := map[string][]string{"orders": {"orders-1"}, "users": {"users-1"}}
+	if !reflect.DeepEqual(previous, want) {
+		t.Errorf("previous = %v, want %v", previous, want)
+	}
+	// The fake server keeps the actions of the last request only.
+	if len(actions) != 4 || actions[1]["add"]["index"] != "orders-2" || actions[3]["add"]["index"] != "users-2" {
+		t.Errorf("expected both aliases moved in one request, got %v", actions)
+	}
+}
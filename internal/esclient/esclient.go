@@ -0,0 +1,86 @@
+// Package esclient builds Elasticsearch clients from configuration.
+package esclient
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+
+	"github.com/gor0utine/kafka-to-es/internal/config"
+)
+
+// New creates an Elasticsearch client with the transport tuned according to cfg.
+func New(cfg config.ESConfig) (*elasticsearch.Client, error) {
+	esCfg, err := NewConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	es, err := elasticsearch.NewClient(esCfg)
+	if err != nil {
+		return nil, fmt.Errorf("create es client: %w", err)
+	}
+	return es, nil
+}
+
+// NewConfig translates cfg into an elasticsearch.Config.
+func NewConfig(cfg config.ESConfig) (elasticsearch.Config, error) {
+	tlsCfg, err := cfg.TLS.Build()
+	if err != nil {
+		return elasticsearch.Config{}, fmt.Errorf("es tls: %w", err)
+	}
+	tr := newTransport(cfg)
+	if tlsCfg != nil {
+		tr.TLSClientConfig = tlsCfg
+	}
+	esCfg := elasticsearch.Config{
+		Addresses:                cfg.Addresses,
+		CloudID:                  cfg.CloudID,
+		APIKey:                   cfg.APIKey.Value,
+		ServiceToken:             cfg.ServiceToken.Value,
+		CertificateFingerprint:   cfg.CertificateFingerprint,
+		CompressRequestBody:      cfg.CompressRequestBody,
+		CompressRequestBodyLevel: cfg.CompressionLevel,
+		PoolCompressor:           cfg.CompressRequestBody,
+		RetryOnStatus:            cfg.RetryOnStatus,
+		MaxRetries:               cfg.MaxRetries,
+		DisableRetry:             cfg.DisableRetry,
+		DiscoverNodesOnStart:     cfg.DiscoverNodesOnStart,
+		DiscoverNodesInterval:    cfg.DiscoverNodesInterval,
+		Transport:                tr,
+	}
+	if cfg.Username != "" {
+		esCfg.Username = cfg.Username
+		esCfg.Password = cfg.Password.Value
+	}
+	return esCfg, nil
+}
+
+// newTransport returns an HTTP transport sized for sustained bulk traffic.
+func newTransport(cfg config.ESConfig) *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.MaxIdleConnsPerHost > 0 {
+		tr.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+		if tr.MaxIdleConns < cfg.MaxIdleConnsPerHost {
+			tr.MaxIdleConns = cfg.MaxIdleConnsPerHost
+		}
+	}
+	if cfg.MaxConnsPerHost > 0 {
+		tr.MaxConnsPerHost = cfg.MaxConnsPerHost
+	}
+	if cfg.DialTimeout > 0 {
+		tr.DialContext = (&net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	}
+	if cfg.TLSHandshakeTimeout > 0 {
+		tr.TLSHandshakeTimeout = cfg.TLSHandshakeTimeout
+	}
+	if cfg.IdleConnTimeout > 0 {
+		tr.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+	if cfg.ResponseHeaderTimeout > 0 {
+		tr.ResponseHeaderTimeout = cfg.ResponseHeaderTimeout
+	}
+	return tr
+}
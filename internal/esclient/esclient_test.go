@@ -0,0 +1,117 @@
+package esclient
+
+import (
+	"compress/gzip"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gor0utine/kafka-to-es/internal/config"
+)
+
+func TestNewCompressesRequestBody(t *testing.T) {
+	var gotEncoding, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncoding = r.Header.Get("Content-Encoding")
+		zr, err := gzip.NewReader(r.Body)
+		if err == nil {
+			b, _ := io.ReadAll(zr)
+			gotBody = string(b)
+		}
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		_, _ = w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer srv.Close()
+
+	es, err := New(config.ESConfig{Addresses: []string{srv.URL}, CompressRequestBody: true})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	res, err := es.Bulk(strings.NewReader("{\"index\":{}}\n{}\n"))
+	if err != nil {
+		t.Fatalf("Bulk() error = %v", err)
+	}
+	res.Body.Close()
+
+	if gotEncoding != "gzip" {
+		t.Errorf("Content-Encoding = %q, want gzip", gotEncoding)
+	}
+	if gotBody != "{\"index\":{}}\n{}\n" {
+		t.Errorf("unexpected decompressed body %q", gotBody)
+	}
+}
+
+func TestNewConfigTransport(t *testing.T) {
+	cfg := config.ESConfig{
+		Addresses:             []string{"http://localhost:9200"},
+		MaxIdleConnsPerHost:   64,
+		MaxConnsPerHost:       128,
+		IdleConnTimeout:       30 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		RetryOnStatus:         []int{429, 502},
+		MaxRetries:            7,
+	}
+	esCfg, err := NewConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewConfig() error = %v", err)
+	}
+	tr, ok := esCfg.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("unexpected transport type %T", esCfg.Transport)
+	}
+	if tr.MaxIdleConnsPerHost != 64 || tr.MaxIdleConns < 64 || tr.MaxConnsPerHost != 128 {
+		t.Errorf("unexpected pool settings: %d/%d/%d", tr.MaxIdleConnsPerHost, tr.MaxIdleConns, tr.MaxConnsPerHost)
+	}
+	if tr.IdleConnTimeout != 30*time.Second || tr.ResponseHeaderTimeout != 10*time.Second {
+		t.Errorf("unexpected timeouts: %v/%v", tr.IdleConnTimeout, tr.ResponseHeaderTimeout)
+	}
+	if esCfg.MaxRetries != 7 || len(esCfg.RetryOnStatus) != 2 {
+		t.Errorf("unexpected retry settings: %d %v", esCfg.MaxRetries, esCfg.RetryOnStatus)
+	}
+}
+
+func TestNewTLSWithCAFileAndAPIKey(t *testing.T) {
+	var gotAuth string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	es, err := New(config.ESConfig{
+		Addresses: []string{srv.URL},
+		APIKey:    config.Secret{Value: "c2VjcmV0"},
+		TLS:       config.TLSConfig{CAFile: caFile},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	res, err := es.Info()
+	if err != nil {
+		t.Fatalf("Info() error = %v", err)
+	}
+	res.Body.Close()
+	if gotAuth != "APIKey c2VjcmV0" {
+		t.Errorf("Authorization = %q, want ApiKey header", gotAuth)
+	}
+}
+
+func TestNewConfigRejectsBadCAFile(t *testing.T) {
+	_, err := NewConfig(config.ESConfig{TLS: config.TLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")}})
+	if err == nil {
+		t.Fatal("expected error for missing CA file")
+	}
+}
@@ -0,0 +1,304 @@
+package esclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// ErrSecurityDisabled is returned by MissingPrivileges when Elasticsearch
+// runs without security, so that every request is allowed.
+var ErrSecurityDisabled = errors.New("security is disabled")
+
+// ClusterInfo identifies a cluster.
+type ClusterInfo struct {
+	Name    string
+	Version string
+}
+
+// Info returns the name and version of the cluster.
+func Info(ctx context.Context, es *elasticsearch.Client) (ClusterInfo, error) {
+	var body struct {
+		ClusterName string `json:"cluster_name"`
+		Version     struct {
+			Number string `json:"number"`
+		} `json:"version"`
+	}
+	res, err := es.Info(es.Info.WithContext(ctx))
+	if err := decode(res, err, "get cluster info", &body); err != nil {
+		return ClusterInfo{}, err
+	}
+	return ClusterInfo{Name: body.ClusterName, Version: body.Version.Number}, nil
+}
+
+// Health is the health of a cluster.
+type Health struct {
+	// Status is green, yellow or red.
+	Status           string `json:"status"`
+	Nodes            int    `json:"number_of_nodes"`
+	UnassignedShards int    `json:"unassigned_shards"`
+}
+
+// ClusterHealth returns the health of the cluster.
+func ClusterHealth(ctx context.Context, es *elasticsearch.Client) (Health, error) {
+	var h Health
+	res, err := es.Cluster.Health(es.Cluster.Health.WithContext(ctx))
+	if err := decode(res, err, "get cluster health", &h); err != nil {
+		return Health{}, err
+	}
+	return h, nil
+}
+
+// MissingPrivileges returns the privileges, by index, that the current user
+// lacks out of those requested by index. Index names may contain wildcards.
+// It returns ErrSecurityDisabled if security is off.
+func MissingPrivileges(ctx context.Context, es *elasticsearch.Client, privileges map[string][]string) (map[string][]string, error) {
+	type indexPrivileges struct {
+		Names      []string `json:"names"`
+		Privileges []string `json:"privileges"`
+	}
+	var req struct {
+		Index []indexPrivileges `json:"index"`
+	}
+	for index, privs := range privileges {
+		req.Index = append(req.Index, indexPrivileges{Names: []string{index}, Privileges: privs})
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	res, err := es.Security.HasPrivileges(bytes.NewReader(b), es.Security.HasPrivileges.WithContext(ctx))
+	if err == nil && res.IsError() {
+		msg := res.String()
+		res.Body.Close()
+		if enabled, serr := securityEnabled(ctx, es); serr == nil && !enabled {
+			return nil, ErrSecurityDisabled
+		}
+		return nil, fmt.Errorf("check privileges: %s", msg)
+	}
+	var body struct {
+		Index map[string]map[string]bool `json:"index"`
+	}
+	if err := decode(res, err, "check privileges", &body); err != nil {
+		return nil, err
+	}
+	missing := make(map[string][]string)
+	for index, privs := range privileges {
+		for _, p := range privs {
+			if !body.Index[index][p] {
+				missing[index] = append(missing[index], p)
+			}
+		}
+		sort.Strings(missing[index])
+	}
+	for index, privs := range missing {
+		if len(privs) == 0 {
+			delete(missing, index)
+		}
+	}
+	return missing, nil
+}
+
+// securityEnabled reports whether the security feature is enabled.
+func securityEnabled(ctx context.Context, es *elasticsearch.Client) (bool, error) {
+	var body struct {
+		Features struct {
+			Security struct {
+				Enabled bool `json:"enabled"`
+			} `json:"security"`
+		} `json:"features"`
+	}
+	res, err := es.XPack.Info(es.XPack.Info.WithCategories("features"), es.XPack.Info.WithContext(ctx))
+	if err := decode(res, err, "get features", &body); err != nil {
+		return false, err
+	}
+	return body.Features.Security.Enabled, nil
+}
+
+// IndexExists reports whether index, or an alias or data stream of that
+// name, exists.
+func IndexExists(ctx context.Context, es *elasticsearch.Client, index string) (bool, error) {
+	res, err := es.Indices.Exists([]string{index}, es.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return false, fmt.Errorf("check index %s: %w", index, err)
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("check index %s: %s", index, res.Status())
+	}
+}
+
+// IndexTemplate is a composable index template.
+type IndexTemplate struct {
+	Name     string
+	Patterns []string
+	Priority int
+	// Pipelines are the default and final ingest pipelines the template
+	// sets, if any.
+	Pipelines []string
+}
+
+// MatchingTemplate returns the index template that applies when index is
+// created, the matching one with the highest priority, or nil if there is
+// none.
+func MatchingTemplate(ctx context.Context, es *elasticsearch.Client, index string) (*IndexTemplate, error) {
+	var body struct {
+		IndexTemplates []struct {
+			Name          string `json:"name"`
+			IndexTemplate struct {
+				IndexPatterns []string `json:"index_patterns"`
+				Priority      int      `json:"priority"`
+				Template      struct {
+					Settings map[string]any `json:"settings"`
+				} `json:"template"`
+			} `json:"index_template"`
+		} `json:"index_templates"`
+	}
+	res, err := es.Indices.GetIndexTemplate(es.Indices.GetIndexTemplate.WithContext(ctx))
+	if res != nil && res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, nil
+	}
+	if err := decode(res, err, "get index templates", &body); err != nil {
+		return nil, err
+	}
+	var best *IndexTemplate
+	for _, t := range body.IndexTemplates {
+		it := t.IndexTemplate
+		if best != nil && it.Priority <= best.Priority {
+			continue
+		}
+		for _, p := range it.IndexPatterns {
+			if ok, _ := path.Match(p, index); ok {
+				best = &IndexTemplate{
+					Name:      t.Name,
+					Patterns:  it.IndexPatterns,
+					Priority:  it.Priority,
+					Pipelines: settingsPipelines(flatten("", it.Template.Settings)),
+				}
+				break
+			}
+		}
+	}
+	return best, nil
+}
+
+// IndexPipelines returns the ingest pipelines set on the existing indices
+// matching index, or nil if there are none.
+func IndexPipelines(ctx context.Context, es *elasticsearch.Client, index string) ([]string, error) {
+	var body map[string]struct {
+		Settings map[string]any `json:"settings"`
+	}
+	res, err := es.Indices.GetSettings(
+		es.Indices.GetSettings.WithIndex(index),
+		es.Indices.GetSettings.WithFlatSettings(true),
+		es.Indices.GetSettings.WithAllowNoIndices(true),
+		es.Indices.GetSettings.WithContext(ctx),
+	)
+	if res != nil && res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, nil
+	}
+	if err := decode(res, err, "get settings of "+index, &body); err != nil {
+		return nil, err
+	}
+	var pipelines []string
+	for _, idx := range body {
+		for _, p := range settingsPipelines(flatten("", idx.Settings)) {
+			if !slices.Contains(pipelines, p) {
+				pipelines = append(pipelines, p)
+			}
+		}
+	}
+	sort.Strings(pipelines)
+	return pipelines, nil
+}
+
+// MissingPipelines returns the ingest pipelines of ids that do not exist.
+func MissingPipelines(ctx context.Context, es *elasticsearch.Client, ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var body map[string]json.RawMessage
+	res, err := es.Ingest.GetPipeline(es.Ingest.GetPipeline.WithPipelineID(strings.Join(ids, ",")), es.Ingest.GetPipeline.WithContext(ctx))
+	if res != nil && res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return ids, nil
+	}
+	if err := decode(res, err, "get ingest pipelines", &body); err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, id := range ids {
+		if _, ok := body[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
+// settingsPipelines returns the default and final pipelines named by flat
+// index settings. "_none" disables a pipeline.
+func settingsPipelines(settings map[string]string) []string {
+	var pipelines []string
+	for _, key := range []string{"index.default_pipeline", "index.final_pipeline"} {
+		if p := settings[key]; p != "" && p != "_none" {
+			pipelines = append(pipelines, p)
+		}
+	}
+	return pipelines
+}
+
+// flatten turns nested settings into dotted keys prefixed with "index.",
+// the form Elasticsearch accepts them in either way.
+func flatten(prefix string, settings map[string]any) map[string]string {
+	out := make(map[string]string)
+	for k, v := range settings {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		switch v := v.(type) {
+		case map[string]any:
+			for fk, fv := range flatten(key, v) {
+				out[fk] = fv
+			}
+		default:
+			if prefix == "" && !strings.HasPrefix(key, "index.") && key != "index" {
+				key = "index." + key
+			}
+			out[key] = fmt.Sprint(v)
+		}
+	}
+	return out
+}
+
+// decode reads the JSON body of a successful response into v.
+func decode(res *esapi.Response, err error, what string, v any) error {
+	if err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("%s: %s", what, res.String())
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode %s: %w", what, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package esclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gor0utine/kafka-to-es/internal/config"
+)
+
+// healthServer fakes the endpoints used by the health checks. With security
+// off, _has_privileges fails as it does on a real cluster.
+func healthServer(t *testing.T, security bool) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/":
+			_, _ = w.Write([]byte(`{"cluster_name":"logs","version":{"number":"8.14.0"}}`))
+		case "/_cluster/health":
+			_, _ = w.Write([]byte(`{"status":"yellow","number_of_nodes":1,"unassigned_shards":3}`))
+		case "/_security/user/_has_privileges":
+			if !security {
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = w.Write([]byte(`{"error":{"type":"exception","reason":"Security must be explicitly enabled"}}`))
+				return
+			}
+			var req struct {
+				Index []struct {
+					Names      []string `json:"names"`
+					Privileges []string `json:"privileges"`
+				} `json:"index"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Errorf("decode privileges request: %v", err)
+			}
+			index := make(map[string]map[string]bool)
+			for _, ip := range req.Index {
+				for _, name := range ip.Names {
+					index[name] = make(map[string]bool)
+					for _, p := range ip.Privileges {
+						index[name][p] = name != "audit" || p == "read"
+					}
+				}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"index": index})
+		case "/_xpack":
+			_, _ = w.Write([]byte(`{"features":{"security":{"available":true,"enabled":false}}}`))
+		case "/_index_template":
+			_, _ = w.Write([]byte(`{"index_templates":[
+				{"name":"logs","index_template":{"index_patterns":["logs-*"],"priority":1}},
+				{"name":"logs-app","index_template":{"index_patterns":["logs-app-*"],"priority":10,
+					"template":{"settings":{"index":{"default_pipeline":"parse"},"final_pipeline":"stamp"}}}}]}`))
+		case "/orders/_settings":
+			_, _ = w.Write([]byte(`{"orders-1":{"settings":{"index.default_pipeline":"_none","index.final_pipeline":"stamp"}}}`))
+		case "/_ingest/pipeline/parse,stamp":
+			_, _ = w.Write([]byte(`{"stamp":{"processors":[]}}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+}
+
+func TestClusterChecks(t *testing.T) {
+	srv := healthServer(t, true)
+	defer srv.Close()
+	es, err := New(config.ESConfig{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if info, err := Info(ctx, es); err != nil || info != (ClusterInfo{Name: "logs", Version: "8.14.0"}) {
+		t.Errorf("Info() = %+v, %v", info, err)
+	}
+	if h, err := ClusterHealth(ctx, es); err != nil || h != (Health{Status: "yellow", Nodes: 1, UnassignedShards: 3}) {
+		t.Errorf("ClusterHealth() = %+v, %v", h, err)
+	}
+
+	missing, err := MissingPrivileges(ctx, es, map[string][]string{"orders": {"index"}, "audit": {"read", "index", "delete"}})
+	if want := map[string][]string{"audit": {"delete", "index"}}; err != nil || !reflect.DeepEqual(missing, want) {
+		t.Errorf("MissingPrivileges() = %v, %v; want %v", missing, err, want)
+	}
+
+	tmpl, err := MatchingTemplate(ctx, es, "logs-app-2024.05.01")
+	if err != nil || tmpl == nil || tmpl.Name != "logs-app" || !reflect.DeepEqual(tmpl.Pipelines, []string{"parse", "stamp"}) {
+		t.Errorf("MatchingTemplate() = %+v, %v", tmpl, err)
+	}
+	if tmpl, err := MatchingTemplate(ctx, es, "metrics"); err != nil || tmpl != nil {
+		t.Errorf("MatchingTemplate(metrics) = %+v, %v", tmpl, err)
+	}
+	if p, err := IndexPipelines(ctx, es, "orders"); err != nil || !reflect.DeepEqual(p, []string{"stamp"}) {
+		t.Errorf("IndexPipelines() = %v, %v", p, err)
+	}
+	if m, err := MissingPipelines(ctx, es, []string{"parse", "stamp"}); err != nil || !reflect.DeepEqual(m, []string{"parse"}) {
+		t.Errorf("MissingPipelines() = %v, %v", m, err)
+	}
+}
+
+func TestMissingPrivilegesWithoutSecurity(t *testing.T) {
+	srv := healthServer(t, false)
+	defer srv.Close()
+	es, err := New(config.ESConfig{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MissingPrivileges(context.Background(), es, map[string][]string{"orders": {"index"}}); !errors.Is(err, ErrSecurityDisabled) {
+		t.Errorf("MissingPrivileges() error = %v, want ErrSecurityDisabled", err)
+	}
+}
@@ -0,0 +1,145 @@
+// Package flow bounds the amount of consumed data that has not yet been
+// indexed.
+package flow
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/gor0utine/kafka-to-es/internal/metrics"
+)
+
+// Config sets the in-flight budget of a Controller.
+type Config struct {
+	// MaxBytes pauses fetching once this many message bytes are in flight.
+	// Zero disables the byte limit.
+	MaxBytes int64
+	// MaxMessages pauses fetching once this many messages are in flight.
+	// Zero disables the count limit.
+	MaxMessages int64
+	// LowWatermark is the fraction of both limits that in-flight data must
+	// drop below before fetching resumes. Defaults to 0.5.
+	LowWatermark float64
+	// Metrics receives the flow metrics; nil uses the top-level registry.
+	Metrics *metrics.Registry
+}
+
+// Controller pauses consumers while too much data is in flight. Consumers
+// call Wait before fetching, Acquire for every fetched message and Release
+// once the message is no longer held.
+type Controller struct {
+	cfg Config
+
+	mu          sync.Mutex
+	bytes       int64
+	messages    int64
+	paused      bool
+	pausedAt    time.Time
+	resumed     chan struct{} // closed when a pause ends
+	lowBytes    int64
+	lowMessages int64
+
+	pausedGauge   *expvar.Int
+	pauses        *expvar.Int
+	pauseDuration *metrics.Timer
+	bytesGauge    *expvar.Int
+	messagesGauge *expvar.Int
+}
+
+// New creates a Controller.
+func New(cfg Config) *Controller {
+	if cfg.LowWatermark <= 0 || cfg.LowWatermark >= 1 {
+		cfg.LowWatermark = 0.5
+	}
+	reg := cfg.Metrics
+	return &Controller{
+		cfg:           cfg,
+		lowBytes:      int64(float64(cfg.MaxBytes) * cfg.LowWatermark),
+		lowMessages:   int64(float64(cfg.MaxMessages) * cfg.LowWatermark),
+		pausedGauge:   reg.Gauge("flow_paused"),
+		pauses:        reg.Counter("flow_pauses"),
+		pauseDuration: reg.Timer("flow_pause_duration"),
+		bytesGauge:    reg.Gauge("flow_inflight_bytes"),
+		messagesGauge: reg.Gauge("flow_inflight_messages"),
+	}
+}
+
+// Wait blocks while the controller is paused.
+func (c *Controller) Wait(ctx context.Context) error {
+	c.mu.Lock()
+	if !c.paused {
+		c.mu.Unlock()
+		return nil
+	}
+	ch := c.resumed
+	c.mu.Unlock()
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Acquire records a fetched message of the given size and pauses the
+// controller if a limit is exceeded.
+func (c *Controller) Acquire(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bytes += int64(size)
+	c.messages++
+	c.publishLocked()
+	if c.paused {
+		return
+	}
+	if (c.cfg.MaxBytes > 0 && c.bytes > c.cfg.MaxBytes) ||
+		(c.cfg.MaxMessages > 0 && c.messages > c.cfg.MaxMessages) {
+		c.paused = true
+		c.pausedAt = time.Now()
+		c.resumed = make(chan struct{})
+		c.pausedGauge.Set(1)
+		c.pauses.Add(1)
+	}
+}
+
+// Release records that a message acquired with the given size is no longer
+// held, resuming the controller once in-flight data is below the low
+// watermark.
+func (c *Controller) Release(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bytes -= int64(size)
+	c.messages--
+	c.publishLocked()
+	if !c.paused {
+		return
+	}
+	if (c.cfg.MaxBytes <= 0 || c.bytes <= c.lowBytes) &&
+		(c.cfg.MaxMessages <= 0 || c.messages <= c.lowMessages) {
+		c.paused = false
+		close(c.resumed)
+		c.pausedGauge.Set(0)
+		c.pauseDuration.Observe(time.Since(c.pausedAt))
+	}
+}
+
+// Paused reports whether fetching is currently paused.
+func (c *Controller) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// InFlight returns the bytes and messages currently in flight.
+func (c *Controller) InFlight() (bytes, messages int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes, c.messages
+}
+
+func (c *Controller) publishLocked() {
+	c.bytesGauge.Set(c.bytes)
+	c.messagesGauge.Set(c.messages)
+}
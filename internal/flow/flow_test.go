@@ -0,0 +1,64 @@
+package flow
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestControllerPausesAndResumesAtLowWatermark(t *testing.T) {
+	c := New(Config{MaxBytes: 100, MaxMessages: 1000, LowWatermark: 0.5})
+	for i := 0; i < 11; i++ {
+		c.Acquire(10)
+	}
+	if !c.Paused() {
+		t.Fatal("expected pause above 100 bytes")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.Wait(ctx); err == nil {
+		t.Fatal("Wait() returned while paused")
+	}
+
+	// 110 -> 60 bytes: below the limit but above the low watermark.
+	for i := 0; i < 5; i++ {
+		c.Release(10)
+	}
+	if !c.Paused() {
+		t.Fatal("resumed above the low watermark")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.Wait(context.Background()) }()
+	c.Release(10)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait() did not return after resume")
+	}
+	if b, m := c.InFlight(); b != 50 || m != 5 {
+		t.Errorf("InFlight() = %d, %d; want 50, 5", b, m)
+	}
+}
+
+func TestControllerMessageLimit(t *testing.T) {
+	c := New(Config{MaxMessages: 2})
+	c.Acquire(1)
+	c.Acquire(1)
+	if c.Paused() {
+		t.Fatal("paused at the limit")
+	}
+	c.Acquire(1)
+	if !c.Paused() {
+		t.Fatal("expected pause above 2 messages")
+	}
+	c.Release(1)
+	c.Release(1)
+	if c.Paused() {
+		t.Fatal("expected resume at 1 message")
+	}
+}
@@ -4,60 +4,235 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
 	"github.com/elastic/go-elasticsearch/v8/esutil"
+
+	"github.com/gor0utine/kafka-to-es/internal/metrics"
+)
+
+// Bulk actions accepted in Item.Action.
+const (
+	ActionIndex  = "index"
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
 )
 
 // Item represents a document to index
 type Item struct {
 	Index string
 	ID    string
-	Body  json.RawMessage
+	// Action is the bulk action, ActionIndex if empty. Update items carry
+	// the full update body, such as {"doc":...,"doc_as_upsert":true}; delete
+	// items have no body.
+	Action string
+	Body   json.RawMessage
+	// OnDone, if set, is called once Elasticsearch has reported a result
+	// for the item, with nil on success. Errors for which Temporary is true
+	// mean the item was not indexed but may be on a later attempt, so it
+	// must not be acknowledged.
+	OnDone func(err error)
+}
+
+// action returns the bulk action of the item.
+func (it Item) action() string {
+	if it.Action == "" {
+		return ActionIndex
+	}
+	return it.Action
+}
+
+// done reports the outcome of the item to OnDone.
+func (it Item) done(err error) {
+	if it.OnDone != nil {
+		it.OnDone(err)
+	}
+}
+
+// Indexer queues items for bulk indexing. It is implemented by Bulker, which
+// keeps one BulkIndexer per index, and by Pipeline, which shares a single bulk
+// stream across all indices.
+type Indexer interface {
+	Add(ctx context.Context, it Item) error
+	Close(ctx context.Context) error
+}
+
+// ErrRequestFailed is reported to OnDone for items of a bulk request that
+// failed as a whole, such as on a connection error or a 5xx response.
+var ErrRequestFailed = errors.New("bulk request failed")
+
+// ErrItemThrottled is reported to OnDone for items Elasticsearch rejected
+// with a status that may pass on a later attempt: 429, such as an
+// es_rejected_execution_exception under load, or 502 to 504.
+var ErrItemThrottled = errors.New("bulk item rejected temporarily")
+
+// Temporary reports whether an error passed to OnDone may pass on a later
+// attempt.
+func Temporary(err error) bool {
+	return errors.Is(err, ErrRequestFailed) || errors.Is(err, ErrItemThrottled)
 }
 
+// itemError returns the error of an item Elasticsearch did not index.
+func itemError(info esutil.BulkIndexerResponseItem) error {
+	err := fmt.Errorf("%s: %s", info.Error.Type, info.Error.Reason)
+	switch info.Status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return fmt.Errorf("%w: status %d: %v", ErrItemThrottled, info.Status, err)
+	}
+	return err
+}
+
+// ErrBulkerClosed is returned by Bulker.Add after Close has been called.
+var ErrBulkerClosed = errors.New("bulker closed")
+
+// ErrNoIndexer is returned by Bulker.FlushIndex for an index without an open
+// indexer.
+var ErrNoIndexer = errors.New("no open bulk indexer")
+
 // Bulker manages bulk indexing for multiple indices.
 type Bulker struct {
 	es         *elasticsearch.Client
-	indexers   map[string]esutil.BulkIndexer
+	indexers   map[string]*indexerEntry
+	closed     bool // guarded by mu
 	mu         sync.RWMutex
 	numWorkers int
 	flushBytes int
 	flushIntv  time.Duration
+	idleTTL    time.Duration
+	stop       chan struct{}
+	stopOnce   sync.Once
+	done       chan struct{}
+	// retired sums the statistics of closed indexers by index; guarded by
+	// mu.
+	retired map[string]esutil.BulkIndexerStats
+
+	liveIndexers    *expvar.Int
+	evictedIndexers *expvar.Int
+}
+
+// indexerEntry is a BulkIndexer together with its usage bookkeeping. Add holds
+// mu for reading while it uses bi; eviction takes it for writing before the
+// indexer is closed, so an evicted indexer is never written to.
+type indexerEntry struct {
+	bi       esutil.BulkIndexer
+	mu       sync.RWMutex
+	closed   bool
+	lastUsed atomic.Int64
+}
+
+func newIndexerEntry(bi esutil.BulkIndexer) *indexerEntry {
+	e := &indexerEntry{bi: bi}
+	e.touch()
+	return e
+}
+
+func (e *indexerEntry) touch() {
+	e.lastUsed.Store(time.Now().UnixNano())
+}
+
+func (e *indexerEntry) idleSince() time.Time {
+	return time.Unix(0, e.lastUsed.Load())
+}
+
+// close marks the entry closed once no Add is using it and closes the indexer.
+func (e *indexerEntry) close(ctx context.Context) error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	e.mu.Unlock()
+	return e.bi.Close(ctx)
+}
+
+// Option configures a Bulker.
+type Option func(*Bulker)
+
+// WithIdleTTL makes the Bulker flush, close and forget indexers that have not
+// received an item for the given duration. Zero disables eviction.
+func WithIdleTTL(ttl time.Duration) Option {
+	return func(b *Bulker) {
+		b.idleTTL = ttl
+	}
+}
+
+// WithMetrics makes the Bulker report its metrics to reg instead of the
+// top-level registry.
+func WithMetrics(reg *metrics.Registry) Option {
+	return func(b *Bulker) {
+		b.liveIndexers = reg.Gauge("bulker_indexers_live")
+		b.evictedIndexers = reg.Counter("bulker_indexers_evicted")
+	}
+}
+
+// WithOrdered makes every per-index indexer use a single flush worker, so
+// items added from one goroutine reach Elasticsearch in the order they were
+// added.
+func WithOrdered() Option {
+	return func(b *Bulker) {
+		b.numWorkers = 1
+	}
 }
 
 // NewBulker creates a new Bulker with configurable options.
-func NewBulker(es *elasticsearch.Client, numWorkers, flushBytes int, flushIntv time.Duration) *Bulker {
-	return &Bulker{
-		es:         es,
-		indexers:   make(map[string]esutil.BulkIndexer),
-		numWorkers: numWorkers,
-		flushBytes: flushBytes,
-		flushIntv:  flushIntv,
+func NewBulker(es *elasticsearch.Client, numWorkers, flushBytes int, flushIntv time.Duration, opts ...Option) *Bulker {
+	reg := metrics.Default()
+	b := &Bulker{
+		es:              es,
+		indexers:        make(map[string]*indexerEntry),
+		retired:         make(map[string]esutil.BulkIndexerStats),
+		numWorkers:      numWorkers,
+		flushBytes:      flushBytes,
+		flushIntv:       flushIntv,
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+		liveIndexers:    reg.Gauge("bulker_indexers_live"),
+		evictedIndexers: reg.Counter("bulker_indexers_evicted"),
+	}
+	for _, opt := range opts {
+		opt(b)
 	}
+	if b.idleTTL > 0 {
+		go b.evictLoop()
+	} else {
+		close(b.done)
+	}
+	return b
 }
 
-// getIndexerForIndex returns or creates a BulkIndexer for a specific index.
-func (b *Bulker) getIndexerForIndex(index string) (esutil.BulkIndexer, error) {
+// getIndexerForIndex returns or creates the indexer entry for a specific index.
+func (b *Bulker) getIndexerForIndex(index string) (*indexerEntry, error) {
 	b.mu.RLock()
-	bi, ok := b.indexers[index]
+	e, ok := b.indexers[index]
 	b.mu.RUnlock()
 	if ok {
-		return bi, nil
+		return e, nil
 	}
 
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrBulkerClosed
+	}
 	// Double-check after acquiring write lock
-	if bi, ok := b.indexers[index]; ok {
-		return bi, nil
+	if e, ok := b.indexers[index]; ok {
+		return e, nil
 	}
 	cfg := esutil.BulkIndexerConfig{
-		Client:        b.es,
+		Client:        failTransport{b.es},
 		Index:         index,
 		NumWorkers:    b.numWorkers,
 		FlushBytes:    b.flushBytes,
@@ -67,50 +242,272 @@ func (b *Bulker) getIndexerForIndex(index string) (esutil.BulkIndexer, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create bulk indexer for %s: %w", index, err)
 	}
-	b.indexers[index] = bi
-	return bi, nil
+	e = newIndexerEntry(bi)
+	b.indexers[index] = e
+	b.liveIndexers.Add(1)
+	return e, nil
 }
 
 // Add adds an item to the bulk queue for indexing.
 func (b *Bulker) Add(ctx context.Context, it Item) error {
-	bi, err := b.getIndexerForIndex(it.Index)
-	if err != nil {
+	for {
+		e, err := b.getIndexerForIndex(it.Index)
+		if err != nil {
+			return err
+		}
+		e.mu.RLock()
+		if e.closed {
+			// Evicted between lookup and use; a fresh indexer will be created.
+			e.mu.RUnlock()
+			continue
+		}
+		e.touch()
+		err = e.bi.Add(ctx, b.bulkItem(it))
+		e.mu.RUnlock()
 		return err
 	}
-	return bi.Add(ctx, esutil.BulkIndexerItem{
-		Action:     "index",
+}
+
+func (b *Bulker) bulkItem(it Item) esutil.BulkIndexerItem {
+	var body io.ReadSeeker
+	if it.action() != ActionDelete {
+		body = bytes.NewReader(it.Body)
+	}
+	return esutil.BulkIndexerItem{
+		Action:     it.action(),
 		DocumentID: it.ID,
-		Body:       bytes.NewReader(it.Body),
+		Body:       body,
 		OnSuccess: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
 			slog.Info("bulk index success",
 				"index", it.Index,
 				"id", it.ID,
 				"version", res.Version,
 			)
+			it.done(nil)
 		},
 		OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, resp esutil.BulkIndexerResponseItem, err error) {
+			if resp.Error.Type == requestFailedType {
+				it.done(fmt.Errorf("%w: %s", ErrRequestFailed, resp.Error.Reason))
+				return
+			}
 			slog.Error("bulk index failure",
 				"index", it.Index,
 				"id", it.ID,
 				"error", err,
 				"response", resp,
 			)
+			if err == nil {
+				err = itemError(resp)
+			}
+			it.done(err)
 		},
-	})
+	}
+}
+
+// requestFailedType is the error type failTransport gives the items of a
+// bulk request that failed as a whole.
+const requestFailedType = "bulk_request_failed"
+
+// failTransport turns a bulk request that failed as a whole, which a
+// BulkIndexer only reports to its OnError callback, into a response in which
+// every item failed with requestFailedType, so each item's OnFailure is
+// called.
+type failTransport struct {
+	esapi.Transport
+}
+
+func (t failTransport) Perform(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	res, err := t.Transport.Perform(req)
+	var reason string
+	switch {
+	case err != nil:
+		reason = err.Error()
+	case res.StatusCode > 299:
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		res.Body.Close()
+		reason = strings.TrimSpace(res.Status + " " + string(msg))
+	default:
+		return res, nil
+	}
+	slog.Error("bulk request failed", "path", req.URL.Path, "error", reason)
+	return failedResponse(body, reason)
+}
+
+// failedResponse builds a bulk response that reports every item of body as
+// failed with requestFailedType.
+func failedResponse(body []byte, reason string) (*http.Response, error) {
+	type failure struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	}
+	var f failure
+	f.Status = http.StatusServiceUnavailable
+	f.Error.Type = requestFailedType
+	f.Error.Reason = reason
+
+	var items []map[string]failure
+	lines := bytes.Split(body, []byte("\n"))
+	for i := 0; i < len(lines); i++ {
+		if len(bytes.TrimSpace(lines[i])) == 0 {
+			continue
+		}
+		var meta map[string]json.RawMessage
+		if err := json.Unmarshal(lines[i], &meta); err != nil {
+			return nil, fmt.Errorf("bulk request body: %w", err)
+		}
+		for action := range meta {
+			items = append(items, map[string]failure{action: f})
+			if action != ActionDelete {
+				i++
+			}
+		}
+	}
+	b, err := json.Marshal(map[string]any{"errors": true, "items": items})
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(b)),
+	}, nil
+}
+
+// evictLoop periodically evicts indexers idle for longer than idleTTL.
+func (b *Bulker) evictLoop() {
+	defer close(b.done)
+	interval := b.idleTTL / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case now := <-ticker.C:
+			b.evictIdle(now)
+		}
+	}
+}
+
+// evictIdle removes indexers idle since before now-idleTTL, then flushes and
+// closes them outside the map lock.
+func (b *Bulker) evictIdle(now time.Time) {
+	cutoff := now.Add(-b.idleTTL)
+	var idle map[string]*indexerEntry
+
+	b.mu.Lock()
+	for idx, e := range b.indexers {
+		if e.idleSince().Before(cutoff) {
+			if idle == nil {
+				idle = make(map[string]*indexerEntry)
+			}
+			idle[idx] = e
+			delete(b.indexers, idx)
+		}
+	}
+	b.mu.Unlock()
+
+	for idx, e := range idle {
+		if err := b.retire(context.Background(), idx, e); err != nil {
+			slog.Error("error closing idle bulk indexer", "index", idx, "error", err)
+		}
+		b.evictedIndexers.Add(1)
+		slog.Info("evicted idle bulk indexer", "index", idx, "idle_ttl", b.idleTTL)
+	}
+}
+
+// FlushIndex sends the queued items of index and waits until Elasticsearch
+// has answered for them. A BulkIndexer cannot flush on demand, so the
+// index's indexer is closed and the next item for the index opens a new one.
+func (b *Bulker) FlushIndex(ctx context.Context, index string) error {
+	b.mu.Lock()
+	e, ok := b.indexers[index]
+	delete(b.indexers, index)
+	b.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w for %s", ErrNoIndexer, index)
+	}
+	return b.retire(ctx, index, e)
 }
 
-// Close flushes and closes all bulk indexers.
+// retire closes an indexer that was removed from the map and keeps its
+// statistics.
+func (b *Bulker) retire(ctx context.Context, index string, e *indexerEntry) error {
+	err := e.close(ctx)
+	b.liveIndexers.Add(-1)
+	b.mu.Lock()
+	b.retired[index] = addStats(b.retired[index], e.bi.Stats())
+	b.mu.Unlock()
+	return err
+}
+
+// IndexStats returns the statistics of every index the Bulker has written
+// to, including those of indexers that were evicted or flushed since.
+func (b *Bulker) IndexStats() map[string]esutil.BulkIndexerStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	stats := make(map[string]esutil.BulkIndexerStats, len(b.retired)+len(b.indexers))
+	for idx, s := range b.retired {
+		stats[idx] = s
+	}
+	for idx, e := range b.indexers {
+		stats[idx] = addStats(stats[idx], e.bi.Stats())
+	}
+	return stats
+}
+
+func addStats(a, b esutil.BulkIndexerStats) esutil.BulkIndexerStats {
+	a.NumAdded += b.NumAdded
+	a.NumFlushed += b.NumFlushed
+	a.NumFailed += b.NumFailed
+	a.NumIndexed += b.NumIndexed
+	a.NumCreated += b.NumCreated
+	a.NumUpdated += b.NumUpdated
+	a.NumDeleted += b.NumDeleted
+	a.NumRequests += b.NumRequests
+	a.FlushedBytes += b.FlushedBytes
+	return a
+}
+
+// Close flushes and closes all bulk indexers. Items added after Close are
+// rejected with ErrBulkerClosed.
 func (b *Bulker) Close(ctx context.Context) error {
+	b.stopOnce.Do(func() { close(b.stop) })
+	<-b.done
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	b.closed = true
 	var firstErr error
-	for idx, bi := range b.indexers {
-		if err := bi.Close(ctx); err != nil {
+	for idx, e := range b.indexers {
+		if err := e.close(ctx); err != nil {
 			slog.Error("error closing bulk indexer", "index", idx, "error", err)
 			if firstErr == nil {
 				firstErr = err
 			}
 		}
+		b.retired[idx] = addStats(b.retired[idx], e.bi.Stats())
+		delete(b.indexers, idx)
+		b.liveIndexers.Add(-1)
 	}
 	return firstErr
 }
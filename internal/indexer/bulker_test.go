@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
 	"time"
@@ -36,7 +38,11 @@ func (m *mockBulkIndexer) Close(ctx context.Context) error {
 }
 
 // The following methods are required to satisfy the interface but are not used in Bulker.
-func (m *mockBulkIndexer) Stats() esutil.BulkIndexerStats  { return esutil.BulkIndexerStats{} }
+func (m *mockBulkIndexer) Stats() esutil.BulkIndexerStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return esutil.BulkIndexerStats{NumAdded: uint64(len(m.added))}
+}
 func (m *mockBulkIndexer) Flush(ctx context.Context) error { return nil }
 
 func TestBulker_AddAndClose(t *testing.T) {
@@ -45,7 +51,7 @@ func TestBulker_AddAndClose(t *testing.T) {
 
 	// Patch getIndexerForIndex to use our mock
 	mockIdx := &mockBulkIndexer{}
-	b.indexers["test-index"] = mockIdx
+	b.indexers["test-index"] = newIndexerEntry(mockIdx)
 
 	body, _ := json.Marshal(map[string]string{"foo": "bar"})
 	item := Item{
@@ -80,7 +86,7 @@ func TestBulker_getIndexerForIndexCreatesNew(t *testing.T) {
 	es := &elasticsearch.Client{}
 	b := NewBulker(es, 1, 1024, time.Second)
 	// Remove all indexers to force creation
-	b.indexers = make(map[string]esutil.BulkIndexer)
+	b.indexers = make(map[string]*indexerEntry)
 	// Should create a new indexer (real one, but we just check error)
 	_, err := b.getIndexerForIndex("new-index")
 	if err != nil {
@@ -92,7 +98,7 @@ func TestBulker_CloseError(t *testing.T) {
 	es := &elasticsearch.Client{}
 	b := NewBulker(es, 1, 1024, time.Second)
 	mockIdx := &mockBulkIndexer{closeErr: errors.New("close fail")}
-	b.indexers["fail-index"] = mockIdx
+	b.indexers["fail-index"] = newIndexerEntry(mockIdx)
 	ctx := context.Background()
 	err := b.Close(ctx)
 	if err == nil || err.Error() != "close fail" {
@@ -100,13 +106,189 @@ func TestBulker_CloseError(t *testing.T) {
 	}
 }
 
+func TestBulker_ConcurrentClose(t *testing.T) {
+	b := NewBulker(&elasticsearch.Client{}, 1, 1024, time.Second, WithIdleTTL(time.Hour))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = b.Close(context.Background())
+		}()
+	}
+	wg.Wait()
+}
+
 func TestBulker_AddWithRealIndexer(t *testing.T) {
-	// This test ensures Add works with a real BulkIndexer (no ES connection needed)
-	es := &elasticsearch.Client{}
-	b := NewBulker(es, 1, 1024, time.Millisecond)
+	// This test ensures Add works with a real BulkIndexer against a fake _bulk endpoint
+	quietLogs(t)
+	srv := newBulkServer(t)
+	b := NewBulker(newTestClient(t, srv.URL), 1, 1024, time.Millisecond)
 	body := json.RawMessage(`{"foo":"bar"}`)
 	item := Item{Index: "real-index", ID: "id2", Body: body}
 	ctx := context.Background()
 	// Should not panic or error
 	_ = b.Add(ctx, item)
+	if err := b.Close(ctx); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestBulker_RequestFailure(t *testing.T) {
+	quietLogs(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		http.Error(w, `{"error":"unavailable"}`, http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	if err != nil {
+		t.Fatalf("es client: %v", err)
+	}
+	b := NewBulker(es, 1, 1024, time.Hour)
+	ctx := context.Background()
+	var got error
+	item := Item{Index: "i", ID: "1", Body: json.RawMessage(`{}`), OnDone: func(err error) { got = err }}
+	if err := b.Add(ctx, item); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	_ = b.Close(ctx)
+	if !errors.Is(got, ErrRequestFailed) {
+		t.Errorf("OnDone error = %v, want ErrRequestFailed", got)
+	}
+}
+
+func TestFailedResponse(t *testing.T) {
+	body := "{\"index\":{\"_id\":\"1\"}}\n{}\n{\"delete\":{\"_id\":\"2\"}}\n{\"update\":{\"_id\":\"3\"}}\n{\"doc\":{}}\n"
+	res, err := failedResponse([]byte(body), "503 Service Unavailable")
+	if err != nil {
+		t.Fatalf("failedResponse() error = %v", err)
+	}
+	var blk esutil.BulkIndexerResponse
+	if err := json.NewDecoder(res.Body).Decode(&blk); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := []string{"index", "delete", "update"}
+	if len(blk.Items) != len(want) {
+		t.Fatalf("got %d items, want %d", len(blk.Items), len(want))
+	}
+	for i, action := range want {
+		info, ok := blk.Items[i][action]
+		if !ok || info.Error.Type != requestFailedType {
+			t.Errorf("item %d = %+v, want failed %s", i, blk.Items[i], action)
+		}
+	}
+}
+
+func TestBulker_EvictIdle(t *testing.T) {
+	es := &elasticsearch.Client{}
+	b := NewBulker(es, 1, 1024, time.Second)
+	idle := &mockBulkIndexer{}
+	busy := &mockBulkIndexer{}
+	b.indexers["idle-index"] = newIndexerEntry(idle)
+	b.indexers["busy-index"] = newIndexerEntry(busy)
+	b.idleTTL = time.Minute
+	b.indexers["idle-index"].lastUsed.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+	evictedBefore := b.evictedIndexers.Value()
+
+	b.evictIdle(time.Now())
+
+	if _, ok := b.indexers["idle-index"]; ok {
+		t.Error("expected idle-index to be evicted")
+	}
+	if _, ok := b.indexers["busy-index"]; !ok {
+		t.Error("expected busy-index to be kept")
+	}
+	if !idle.closeOk {
+		t.Error("expected evicted indexer to be closed")
+	}
+	if busy.closeOk {
+		t.Error("expected busy indexer to stay open")
+	}
+	if got := b.evictedIndexers.Value() - evictedBefore; got != 1 {
+		t.Errorf("expected 1 eviction, got %d", got)
+	}
+}
+
+func TestBulker_AddRecreatesEvictedIndexer(t *testing.T) {
+	quietLogs(t)
+	srv := newBulkServer(t)
+	b := NewBulker(newTestClient(t, srv.URL), 1, 1024, time.Millisecond, WithIdleTTL(time.Nanosecond))
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if err := b.Add(ctx, Item{Index: "rotating", ID: "x", Body: json.RawMessage(`{}`)}); err != nil {
+					t.Errorf("Add() error = %v", err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		b.evictIdle(time.Now())
+	}
+	wg.Wait()
+	if err := b.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if n := srv.indices["/rotating/_bulk"]; n != 200 {
+		t.Errorf("expected 200 indexed items, got %d", n)
+	}
+}
+
+func TestBulker_AddAfterClose(t *testing.T) {
+	b := NewBulker(&elasticsearch.Client{}, 1, 1024, time.Second)
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	err := b.Add(context.Background(), Item{Index: "idx", Body: []byte(`{}`)})
+	if !errors.Is(err, ErrBulkerClosed) {
+		t.Errorf("Add() after Close error = %v, want ErrBulkerClosed", err)
+	}
+}
+
+func TestBulker_FlushIndex(t *testing.T) {
+	b := NewBulker(&elasticsearch.Client{}, 1, 1024, time.Second)
+	first := &mockBulkIndexer{}
+	b.indexers["idx"] = newIndexerEntry(first)
+	ctx := context.Background()
+	if err := b.Add(ctx, Item{Index: "idx", Body: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.FlushIndex(ctx, "idx"); err != nil {
+		t.Fatalf("FlushIndex() error = %v", err)
+	}
+	if !first.closeOk {
+		t.Error("expected flushed indexer to be closed")
+	}
+	if err := b.FlushIndex(ctx, "idx"); !errors.Is(err, ErrNoIndexer) {
+		t.Errorf("FlushIndex() without indexer error = %v, want ErrNoIndexer", err)
+	}
+
+	// Statistics of the flushed indexer are kept and summed with its
+	// successor's.
+	second := &mockBulkIndexer{}
+	b.indexers["idx"] = newIndexerEntry(second)
+	for i := 0; i < 2; i++ {
+		if err := b.Add(ctx, Item{Index: "idx", Body: []byte(`{}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := b.IndexStats()["idx"].NumAdded; got != 3 {
+		t.Errorf("IndexStats() added = %d, want 3", got)
+	}
+	if err := b.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.IndexStats()["idx"].NumAdded; got != 3 {
+		t.Errorf("IndexStats() after Close added = %d, want 3", got)
+	}
 }
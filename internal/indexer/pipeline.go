@@ -0,0 +1,440 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+// ErrPipelineClosed is returned by Pipeline.Add after Close has been called.
+var ErrPipelineClosed = errors.New("bulk pipeline closed")
+
+// PipelineConfig configures a shared bulk Pipeline.
+type PipelineConfig struct {
+	// Concurrency is the maximum number of _bulk requests in flight across
+	// all indices.
+	Concurrency int
+	// FlushBytes is the request body size at which a batch is sent.
+	FlushBytes int
+	// FlushInterval is the maximum time an item waits before being sent.
+	FlushInterval time.Duration
+	// MaxBufferedBytes bounds the memory held by items that have been added
+	// but not yet acknowledged by Elasticsearch. Add blocks while the budget
+	// is exhausted. Defaults to Concurrency+1 times FlushBytes.
+	MaxBufferedBytes int
+	// Ordered sends batches strictly one after another in the order they
+	// were cut, so items added from one goroutine are applied in order.
+	// It implies a Concurrency of 1.
+	Ordered bool
+}
+
+// Pipeline sends items for any number of indices through a single _bulk
+// stream. Each item carries its own _index, so there is one buffer, one set of
+// flush goroutines and one memory budget no matter how many indices are
+// written to.
+type Pipeline struct {
+	client esapi.Transport
+	cfg    PipelineConfig
+
+	mu      sync.Mutex
+	buf     *bytes.Buffer
+	pending []Item
+	closed  bool
+
+	sem    chan struct{}
+	seq    uint64 // next batch sequence number, guarded by mu
+	turns  *sequencer
+	budget *byteBudget
+	wg     sync.WaitGroup
+	stop   chan struct{}
+	done   chan struct{}
+
+	stats pipelineStats
+}
+
+type pipelineStats struct {
+	numAdded     atomic.Uint64
+	numFlushed   atomic.Uint64
+	numFailed    atomic.Uint64
+	numRequests  atomic.Uint64
+	flushedBytes atomic.Uint64
+}
+
+// NewPipeline creates a Pipeline and starts its periodic flusher.
+func NewPipeline(client esapi.Transport, cfg PipelineConfig) *Pipeline {
+	if cfg.Concurrency <= 0 || cfg.Ordered {
+		cfg.Concurrency = 1
+	}
+	if cfg.FlushBytes <= 0 {
+		cfg.FlushBytes = 5_000_000
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 30 * time.Second
+	}
+	if cfg.MaxBufferedBytes <= 0 {
+		cfg.MaxBufferedBytes = (cfg.Concurrency + 1) * cfg.FlushBytes
+	}
+	p := &Pipeline{
+		client: client,
+		cfg:    cfg,
+		buf:    bytes.NewBuffer(make([]byte, 0, cfg.FlushBytes)),
+		sem:    make(chan struct{}, cfg.Concurrency),
+		turns:  &sequencer{ch: make(chan struct{})},
+		budget: newByteBudget(int64(cfg.MaxBufferedBytes)),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go p.flushLoop()
+	return p
+}
+
+// Add queues an item for indexing. It blocks while the memory budget or the
+// request concurrency limit is exhausted.
+func (p *Pipeline) Add(ctx context.Context, it Item) error {
+	meta, err := bulkMeta(it.action(), it.Index, it.ID)
+	if err != nil {
+		return fmt.Errorf("encode bulk metadata for %s: %w", it.Index, err)
+	}
+	hasBody := it.action() != ActionDelete
+	size := len(meta)
+	if hasBody {
+		size += len(it.Body) + 1
+	}
+	if err := p.budget.acquire(ctx, int64(size)); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		p.budget.release(int64(size))
+		return ErrPipelineClosed
+	}
+	p.buf.Write(meta)
+	if hasBody {
+		p.buf.Write(it.Body)
+		p.buf.WriteByte('\n')
+	}
+	p.pending = append(p.pending, it)
+	p.stats.numAdded.Add(1)
+	var b *batch
+	if p.buf.Len() >= p.cfg.FlushBytes {
+		b = p.takeLocked()
+	}
+	p.mu.Unlock()
+
+	if b != nil {
+		return p.send(ctx, b)
+	}
+	return nil
+}
+
+// Flush sends whatever is currently buffered and waits for every in-flight
+// request to complete.
+func (p *Pipeline) Flush(ctx context.Context) error {
+	p.mu.Lock()
+	b := p.takeLocked()
+	p.mu.Unlock()
+	if b != nil {
+		if err := p.send(ctx, b); err != nil {
+			return err
+		}
+	}
+	return p.wait(ctx)
+}
+
+// Close flushes all buffered items, waits for in-flight requests and stops
+// the pipeline. Items added after Close are rejected.
+func (p *Pipeline) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	b := p.takeLocked()
+	p.mu.Unlock()
+
+	close(p.stop)
+	<-p.done
+	if b != nil {
+		if err := p.send(ctx, b); err != nil {
+			return err
+		}
+	}
+	return p.wait(ctx)
+}
+
+// Stats returns pipeline statistics.
+func (p *Pipeline) Stats() esutil.BulkIndexerStats {
+	return esutil.BulkIndexerStats{
+		NumAdded:     p.stats.numAdded.Load(),
+		NumFlushed:   p.stats.numFlushed.Load(),
+		NumFailed:    p.stats.numFailed.Load(),
+		NumIndexed:   p.stats.numFlushed.Load(),
+		NumRequests:  p.stats.numRequests.Load(),
+		FlushedBytes: p.stats.flushedBytes.Load(),
+	}
+}
+
+// batch is one _bulk request body and the items it carries.
+type batch struct {
+	seq   uint64
+	body  []byte
+	items []Item
+}
+
+// takeLocked detaches the current buffer. The caller must hold p.mu.
+func (p *Pipeline) takeLocked() *batch {
+	if len(p.pending) == 0 {
+		return nil
+	}
+	b := &batch{seq: p.seq, body: make([]byte, p.buf.Len()), items: p.pending}
+	copy(b.body, p.buf.Bytes())
+	p.seq++
+	p.buf.Reset()
+	p.pending = nil
+	return b
+}
+
+// send waits for a concurrency slot and dispatches one _bulk request. In
+// ordered mode batches also wait for their turn, so they take the single
+// slot in sequence.
+func (p *Pipeline) send(ctx context.Context, b *batch) error {
+	if p.cfg.Ordered {
+		if err := p.turns.wait(ctx, b.seq); err != nil {
+			p.fail(b.items, b.body, err)
+			// Give up the turn once it comes so later batches are not stuck.
+			go func() {
+				_ = p.turns.wait(context.Background(), b.seq)
+				p.turns.advance()
+			}()
+			return err
+		}
+		defer p.turns.advance()
+	}
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		p.fail(b.items, b.body, ctx.Err())
+		return ctx.Err()
+	}
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.sem
+			p.wg.Done()
+		}()
+		p.do(b.body, b.items)
+	}()
+	return nil
+}
+
+func (p *Pipeline) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pipeline) flushLoop() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			b := p.takeLocked()
+			p.mu.Unlock()
+			if b != nil {
+				_ = p.send(context.Background(), b)
+			}
+		}
+	}
+}
+
+// do performs a single _bulk request and reports the outcome of every item.
+func (p *Pipeline) do(body []byte, items []Item) {
+	p.stats.numRequests.Add(1)
+	req := esapi.BulkRequest{Body: bytes.NewReader(body)}
+	res, err := req.Do(context.Background(), p.client)
+	if err != nil {
+		p.fail(items, body, fmt.Errorf("bulk request: %w", err))
+		return
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		p.fail(items, body, fmt.Errorf("bulk request: %s", res.String()))
+		return
+	}
+
+	var blk esutil.BulkIndexerResponse
+	if err := json.NewDecoder(res.Body).Decode(&blk); err != nil {
+		p.fail(items, body, fmt.Errorf("decode bulk response: %w", err))
+		return
+	}
+	p.stats.flushedBytes.Add(uint64(len(body)))
+	for i, it := range items {
+		var info esutil.BulkIndexerResponseItem
+		if i < len(blk.Items) {
+			for _, v := range blk.Items[i] {
+				info = v
+			}
+		}
+		if info.Error.Type != "" || info.Status > 201 {
+			p.stats.numFailed.Add(1)
+			slog.Error("bulk index failure",
+				"index", it.Index,
+				"id", it.ID,
+				"error", info.Error.Reason,
+				"response", info,
+			)
+			it.done(itemError(info))
+		} else {
+			p.stats.numFlushed.Add(1)
+			slog.Info("bulk index success",
+				"index", it.Index,
+				"id", it.ID,
+				"version", info.Version,
+			)
+			it.done(nil)
+		}
+	}
+	p.budget.release(int64(len(body)))
+}
+
+// fail reports every item of a batch as failed with ErrRequestFailed and
+// frees its memory.
+func (p *Pipeline) fail(items []Item, body []byte, err error) {
+	p.stats.numFailed.Add(uint64(len(items)))
+	indices := make(map[string]int)
+	for _, it := range items {
+		indices[it.Index]++
+	}
+	slog.Error("bulk request failed", "items", len(items), "indices", indices, "error", err)
+	p.budget.release(int64(len(body)))
+	err = fmt.Errorf("%w: %v", ErrRequestFailed, err)
+	for _, it := range items {
+		it.done(err)
+	}
+}
+
+// bulkMeta encodes the action line for a single bulk item.
+func bulkMeta(action, index, id string) ([]byte, error) {
+	var sb strings.Builder
+	sb.WriteString(`{"`)
+	sb.WriteString(action)
+	sb.WriteString(`":{"_index":`)
+	idx, err := json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	sb.Write(idx)
+	if id != "" {
+		sb.WriteString(`,"_id":`)
+		b, err := json.Marshal(id)
+		if err != nil {
+			return nil, err
+		}
+		sb.Write(b)
+	}
+	sb.WriteString("}}\n")
+	return []byte(sb.String()), nil
+}
+
+// byteBudget is a context-aware counting semaphore measured in bytes.
+type byteBudget struct {
+	mu    sync.Mutex
+	used  int64
+	max   int64
+	freed chan struct{}
+}
+
+func newByteBudget(max int64) *byteBudget {
+	return &byteBudget{max: max, freed: make(chan struct{})}
+}
+
+// acquire reserves n bytes. A single reservation larger than the whole budget
+// is admitted once nothing else is held, so oversized items cannot deadlock.
+func (b *byteBudget) acquire(ctx context.Context, n int64) error {
+	for {
+		b.mu.Lock()
+		if b.used == 0 || b.used+n <= b.max {
+			b.used += n
+			b.mu.Unlock()
+			return nil
+		}
+		freed := b.freed
+		b.mu.Unlock()
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *byteBudget) release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	if b.used < 0 {
+		b.used = 0
+	}
+	close(b.freed)
+	b.freed = make(chan struct{})
+	b.mu.Unlock()
+}
+
+// sequencer hands out turns in increasing sequence order.
+type sequencer struct {
+	mu   sync.Mutex
+	next uint64
+	ch   chan struct{} // closed and replaced on every advance
+}
+
+// wait blocks until it is seq's turn.
+func (s *sequencer) wait(ctx context.Context, seq uint64) error {
+	for {
+		s.mu.Lock()
+		if s.next == seq {
+			s.mu.Unlock()
+			return nil
+		}
+		ch := s.ch
+		s.mu.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// advance passes the turn to the next sequence number.
+func (s *sequencer) advance() {
+	s.mu.Lock()
+	s.next++
+	close(s.ch)
+	s.ch = make(chan struct{})
+	s.mu.Unlock()
+}
@@ -0,0 +1,371 @@
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// bulkServer is a fake _bulk endpoint that acknowledges every item.
+type bulkServer struct {
+	*httptest.Server
+	requests atomic.Int64
+	mu       sync.Mutex
+	indices  map[string]int
+}
+
+func newBulkServer(t testing.TB) *bulkServer {
+	s := &bulkServer{indices: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		var items []map[string]map[string]any
+		sc := bufio.NewScanner(r.Body)
+		sc.Buffer(make([]byte, 0, 1<<20), 64<<20)
+		for line := 0; sc.Scan(); line++ {
+			if line%2 == 1 {
+				continue
+			}
+			var meta map[string]map[string]string
+			if err := json.Unmarshal(sc.Bytes(), &meta); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			idx := meta["index"]["_index"]
+			if idx == "" {
+				idx = r.URL.Path
+			}
+			s.mu.Lock()
+			s.indices[idx]++
+			s.mu.Unlock()
+			items = append(items, map[string]map[string]any{
+				"index": {"_index": idx, "_id": meta["index"]["_id"], "status": 201, "result": "created"},
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		_ = json.NewEncoder(w).Encode(map[string]any{"took": 1, "errors": false, "items": items})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestClient(t testing.TB, url string) *elasticsearch.Client {
+	es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{url}})
+	if err != nil {
+		t.Fatalf("es client: %v", err)
+	}
+	return es
+}
+
+func quietLogs(t testing.TB) {
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+}
+
+func TestPipeline_MultiIndexSingleStream(t *testing.T) {
+	quietLogs(t)
+	srv := newBulkServer(t)
+	p := NewPipeline(newTestClient(t, srv.URL), PipelineConfig{
+		Concurrency:   2,
+		FlushBytes:    1 << 20,
+		FlushInterval: time.Hour,
+	})
+
+	ctx := context.Background()
+	var done atomic.Int64
+	for i := 0; i < 30; i++ {
+		it := Item{Index: fmt.Sprintf("index-%d", i%3), ID: fmt.Sprint(i), Body: json.RawMessage(`{"n":1}`)}
+		it.OnDone = func(err error) {
+			if err == nil {
+				done.Add(1)
+			}
+		}
+		if err := p.Add(ctx, it); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	if err := p.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if got := srv.requests.Load(); got != 1 {
+		t.Errorf("expected 1 bulk request, got %d", got)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for i := 0; i < 3; i++ {
+		if n := srv.indices[fmt.Sprintf("index-%d", i)]; n != 10 {
+			t.Errorf("index-%d: expected 10 items, got %d", i, n)
+		}
+	}
+	if st := p.Stats(); st.NumAdded != 30 || st.NumFlushed != 30 || st.NumFailed != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	if n := done.Load(); n != 30 {
+		t.Errorf("OnDone called %d times, want 30", n)
+	}
+}
+
+func TestPipeline_FlushesOnSize(t *testing.T) {
+	quietLogs(t)
+	srv := newBulkServer(t)
+	p := NewPipeline(newTestClient(t, srv.URL), PipelineConfig{
+		Concurrency:   1,
+		FlushBytes:    100,
+		FlushInterval: time.Hour,
+	})
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		_ = p.Add(ctx, Item{Index: "i", ID: fmt.Sprint(i), Body: json.RawMessage(`{"payload":"0123456789"}`)})
+	}
+	if err := p.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got := srv.requests.Load(); got < 2 {
+		t.Errorf("expected several size-triggered requests, got %d", got)
+	}
+}
+
+func TestPipeline_RequestFailure(t *testing.T) {
+	quietLogs(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		http.Error(w, `{"error":"boom"}`, http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	p := NewPipeline(newTestClient(t, srv.URL), PipelineConfig{Concurrency: 1, FlushInterval: time.Hour})
+	ctx := context.Background()
+	var got error
+	_ = p.Add(ctx, Item{Index: "i", ID: "1", Body: json.RawMessage(`{}`), OnDone: func(err error) { got = err }})
+	if err := p.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if st := p.Stats(); st.NumFailed != 1 {
+		t.Errorf("expected 1 failed item, got %+v", st)
+	}
+	if !errors.Is(got, ErrRequestFailed) {
+		t.Errorf("OnDone error = %v, want ErrRequestFailed", got)
+	}
+}
+
+func TestPipeline_TemporaryItemFailures(t *testing.T) {
+	quietLogs(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		items := []map[string]map[string]any{
+			{"index": {"status": 429, "error": map[string]any{"type": "es_rejected_execution_exception", "reason": "queue full"}}},
+			{"index": {"status": 503, "error": map[string]any{"type": "unavailable_shards_exception", "reason": "primary not active"}}},
+			{"index": {"status": 400, "error": map[string]any{"type": "mapper_parsing_exception", "reason": "bad field"}}},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		_ = json.NewEncoder(w).Encode(map[string]any{"took": 1, "errors": true, "items": items})
+	}))
+	defer srv.Close()
+	p := NewPipeline(newTestClient(t, srv.URL), PipelineConfig{FlushBytes: 1 << 20, FlushInterval: time.Hour})
+
+	ctx := context.Background()
+	errs := make([]error, 3)
+	for i := range errs {
+		it := Item{Index: "i", ID: fmt.Sprint(i), Body: json.RawMessage(`{}`), OnDone: func(err error) { errs[i] = err }}
+		if err := p.Add(ctx, it); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, true, false} {
+		if errs[i] == nil || Temporary(errs[i]) != want {
+			t.Errorf("item %d: error %v, temporary %v, want %v", i, errs[i], Temporary(errs[i]), want)
+		}
+	}
+}
+
+func TestPipeline_AddAfterClose(t *testing.T) {
+	p := NewPipeline(&elasticsearch.Client{}, PipelineConfig{})
+	ctx := context.Background()
+	_ = p.Close(ctx)
+	if err := p.Add(ctx, Item{Index: "i", Body: json.RawMessage(`{}`)}); err != ErrPipelineClosed {
+		t.Errorf("expected ErrPipelineClosed, got %v", err)
+	}
+}
+
+func TestByteBudgetBlocksUntilRelease(t *testing.T) {
+	b := newByteBudget(10)
+	ctx := context.Background()
+	if err := b.acquire(ctx, 8); err != nil {
+		t.Fatal(err)
+	}
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := b.acquire(tctx, 8); err == nil {
+		t.Fatal("expected acquire to block past the budget")
+	}
+	go b.release(8)
+	if err := b.acquire(ctx, 8); err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+}
+
+func TestBulkMeta(t *testing.T) {
+	b, err := bulkMeta("index", `logs-"x"`, "id1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"index":{"_index":"logs-\"x\"","_id":"id1"}}` + "\n"
+	if !bytes.Equal(b, []byte(want)) {
+		t.Errorf("bulkMeta() = %s, want %s", b, want)
+	}
+}
+
+func TestPipeline_Actions(t *testing.T) {
+	quietLogs(t)
+	var body bytes.Buffer
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(&body, r.Body)
+		items := []map[string]map[string]any{
+			{"index": {"status": 201}},
+			{"delete": {"status": 200}},
+			{"update": {"status": 200}},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		_ = json.NewEncoder(w).Encode(map[string]any{"took": 1, "errors": false, "items": items})
+	}))
+	defer srv.Close()
+	p := NewPipeline(newTestClient(t, srv.URL), PipelineConfig{FlushBytes: 1 << 20, FlushInterval: time.Hour})
+
+	ctx := context.Background()
+	var done atomic.Int64
+	onDone := func(err error) {
+		if err == nil {
+			done.Add(1)
+		}
+	}
+	items := []Item{
+		{Index: "i", ID: "1", Body: json.RawMessage(`{"n":1}`), OnDone: onDone},
+		{Index: "i", ID: "2", Action: ActionDelete, OnDone: onDone},
+		{Index: "i", ID: "3", Action: ActionUpdate, Body: json.RawMessage(`{"doc":{"n":3}}`), OnDone: onDone},
+	}
+	for _, it := range items {
+		if err := p.Add(ctx, it); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"index":{"_index":"i","_id":"1"}}
+{"n":1}
+{"delete":{"_index":"i","_id":"2"}}
+{"update":{"_index":"i","_id":"3"}}
+{"doc":{"n":3}}
+`
+	if body.String() != want {
+		t.Errorf("bulk body:\n%s\nwant:\n%s", body.String(), want)
+	}
+	if n := done.Load(); n != 3 {
+		t.Errorf("OnDone called %d times, want 3", n)
+	}
+}
+
+// The benchmarks below compare the per-index Bulker with the shared Pipeline
+// when items are spread over many indices, as with daily or templated names.
+const benchIndices = 64
+
+func benchItems(b *testing.B, add func(ctx context.Context, it Item) error) {
+	body := json.RawMessage(`{"payload":{"foo":"bar","n":12345},"key":"k","topic":"t"}`)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		it := Item{Index: fmt.Sprintf("logs-%03d", i%benchIndices), ID: fmt.Sprint(i), Body: body}
+		if err := add(ctx, it); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBulkerPerIndex(b *testing.B) {
+	quietLogs(b)
+	srv := newBulkServer(b)
+	bk := NewBulker(newTestClient(b, srv.URL), 4, 64<<10, time.Second)
+	benchItems(b, bk.Add)
+	if err := bk.Close(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(srv.requests.Load()), "requests")
+}
+
+func BenchmarkPipelineShared(b *testing.B) {
+	quietLogs(b)
+	srv := newBulkServer(b)
+	p := NewPipeline(newTestClient(b, srv.URL), PipelineConfig{
+		Concurrency:   4,
+		FlushBytes:    64 << 10,
+		FlushInterval: time.Second,
+	})
+	benchItems(b, p.Add)
+	if err := p.Close(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(srv.requests.Load()), "requests")
+}
+
+func TestPipeline_OrderedSendsBatchesInSequence(t *testing.T) {
+	quietLogs(t)
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sc := bufio.NewScanner(r.Body)
+		sc.Scan()
+		var meta map[string]map[string]string
+		_ = json.Unmarshal(sc.Bytes(), &meta)
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		order = append(order, meta["index"]["_id"])
+		mu.Unlock()
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		_, _ = w.Write([]byte(`{"took":1,"errors":false,"items":[{"index":{"status":201}}]}`))
+	}))
+	defer srv.Close()
+
+	p := NewPipeline(newTestClient(t, srv.URL), PipelineConfig{
+		Concurrency:   8,
+		FlushBytes:    1, // one item per request
+		FlushInterval: time.Hour,
+		Ordered:       true,
+	})
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		if err := p.Add(ctx, Item{Index: "i", ID: fmt.Sprint(i), Body: json.RawMessage(`{}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i, id := range order {
+		if id != fmt.Sprint(i) {
+			t.Fatalf("requests out of order: %v", order)
+		}
+	}
+}
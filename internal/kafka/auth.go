@@ -0,0 +1,162 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// SASL mechanism names accepted by NewSASLMechanism.
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+	SASLOAuthBearer = "OAUTHBEARER"
+)
+
+// SASLOptions describes how to authenticate with the brokers.
+type SASLOptions struct {
+	Mechanism string
+	Username  string
+	Password  string
+	// TokenFile holds the OAUTHBEARER token. It is re-read once TokenRefresh
+	// has elapsed, so an external agent can rotate it in place.
+	TokenFile    string
+	TokenRefresh time.Duration
+}
+
+// NewSASLMechanism returns the mechanism selected by opts, or nil when no
+// mechanism is configured.
+func NewSASLMechanism(opts SASLOptions) (sasl.Mechanism, error) {
+	switch strings.ToUpper(opts.Mechanism) {
+	case "":
+		return nil, nil
+	case SASLPlain:
+		return plain.Mechanism{Username: opts.Username, Password: opts.Password}, nil
+	case SASLScramSHA256:
+		return scram.Mechanism(scram.SHA256, opts.Username, opts.Password)
+	case SASLScramSHA512:
+		return scram.Mechanism(scram.SHA512, opts.Username, opts.Password)
+	case SASLOAuthBearer:
+		if opts.TokenFile == "" {
+			return nil, fmt.Errorf("sasl %s requires a token file", SASLOAuthBearer)
+		}
+		return newOAuthBearer(opts.TokenFile, opts.TokenRefresh), nil
+	default:
+		return nil, fmt.Errorf("unsupported sasl mechanism %q", opts.Mechanism)
+	}
+}
+
+// Timeouts bounds network operations against the brokers. Zero values use
+// DefaultTimeout.
+type Timeouts struct {
+	// Dial bounds connecting to a broker, including TLS and SASL.
+	Dial time.Duration
+	// Request bounds metadata and offset requests.
+	Request time.Duration
+	// Write bounds produce requests.
+	Write time.Duration
+}
+
+// DefaultTimeout applies to every unset field of Timeouts.
+const DefaultTimeout = 10 * time.Second
+
+func (t Timeouts) withDefaults() Timeouts {
+	for _, d := range []*time.Duration{&t.Dial, &t.Request, &t.Write} {
+		if *d <= 0 {
+			*d = DefaultTimeout
+		}
+	}
+	return t
+}
+
+// NewDialer returns a dialer for readers and admin connections.
+func NewDialer(tlsCfg *tls.Config, mechanism sasl.Mechanism, timeouts Timeouts) *kafka.Dialer {
+	return &kafka.Dialer{
+		Timeout:       timeouts.withDefaults().Dial,
+		DualStack:     true,
+		TLS:           tlsCfg,
+		SASLMechanism: mechanism,
+	}
+}
+
+// NewTransport returns a transport for writers and clients.
+func NewTransport(tlsCfg *tls.Config, mechanism sasl.Mechanism, timeouts Timeouts) *kafka.Transport {
+	return &kafka.Transport{
+		DialTimeout: timeouts.withDefaults().Dial,
+		TLS:         tlsCfg,
+		SASL:        mechanism,
+	}
+}
+
+// oauthBearer implements SASL/OAUTHBEARER (RFC 7628) with a token read from
+// a file.
+type oauthBearer struct {
+	path    string
+	refresh time.Duration
+
+	mu       sync.Mutex
+	token    string
+	loadedAt time.Time
+}
+
+func newOAuthBearer(path string, refresh time.Duration) *oauthBearer {
+	if refresh <= 0 {
+		refresh = time.Minute
+	}
+	return &oauthBearer{path: path, refresh: refresh}
+}
+
+func (o *oauthBearer) Name() string { return SASLOAuthBearer }
+
+func (o *oauthBearer) Start(ctx context.Context) (sasl.StateMachine, []byte, error) {
+	token, err := o.currentToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	return oauthBearerSession{}, []byte("n,,\x01auth=Bearer " + token + "\x01\x01"), nil
+}
+
+// currentToken returns the cached token, re-reading the file when stale.
+func (o *oauthBearer) currentToken() (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.token != "" && time.Since(o.loadedAt) < o.refresh {
+		return o.token, nil
+	}
+	b, err := os.ReadFile(o.path)
+	if err != nil {
+		if o.token != "" {
+			// Keep using the previous token while the file is being rotated.
+			return o.token, nil
+		}
+		return "", fmt.Errorf("read oauthbearer token: %w", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("oauthbearer token file %s is empty", o.path)
+	}
+	o.token = token
+	o.loadedAt = time.Now()
+	return o.token, nil
+}
+
+type oauthBearerSession struct{}
+
+// Next completes the exchange. A non-empty challenge carries the broker's
+// error details for a rejected token.
+func (oauthBearerSession) Next(ctx context.Context, challenge []byte) (bool, []byte, error) {
+	if len(challenge) == 0 {
+		return true, nil, nil
+	}
+	return false, nil, fmt.Errorf("oauthbearer authentication failed: %s", challenge)
+}
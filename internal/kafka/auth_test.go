@@ -0,0 +1,78 @@
+package kafka
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewSASLMechanism(t *testing.T) {
+	tests := []struct {
+		mechanism string
+		name      string
+		wantErr   bool
+	}{
+		{"", "", false},
+		{"plain", SASLPlain, false},
+		{SASLScramSHA256, SASLScramSHA256, false},
+		{SASLScramSHA512, SASLScramSHA512, false},
+		{SASLOAuthBearer, "", true}, // token file missing
+		{"GSSAPI", "", true},
+	}
+	for _, tt := range tests {
+		m, err := NewSASLMechanism(SASLOptions{Mechanism: tt.mechanism, Username: "u", Password: "p"})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: err = %v, wantErr %v", tt.mechanism, err, tt.wantErr)
+			continue
+		}
+		if tt.name == "" {
+			if m != nil {
+				t.Errorf("%q: expected nil mechanism", tt.mechanism)
+			}
+			continue
+		}
+		if m.Name() != tt.name {
+			t.Errorf("%q: Name() = %q, want %q", tt.mechanism, m.Name(), tt.name)
+		}
+	}
+}
+
+func TestOAuthBearerRefreshesToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("first\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewSASLMechanism(SASLOptions{Mechanism: SASLOAuthBearer, TokenFile: path, TokenRefresh: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	sess, ir, err := m.Start(ctx)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if string(ir) != "n,,\x01auth=Bearer first\x01\x01" {
+		t.Errorf("unexpected initial response %q", ir)
+	}
+	if done, _, err := sess.Next(ctx, nil); !done || err != nil {
+		t.Errorf("Next() = %v, %v; want done", done, err)
+	}
+	if _, _, err := sess.Next(ctx, []byte(`{"status":"invalid_token"}`)); err == nil {
+		t.Error("expected error for rejected token")
+	}
+
+	if err := os.WriteFile(path, []byte("second"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	_, ir, err = m.Start(ctx)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if string(ir) != "n,,\x01auth=Bearer second\x01\x01" {
+		t.Errorf("token not refreshed: %q", ir)
+	}
+}
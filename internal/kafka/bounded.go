@@ -0,0 +1,352 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// boundedPollTimeout is how long a bounded reader waits for a message before
+// checking whether the rest of its range holds any messages at all.
+const boundedPollTimeout = 5 * time.Second
+
+// OffsetSpec selects a position in every partition of a topic.
+type OffsetSpec struct {
+	// Position is kafka.FirstOffset or kafka.LastOffset. It applies when Time
+	// is zero and to partitions missing from Offsets.
+	Position int64
+	// Time selects the first offset whose timestamp is at or after Time.
+	Time time.Time
+	// Offsets lists explicit offsets by topic and partition.
+	Offsets map[string]map[int]int64
+}
+
+// ParseOffsetSpec parses "earliest", "latest", an RFC 3339 timestamp or a
+// comma-separated list of explicit offsets such as "orders:0=120,orders:1=98".
+// Partitions missing from an explicit list use def.
+func ParseOffsetSpec(s string, def int64) (OffsetSpec, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return OffsetSpec{Position: def}, nil
+	case "earliest", "first":
+		return OffsetSpec{Position: kafka.FirstOffset}, nil
+	case "latest", "last", "end":
+		return OffsetSpec{Position: kafka.LastOffset}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return OffsetSpec{Position: def, Time: t}, nil
+	}
+
+	spec := OffsetSpec{Position: def, Offsets: make(map[string]map[int]int64)}
+	for _, part := range strings.Split(s, ",") {
+		tp, off, ok := strings.Cut(strings.TrimSpace(part), "=")
+		i := strings.LastIndex(tp, ":")
+		if !ok || i <= 0 {
+			return OffsetSpec{}, fmt.Errorf("invalid offset spec %q (want earliest, latest, an RFC 3339 time or topic:partition=offset,...)", s)
+		}
+		partition, err := strconv.Atoi(tp[i+1:])
+		if err != nil {
+			return OffsetSpec{}, fmt.Errorf("invalid partition in %q: %w", part, err)
+		}
+		offset, err := strconv.ParseInt(off, 10, 64)
+		if err != nil {
+			return OffsetSpec{}, fmt.Errorf("invalid offset in %q: %w", part, err)
+		}
+		topic := tp[:i]
+		if spec.Offsets[topic] == nil {
+			spec.Offsets[topic] = make(map[int]int64)
+		}
+		spec.Offsets[topic][partition] = offset
+	}
+	return spec, nil
+}
+
+// PartitionRange is the half-open offset range [Start, End) of a partition.
+type PartitionRange struct {
+	Topic     string
+	Partition int
+	Start     int64
+	End       int64
+}
+
+// RangeProgress counts the messages of a range that were consumed and
+// acknowledged.
+type RangeProgress struct {
+	PartitionRange
+	Consumed atomic.Int64
+	Acked    atomic.Int64
+}
+
+// BoundedConsumer reads fixed offset ranges without joining a consumer group,
+// so it never commits offsets or disturbs the live group.
+type BoundedConsumer struct {
+	cm     *ConsumerManager
+	client *kafka.Client
+}
+
+// NewBoundedConsumer creates a BoundedConsumer for config.Topics. GroupID and
+// the commit settings are ignored.
+func NewBoundedConsumer(config ConsumerConfig) *BoundedConsumer {
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = DefaultConsumerConfig().RetryInterval
+	}
+	return &BoundedConsumer{
+		cm: &ConsumerManager{config: config, dialer: NewDialer(config.TLS, config.SASL, config.Timeouts)},
+		client: &kafka.Client{
+			Addr:      kafka.TCP(config.Brokers...),
+			Transport: NewTransport(config.TLS, config.SASL, config.Timeouts),
+			Timeout:   config.Timeouts.withDefaults().Request,
+		},
+	}
+}
+
+// Resolve snapshots the offsets selected by start and end for every partition
+// of the configured topics. Offsets are clamped to what the brokers still
+// hold; partitions with nothing to read are included with Start == End.
+func (b *BoundedConsumer) Resolve(ctx context.Context, start, end OffsetSpec) ([]PartitionRange, error) {
+	meta, err := b.client.Metadata(ctx, &kafka.MetadataRequest{Topics: b.cm.config.Topics})
+	if err != nil {
+		return nil, fmt.Errorf("fetch metadata: %w", err)
+	}
+	partitions := make(map[string][]int)
+	for _, t := range meta.Topics {
+		if t.Error != nil {
+			return nil, fmt.Errorf("topic %s: %w", t.Name, t.Error)
+		}
+		for _, p := range t.Partitions {
+			partitions[t.Name] = append(partitions[t.Name], p.ID)
+		}
+	}
+
+	first, err := b.listOffsets(ctx, partitions, kafka.FirstOffsetOf)
+	if err != nil {
+		return nil, err
+	}
+	last, err := b.listOffsets(ctx, partitions, kafka.LastOffsetOf)
+	if err != nil {
+		return nil, err
+	}
+	starts, err := b.resolveSpec(ctx, partitions, start, first, last)
+	if err != nil {
+		return nil, err
+	}
+	ends, err := b.resolveSpec(ctx, partitions, end, first, last)
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges []PartitionRange
+	for tp, s := range starts {
+		e := ends[tp]
+		s = max(s, first[tp])
+		e = min(e, last[tp])
+		if s > e {
+			s = e
+		}
+		ranges = append(ranges, PartitionRange{Topic: tp.topic, Partition: tp.partition, Start: s, End: e})
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		if ranges[i].Topic != ranges[j].Topic {
+			return ranges[i].Topic < ranges[j].Topic
+		}
+		return ranges[i].Partition < ranges[j].Partition
+	})
+	return ranges, nil
+}
+
+// resolveSpec returns the offset spec selects in every partition.
+func (b *BoundedConsumer) resolveSpec(ctx context.Context, partitions map[string][]int, spec OffsetSpec, first, last map[topicPartition]int64) (map[topicPartition]int64, error) {
+	var byTime map[topicPartition]int64
+	if !spec.Time.IsZero() {
+		var err error
+		byTime, err = b.listOffsets(ctx, partitions, func(p int) kafka.OffsetRequest {
+			return kafka.TimeOffsetOf(p, spec.Time)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	out := make(map[topicPartition]int64)
+	for topic, ids := range partitions {
+		for _, p := range ids {
+			tp := topicPartition{topic, p}
+			switch off, ok := spec.Offsets[topic][p]; {
+			case ok:
+				out[tp] = off
+			case byTime != nil:
+				off, ok := byTime[tp]
+				if !ok || off < 0 {
+					// No message at or after the time.
+					off = last[tp]
+				}
+				out[tp] = off
+			case spec.Position == kafka.LastOffset:
+				out[tp] = last[tp]
+			default:
+				out[tp] = first[tp]
+			}
+		}
+	}
+	return out, nil
+}
+
+// listOffsets sends one ListOffsets request for all partitions.
+func (b *BoundedConsumer) listOffsets(ctx context.Context, partitions map[string][]int, req func(int) kafka.OffsetRequest) (map[topicPartition]int64, error) {
+	return listOffsets(ctx, b.client, b.cm.config.IsolationLevel, partitions, req)
+}
+
+// listOffsets sends one ListOffsets request for all partitions. Partitions
+// without a result, such as a time lookup past the last message, map to -1.
+func listOffsets(ctx context.Context, client *kafka.Client, isolation kafka.IsolationLevel, partitions map[string][]int, req func(int) kafka.OffsetRequest) (map[topicPartition]int64, error) {
+	topics := make(map[string][]kafka.OffsetRequest)
+	for topic, ids := range partitions {
+		for _, p := range ids {
+			topics[topic] = append(topics[topic], req(p))
+		}
+	}
+	res, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics:         topics,
+		IsolationLevel: isolation,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list offsets: %w", err)
+	}
+
+	out := make(map[topicPartition]int64)
+	for topic, parts := range res.Topics {
+		for _, p := range parts {
+			if p.Error != nil {
+				return nil, fmt.Errorf("list offsets for %s/%d: %w", topic, p.Partition, p.Error)
+			}
+			tp := topicPartition{topic, p.Partition}
+			switch {
+			case len(p.Offsets) > 0:
+				for off := range p.Offsets {
+					out[tp] = off
+				}
+			case p.LastOffset >= 0:
+				out[tp] = p.LastOffset
+			default:
+				out[tp] = p.FirstOffset
+			}
+		}
+	}
+	return out, nil
+}
+
+// Run consumes every range concurrently and hands the messages to sink. It
+// returns once each partition has reached its end offset. Acked counts keep
+// growing afterwards as the sink's consumers acknowledge messages.
+func (b *BoundedConsumer) Run(ctx context.Context, ranges []PartitionRange, sink Sink) ([]*RangeProgress, error) {
+	progress := make([]*RangeProgress, len(ranges))
+	errs := make([]error, len(ranges))
+	var wg sync.WaitGroup
+	for i, r := range ranges {
+		progress[i] = &RangeProgress{PartitionRange: r}
+		if r.Start >= r.End {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = b.consumeRange(ctx, progress[i], sink)
+		}(i)
+	}
+	wg.Wait()
+	return progress, errors.Join(errs...)
+}
+
+// drained reports whether r has nothing left to read in p after a fetch came
+// back empty. Once the partition's last stable offset, or its high watermark
+// when reading uncommitted messages, has reached the end of the range, every
+// message before the end was fetchable, so an empty fetch means the offsets
+// left hold no messages.
+func (b *BoundedConsumer) drained(ctx context.Context, r *kafka.Reader, p *RangeProgress) (bool, error) {
+	if r.Offset() >= p.End {
+		return true, nil
+	}
+	last, err := b.listOffsets(ctx, map[string][]int{p.Topic: {p.Partition}}, kafka.LastOffsetOf)
+	if err != nil {
+		return false, err
+	}
+	return last[topicPartition{p.Topic, p.Partition}] >= p.End, nil
+}
+
+func (b *BoundedConsumer) consumeRange(ctx context.Context, p *RangeProgress, sink Sink) error {
+	logger := slog.With("topic", p.Topic, "partition", p.Partition, "start", p.Start, "end", p.End)
+	r := kafka.NewReader(b.cm.partitionReaderConfig(p.Topic, p.Partition))
+	defer r.Close()
+	if err := r.SetOffset(p.Start); err != nil {
+		return fmt.Errorf("%s/%d: set offset: %w", p.Topic, p.Partition, err)
+	}
+	logger.Info("consuming range")
+
+	for {
+		if f := b.cm.config.Flow; f != nil {
+			if err := f.Wait(ctx); err != nil {
+				return err
+			}
+		}
+		fetchCtx, cancel := context.WithTimeout(ctx, boundedPollTimeout)
+		m, err := r.FetchMessage(fetchCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				// Transaction markers, aborted transactions and compacted
+				// gaps occupy offsets without producing messages, so the
+				// end may be reached without one.
+				if done, err := b.drained(ctx, r, p); err != nil {
+					logger.Warn("failed to look up the last stable offset", "error", err)
+				} else if done {
+					break
+				}
+				continue
+			}
+			logger.Error("failed to fetch message", "error", err)
+			select {
+			case <-time.After(b.cm.config.RetryInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+		if m.Offset >= p.End {
+			break
+		}
+
+		p.Consumed.Add(1)
+		msg := &Message{
+			Topic:     p.Topic,
+			Partition: p.Partition,
+			Offset:    m.Offset,
+			Key:       m.Key,
+			Value:     m.Value,
+			Headers:   m.Headers,
+			Time:      m.Time,
+			ack:       sync.OnceFunc(func() { p.Acked.Add(1) }),
+			release:   b.cm.acquire(len(m.Key) + len(m.Value)),
+		}
+		if err := sink.Put(ctx, msg); err != nil {
+			msg.Release()
+			return err
+		}
+		if m.Offset+1 >= p.End {
+			break
+		}
+	}
+	logger.Info("range complete", "consumed", p.Consumed.Load())
+	return nil
+}
@@ -0,0 +1,59 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestParseOffsetSpec(t *testing.T) {
+	spec, err := ParseOffsetSpec("", kafka.LastOffset)
+	if err != nil || spec.Position != kafka.LastOffset {
+		t.Errorf("empty spec = %+v, %v", spec, err)
+	}
+	spec, err = ParseOffsetSpec("earliest", kafka.LastOffset)
+	if err != nil || spec.Position != kafka.FirstOffset {
+		t.Errorf("earliest = %+v, %v", spec, err)
+	}
+	spec, err = ParseOffsetSpec("2024-05-01T10:00:00Z", kafka.FirstOffset)
+	if err != nil || !spec.Time.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("time spec = %+v, %v", spec, err)
+	}
+	spec, err = ParseOffsetSpec("orders:0=120, orders:1=98,ns:events:2=5", kafka.FirstOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Offsets["orders"][0] != 120 || spec.Offsets["orders"][1] != 98 || spec.Offsets["ns:events"][2] != 5 {
+		t.Errorf("explicit spec = %+v", spec.Offsets)
+	}
+	for _, bad := range []string{"orders=1", "orders:x=1", "orders:0=abc", "yesterday"} {
+		if _, err := ParseOffsetSpec(bad, kafka.FirstOffset); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestResolveSpecFallbacks(t *testing.T) {
+	b := &BoundedConsumer{}
+	partitions := map[string][]int{"t": {0, 1}}
+	first := map[topicPartition]int64{{"t", 0}: 10, {"t", 1}: 0}
+	last := map[topicPartition]int64{{"t", 0}: 50, {"t", 1}: 7}
+
+	got, err := b.resolveSpec(context.Background(), partitions, OffsetSpec{
+		Position: kafka.LastOffset,
+		Offsets:  map[string]map[int]int64{"t": {0: 20}},
+	}, first, last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[topicPartition{"t", 0}] != 20 || got[topicPartition{"t", 1}] != 7 {
+		t.Errorf("resolveSpec() = %v", got)
+	}
+
+	got, _ = b.resolveSpec(context.Background(), partitions, OffsetSpec{Position: kafka.FirstOffset}, first, last)
+	if got[topicPartition{"t", 0}] != 10 || got[topicPartition{"t", 1}] != 0 {
+		t.Errorf("resolveSpec(earliest) = %v", got)
+	}
+}
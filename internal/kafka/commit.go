@@ -0,0 +1,308 @@
+package kafka
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gor0utine/kafka-to-es/internal/metrics"
+)
+
+// Committer stores consumed offsets for a set of partitions. Offsets are the
+// next offset to read, as in Kafka. *kafka.Generation implements Committer.
+type Committer interface {
+	CommitOffsets(offsets map[string]map[int]int64) error
+}
+
+// CheckpointStore keeps offsets outside Kafka, for partitions that are
+// consumed without a consumer group.
+type CheckpointStore interface {
+	Committer
+	// Offset returns the stored next offset of a partition, or -1 if there
+	// is none.
+	Offset(ctx context.Context, topic string, partition int) (int64, error)
+}
+
+// CommitManagerConfig controls how often offsets are committed.
+type CommitManagerConfig struct {
+	// Interval is the maximum time between commits.
+	Interval time.Duration
+	// Threshold triggers a commit once this many messages have been
+	// acknowledged since the previous one.
+	Threshold int
+	// Metrics receives the commit metrics; nil uses the top-level registry.
+	Metrics *metrics.Registry
+}
+
+// CommitManager collects acknowledged offsets per partition and commits them
+// in batches. Messages may be acknowledged out of order; a partition's offset
+// only advances past messages that have all been acknowledged.
+type CommitManager struct {
+	cfg CommitManagerConfig
+
+	mu          sync.Mutex
+	parts       map[topicPartition]*partitionOffsets
+	sinceCommit int
+
+	kick chan struct{}
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+
+	started bool
+
+	commits  *expvar.Int
+	failures *expvar.Int
+	latency  *metrics.Timer
+}
+
+type topicPartition struct {
+	topic     string
+	partition int
+}
+
+// partitionOffsets tracks the messages of one assigned partition.
+type partitionOffsets struct {
+	committer Committer
+	sync      bool
+	pending   map[int64]struct{}
+	// next is one past the highest tracked offset, -1 before the first one.
+	next      int64
+	committed int64
+	drained   chan struct{}
+}
+
+// watermark returns the offset up to which every message was acknowledged,
+// or -1 if nothing has been tracked yet.
+func (p *partitionOffsets) watermark() int64 {
+	if len(p.pending) == 0 {
+		return p.next
+	}
+	low := p.next
+	for off := range p.pending {
+		if off < low {
+			low = off
+		}
+	}
+	return low
+}
+
+// NewCommitManager creates a CommitManager. Call Start to begin periodic commits.
+func NewCommitManager(cfg CommitManagerConfig) *CommitManager {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 1000
+	}
+	reg := cfg.Metrics
+	return &CommitManager{
+		cfg:      cfg,
+		parts:    make(map[topicPartition]*partitionOffsets),
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		commits:  reg.Counter("kafka_commits"),
+		failures: reg.Counter("kafka_commit_failures"),
+		latency:  reg.Timer("kafka_commit_latency"),
+	}
+}
+
+// Start runs the commit loop until Close is called.
+func (c *CommitManager) Start() {
+	c.mu.Lock()
+	c.started = true
+	c.mu.Unlock()
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(c.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+			case <-c.kick:
+			}
+			_ = c.Flush()
+		}
+	}()
+}
+
+// Assign registers a partition whose offsets are committed through committer.
+// With sync set, every acknowledgement triggers a commit.
+func (c *CommitManager) Assign(topic string, partition int, committer Committer, sync bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.parts[topicPartition{topic, partition}] = &partitionOffsets{
+		committer: committer,
+		sync:      sync,
+		pending:   make(map[int64]struct{}),
+		next:      -1,
+		committed: -1,
+	}
+}
+
+// Track records a fetched message and returns the function that acknowledges
+// it. The returned function is idempotent and becomes a no-op once the
+// partition is revoked.
+func (c *CommitManager) Track(topic string, partition int, offset int64) func() {
+	tp := topicPartition{topic, partition}
+	c.mu.Lock()
+	st := c.parts[tp]
+	if st == nil {
+		c.mu.Unlock()
+		return func() {}
+	}
+	st.pending[offset] = struct{}{}
+	if offset+1 > st.next {
+		st.next = offset + 1
+	}
+	c.mu.Unlock()
+	return func() { c.ack(tp, st, offset) }
+}
+
+func (c *CommitManager) ack(tp topicPartition, st *partitionOffsets, offset int64) {
+	c.mu.Lock()
+	if c.parts[tp] != st {
+		c.mu.Unlock()
+		return
+	}
+	if _, ok := st.pending[offset]; !ok {
+		c.mu.Unlock()
+		return
+	}
+	delete(st.pending, offset)
+	c.sinceCommit++
+	trigger := st.sync || c.sinceCommit >= c.cfg.Threshold
+	if len(st.pending) == 0 && st.drained != nil {
+		close(st.drained)
+		st.drained = nil
+	}
+	c.mu.Unlock()
+	if trigger {
+		select {
+		case c.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Untrack forgets the most recently tracked message of a partition, for a
+// message that was fetched but never handed off.
+func (c *CommitManager) Untrack(topic string, partition int, offset int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.parts[topicPartition{topic, partition}]
+	if st == nil {
+		return
+	}
+	delete(st.pending, offset)
+	if st.next == offset+1 {
+		st.next = offset
+	}
+}
+
+// Revoke commits a partition's final offset and stops tracking it. A positive
+// drain first waits up to that long for outstanding messages to be
+// acknowledged, so a rebalance hands over as little duplicate work as possible.
+func (c *CommitManager) Revoke(topic string, partition int, drain time.Duration) {
+	tp := topicPartition{topic, partition}
+	c.mu.Lock()
+	st := c.parts[tp]
+	if st == nil {
+		c.mu.Unlock()
+		return
+	}
+	if drain > 0 && len(st.pending) > 0 {
+		ch := make(chan struct{})
+		st.drained = ch
+		c.mu.Unlock()
+		timer := time.NewTimer(drain)
+		select {
+		case <-ch:
+		case <-timer.C:
+			slog.Warn("partition revoked with unacknowledged messages",
+				"topic", topic, "partition", partition, "drain_timeout", drain)
+		}
+		timer.Stop()
+		c.mu.Lock()
+	}
+	delete(c.parts, tp)
+	off := st.watermark()
+	committed := st.committed
+	c.mu.Unlock()
+
+	if off > committed {
+		_ = c.commit(st.committer, map[string]map[int]int64{topic: {partition: off}})
+	}
+}
+
+// Flush commits the acknowledged offsets of every assigned partition.
+func (c *CommitManager) Flush() error {
+	batches := make(map[Committer]map[string]map[int]int64)
+	c.mu.Lock()
+	for tp, st := range c.parts {
+		off := st.watermark()
+		if off <= st.committed {
+			continue
+		}
+		b := batches[st.committer]
+		if b == nil {
+			b = make(map[string]map[int]int64)
+			batches[st.committer] = b
+		}
+		if b[tp.topic] == nil {
+			b[tp.topic] = make(map[int]int64)
+		}
+		b[tp.topic][tp.partition] = off
+	}
+	c.sinceCommit = 0
+	c.mu.Unlock()
+
+	var firstErr error
+	for committer, offsets := range batches {
+		if err := c.commit(committer, offsets); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (c *CommitManager) commit(committer Committer, offsets map[string]map[int]int64) error {
+	start := time.Now()
+	err := committer.CommitOffsets(offsets)
+	c.latency.Observe(time.Since(start))
+	if err != nil {
+		c.failures.Add(1)
+		slog.Error("failed to commit offsets", "offsets", offsets, "error", err)
+		return err
+	}
+	c.commits.Add(1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for topic, parts := range offsets {
+		for partition, off := range parts {
+			st := c.parts[topicPartition{topic, partition}]
+			if st != nil && st.committer == committer && off > st.committed {
+				st.committed = off
+			}
+		}
+	}
+	return nil
+}
+
+// Close stops the commit loop after a final flush.
+func (c *CommitManager) Close() error {
+	c.once.Do(func() { close(c.stop) })
+	c.mu.Lock()
+	started := c.started
+	c.mu.Unlock()
+	if started {
+		<-c.done
+	}
+	return c.Flush()
+}
@@ -0,0 +1,149 @@
+package kafka
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeCommitter struct {
+	mu      sync.Mutex
+	commits []map[string]map[int]int64
+	err     error
+}
+
+func (f *fakeCommitter) CommitOffsets(offsets map[string]map[int]int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.commits = append(f.commits, offsets)
+	return nil
+}
+
+func (f *fakeCommitter) last(topic string, partition int) (int64, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.commits) - 1; i >= 0; i-- {
+		if off, ok := f.commits[i][topic][partition]; ok {
+			return off, true
+		}
+	}
+	return 0, false
+}
+
+func (f *fakeCommitter) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.commits)
+}
+
+func TestCommitManagerOutOfOrderAcks(t *testing.T) {
+	c := NewCommitManager(CommitManagerConfig{Interval: time.Hour})
+	fc := &fakeCommitter{}
+	c.Assign("t", 0, fc, false)
+
+	ack10 := c.Track("t", 0, 10)
+	ack11 := c.Track("t", 0, 11)
+	ack12 := c.Track("t", 0, 12)
+
+	ack11()
+	ack12()
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if off, ok := fc.last("t", 0); !ok || off != 10 {
+		t.Errorf("committed %d (%v), want 10 while offset 10 is pending", off, ok)
+	}
+
+	ack10()
+	ack10() // idempotent
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if off, _ := fc.last("t", 0); off != 13 {
+		t.Errorf("committed %d, want 13", off)
+	}
+
+	n := fc.count()
+	_ = c.Flush()
+	if fc.count() != n {
+		t.Error("expected no commit when nothing changed")
+	}
+}
+
+func TestCommitManagerThresholdTriggersCommit(t *testing.T) {
+	c := NewCommitManager(CommitManagerConfig{Interval: time.Hour, Threshold: 2})
+	fc := &fakeCommitter{}
+	c.Assign("t", 1, fc, false)
+	c.Start()
+	defer c.Close()
+
+	c.Track("t", 1, 0)()
+	c.Track("t", 1, 1)()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if off, ok := fc.last("t", 1); ok && off == 2 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("threshold did not trigger a commit")
+}
+
+func TestCommitManagerRevokeDrains(t *testing.T) {
+	c := NewCommitManager(CommitManagerConfig{Interval: time.Hour})
+	fc := &fakeCommitter{}
+	c.Assign("t", 0, fc, false)
+	ack := c.Track("t", 0, 5)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		ack()
+	}()
+	c.Revoke("t", 0, time.Second)
+	if off, _ := fc.last("t", 0); off != 6 {
+		t.Errorf("committed %d on revoke, want 6", off)
+	}
+
+	// Acks after revocation are ignored.
+	c.Assign("t", 0, fc, false)
+	ack()
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if fc.count() != 1 {
+		t.Errorf("expected 1 commit, got %d", fc.count())
+	}
+}
+
+func TestCommitManagerUntrackAndFailure(t *testing.T) {
+	c := NewCommitManager(CommitManagerConfig{Interval: time.Hour})
+	fc := &fakeCommitter{err: errors.New("coordinator unavailable")}
+	c.Assign("t", 0, fc, false)
+
+	c.Track("t", 0, 0)()
+	c.Track("t", 0, 1)
+	c.Untrack("t", 0, 1)
+
+	failures := c.failures.Value()
+	if err := c.Flush(); err == nil {
+		t.Fatal("expected commit error")
+	}
+	if c.failures.Value() != failures+1 {
+		t.Error("failure metric not incremented")
+	}
+
+	fc.mu.Lock()
+	fc.err = nil
+	fc.mu.Unlock()
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if off, _ := fc.last("t", 0); off != 1 {
+		t.Errorf("committed %d, want 1 after untracking offset 1", off)
+	}
+}
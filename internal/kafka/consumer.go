@@ -2,153 +2,568 @@ package kafka
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+
+	"github.com/gor0utine/kafka-to-es/internal/metrics"
 )
 
+// revokeDrainTimeout bounds how long a revoked partition waits for its
+// outstanding messages to be acknowledged before the final commit.
+const revokeDrainTimeout = 10 * time.Second
+
 // Message wraps kafka.Message with topic info
 type Message struct {
-	Topic   string
-	Key     []byte
-	Value   []byte
-	Headers []kafka.Header
-	Time    time.Time
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   []kafka.Header
+	Time      time.Time
+
+	ack     func()
+	release func()
+}
+
+// Ack marks the message as processed so its offset can be committed. It is
+// safe to call more than once.
+func (m *Message) Ack() {
+	if m.ack != nil {
+		m.ack()
+	}
+}
+
+// Release reports that the message has been indexed or given up on, so it
+// no longer counts against the flow controller's budget. It is safe to call
+// more than once.
+func (m *Message) Release() {
+	if m.release != nil {
+		m.release()
+	}
+}
+
+// Sink receives consumed messages.
+type Sink interface {
+	// Put blocks until msg is accepted or ctx is done.
+	Put(ctx context.Context, msg *Message) error
+}
+
+// ChanSink is a Sink that sends messages on a channel.
+type ChanSink chan<- *Message
+
+// Put implements Sink.
+func (c ChanSink) Put(ctx context.Context, msg *Message) error {
+	select {
+	case c <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FlowController limits the messages in flight between the consumer and the
+// indexer. *flow.Controller implements it.
+type FlowController interface {
+	// Wait blocks while fetching is paused.
+	Wait(ctx context.Context) error
+	Acquire(size int)
+	Release(size int)
 }
 
 // ConsumerConfig holds configuration for the consumer manager
 type ConsumerConfig struct {
-	Brokers       []string
-	GroupID       string
-	Topics        []string
-	MinBytes      int
-	MaxBytes      int
-	RetryInterval time.Duration
-	CommitSync    bool
+	Brokers []string
+	GroupID string
+	Topics  []string
+	// ReaderTuning applies to every topic without an entry in TopicTuning.
+	ReaderTuning
+	// TopicTuning replaces ReaderTuning for individual topics.
+	TopicTuning map[string]ReaderTuning
+	// SingleGroup subscribes one group member to all topics, so partitions
+	// are balanced across topics and a rebalance covers every topic at once.
+	// Otherwise each topic joins the group as a separate member.
+	SingleGroup bool
+	// StaticPartitions assigns fixed partitions by topic instead of joining
+	// a consumer group. Topics and GroupID are ignored, and offsets are
+	// loaded from and committed to Checkpoints.
+	StaticPartitions map[string][]int
+	// Checkpoints stores offsets for StaticPartitions. With consumer groups
+	// it is optional: partitions then resume from their checkpoint instead
+	// of the group's committed offset, and offsets are committed to both.
+	Checkpoints CheckpointStore
+	// CommitThreshold commits early once this many messages have been
+	// acknowledged since the last commit. The commit interval comes from
+	// ReaderTuning.CommitInterval.
+	CommitThreshold int
+	RetryInterval   time.Duration
+	// Flow, if set, pauses fetching while too many messages are waiting for
+	// the indexer.
+	Flow FlowController
+	// TLS and SASL secure the broker connections; both are optional.
+	TLS      *tls.Config
+	SASL     sasl.Mechanism
+	Timeouts Timeouts
+	// Metrics receives the commit metrics; nil uses the top-level registry.
+	Metrics *metrics.Registry
 }
 
 // DefaultConsumerConfig returns sensible defaults for ConsumerConfig
 func DefaultConsumerConfig() ConsumerConfig {
 	return ConsumerConfig{
-		MinBytes:      1,
-		MaxBytes:      10e6, // 10MB
-		RetryInterval: time.Second,
-		CommitSync:    true,
+		ReaderTuning: ReaderTuning{
+			MinBytes:       1,
+			MaxBytes:       10e6, // 10MB
+			StartOffset:    kafka.FirstOffset,
+			CommitInterval: time.Second,
+		},
+		CommitThreshold: 1000,
+		RetryInterval:   time.Second,
+	}
+}
+
+// tuningFor returns the reader settings for topic.
+func (cm *ConsumerManager) tuningFor(topic string) ReaderTuning {
+	t, ok := cm.config.TopicTuning[topic]
+	if !ok {
+		t = cm.config.ReaderTuning
+	}
+	return t.withDefaults()
+}
+
+// groupConfig builds the consumer group configuration for a set of topics.
+// A group for a single topic uses that topic's tuning; a group spanning
+// several topics uses the global tuning.
+func (cm *ConsumerManager) groupConfig(topics []string) kafka.ConsumerGroupConfig {
+	t := cm.config.ReaderTuning.withDefaults()
+	if len(topics) == 1 {
+		t = cm.tuningFor(topics[0])
+	}
+	return kafka.ConsumerGroupConfig{
+		ID:                    cm.config.GroupID,
+		Brokers:               cm.config.Brokers,
+		Dialer:                cm.dialer,
+		Topics:                topics,
+		GroupBalancers:        t.GroupBalancers,
+		HeartbeatInterval:     t.HeartbeatInterval,
+		SessionTimeout:        t.SessionTimeout,
+		RebalanceTimeout:      t.RebalanceTimeout,
+		StartOffset:           t.StartOffset,
+		WatchPartitionChanges: true,
 	}
 }
 
-// ConsumerManager reads from a set of topics and pushes messages into outCh.
+// partitionReaderConfig builds the reader for one assigned partition.
+func (cm *ConsumerManager) partitionReaderConfig(topic string, partition int) kafka.ReaderConfig {
+	t := cm.tuningFor(topic)
+	return kafka.ReaderConfig{
+		Brokers:        cm.config.Brokers,
+		Topic:          topic,
+		Partition:      partition,
+		Dialer:         cm.dialer,
+		MinBytes:       t.MinBytes,
+		MaxBytes:       t.MaxBytes,
+		MaxWait:        t.MaxWait,
+		QueueCapacity:  t.QueueCapacity,
+		IsolationLevel: t.IsolationLevel,
+	}
+}
+
+// ConsumerManager reads from a set of topics and pushes messages into a Sink.
+// Topics are consumed through one consumer group member each, or through a
+// single member with SingleGroup; every assigned partition gets a dedicated
+// reader. Offsets are committed by a CommitManager once messages are
+// acknowledged with Message.Ack.
 type ConsumerManager struct {
-	readers []*kafka.Reader
 	config  ConsumerConfig
+	dialer  *kafka.Dialer
+	groups  []*consumerGroup
+	commits *CommitManager
+	closing atomic.Bool
+	static  *staticAssignment
+
+	pauseMu sync.Mutex
+	paused  map[string]chan struct{} // closed on Resume
 }
 
-// NewConsumerManager creates a new consumer manager with the given configuration.
-func NewConsumerManager(config ConsumerConfig) *ConsumerManager {
-	if config.MinBytes <= 0 {
-		config.MinBytes = DefaultConsumerConfig().MinBytes
+type consumerGroup struct {
+	topics []string
+	group  *kafka.ConsumerGroup
+
+	mu         sync.Mutex
+	generation int32
+	memberID   string
+	assigned   map[string][]int
+}
+
+// GroupAssignment describes the partitions currently held by one group member.
+type GroupAssignment struct {
+	Topics     []string         `json:"topics"`
+	Generation int32            `json:"generation"`
+	MemberID   string           `json:"member_id"`
+	Partitions map[string][]int `json:"partitions"`
+}
+
+// setGeneration records the assignments of gen, or clears them when gen is nil.
+func (g *consumerGroup) setGeneration(gen *kafka.Generation) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if gen == nil {
+		g.assigned = nil
+		return
 	}
-	if config.MaxBytes <= 0 {
-		config.MaxBytes = DefaultConsumerConfig().MaxBytes
+	g.generation = gen.ID
+	g.memberID = gen.MemberID
+	g.assigned = make(map[string][]int, len(gen.Assignments))
+	for topic, pas := range gen.Assignments {
+		ids := make([]int, 0, len(pas))
+		for _, pa := range pas {
+			ids = append(ids, pa.ID)
+		}
+		sort.Ints(ids)
+		g.assigned[topic] = ids
 	}
+}
+
+func (g *consumerGroup) assignment() GroupAssignment {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	a := GroupAssignment{
+		Topics:     g.topics,
+		Generation: g.generation,
+		MemberID:   g.memberID,
+		Partitions: make(map[string][]int, len(g.assigned)),
+	}
+	for topic, ids := range g.assigned {
+		a.Partitions[topic] = append([]int(nil), ids...)
+	}
+	return a
+}
+
+// NewConsumerManager creates a new consumer manager with the given configuration.
+func NewConsumerManager(config ConsumerConfig) (*ConsumerManager, error) {
+	def := DefaultConsumerConfig()
 	if config.RetryInterval <= 0 {
-		config.RetryInterval = DefaultConsumerConfig().RetryInterval
+		config.RetryInterval = def.RetryInterval
+	}
+	if config.CommitThreshold <= 0 {
+		config.CommitThreshold = def.CommitThreshold
+	}
+	if config.CommitInterval <= 0 {
+		config.CommitInterval = def.CommitInterval
 	}
 
-	var readers []*kafka.Reader
-	for _, t := range config.Topics {
-		r := kafka.NewReader(kafka.ReaderConfig{
-			Brokers:  config.Brokers,
-			GroupID:  config.GroupID,
-			Topic:    t,
-			MinBytes: config.MinBytes,
-			MaxBytes: config.MaxBytes,
-		})
-		readers = append(readers, r)
+	cm := &ConsumerManager{
+		config: config,
+		dialer: NewDialer(config.TLS, config.SASL, config.Timeouts),
+		commits: NewCommitManager(CommitManagerConfig{
+			Interval:  config.CommitInterval,
+			Threshold: config.CommitThreshold,
+			Metrics:   config.Metrics,
+		}),
+	}
+	if len(config.StaticPartitions) > 0 {
+		if config.Checkpoints == nil {
+			return nil, errors.New("static partitions need a checkpoint store")
+		}
+		cm.static = newStaticAssignment(config.StaticPartitions)
+		return cm, nil
 	}
-	return &ConsumerManager{
-		readers: readers,
-		config:  config,
+	var memberTopics [][]string
+	if config.SingleGroup {
+		memberTopics = [][]string{config.Topics}
+	} else {
+		for _, t := range config.Topics {
+			memberTopics = append(memberTopics, []string{t})
+		}
+	}
+	for _, topics := range memberTopics {
+		g, err := kafka.NewConsumerGroup(cm.groupConfig(topics))
+		if err != nil {
+			cm.Close()
+			return nil, fmt.Errorf("consumer group for topics %v: %w", topics, err)
+		}
+		cm.groups = append(cm.groups, &consumerGroup{topics: topics, group: g})
 	}
+	return cm, nil
 }
 
-// Start consumes messages and sends to outCh. Each reader runs in its goroutine.
+// Start consumes messages and sends to outCh. Each group and each assigned
+// partition runs in its own goroutine.
 func (cm *ConsumerManager) Start(ctx context.Context, outCh chan<- *Message) {
-	for _, r := range cm.readers {
-		go cm.consumeMessages(ctx, r, outCh)
+	cm.StartSink(ctx, ChanSink(outCh))
+}
+
+// StartSink is like Start but hands messages to sink.
+func (cm *ConsumerManager) StartSink(ctx context.Context, sink Sink) {
+	cm.commits.Start()
+	if cm.static != nil {
+		cm.startStatic(ctx, sink)
+		return
+	}
+	for _, g := range cm.groups {
+		go cm.runGroup(ctx, g, sink)
 	}
 }
 
-// consumeMessages handles the message consumption loop for a single reader
-func (cm *ConsumerManager) consumeMessages(ctx context.Context, r *kafka.Reader, outCh chan<- *Message) {
-	topic := r.Config().Topic
-	logger := slog.With("topic", topic)
+// runGroup follows the generations of one consumer group and starts a
+// partition consumer for every assignment.
+func (cm *ConsumerManager) runGroup(ctx context.Context, g *consumerGroup, sink Sink) {
+	logger := slog.With("topics", g.topics)
 	logger.Info("starting consumer")
 
 	for {
-		select {
-		case <-ctx.Done():
-			logger.Info("stopping consumer", "reason", ctx.Err())
+		gen, err := g.group.Next(ctx)
+		if err != nil {
+			if errors.Is(err, kafka.ErrGroupClosed) || ctx.Err() != nil {
+				logger.Info("stopping consumer", "reason", err)
+				return
+			}
+			logger.Error("failed to join consumer group", "error", err)
+			select {
+			case <-time.After(cm.config.RetryInterval):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		logger.Info("partitions assigned",
+			"generation", gen.ID, "member", gen.MemberID,
+			"assignments", formatAssignments(gen.Assignments))
+		g.setGeneration(gen)
+		committer := cm.groupCommitter(gen)
+		gen.Start(func(genCtx context.Context) {
+			<-genCtx.Done()
+			g.setGeneration(nil)
+		})
+		for topic, assignments := range gen.Assignments {
+			for _, pa := range assignments {
+				topic, pa := topic, pa
+				gen.Start(func(genCtx context.Context) {
+					cm.consumePartition(ctx, genCtx, committer, topic, pa, sink)
+				})
+			}
+		}
+	}
+}
+
+// groupCommitter returns the committer for the partitions of a generation.
+func (cm *ConsumerManager) groupCommitter(gen *kafka.Generation) Committer {
+	if cm.config.Checkpoints == nil {
+		return gen
+	}
+	return &checkpointCommitter{checkpoints: cm.config.Checkpoints, group: gen}
+}
+
+// checkpointCommitter commits to the checkpoint store, which consumers
+// resume from, and then to the consumer group so lag stays visible.
+type checkpointCommitter struct {
+	checkpoints CheckpointStore
+	group       Committer
+}
+
+func (c *checkpointCommitter) CommitOffsets(offsets map[string]map[int]int64) error {
+	if err := c.checkpoints.CommitOffsets(offsets); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	return c.group.CommitOffsets(offsets)
+}
+
+// consumePartition reads one partition for the lifetime of a generation.
+// When the generation ends the partition's acknowledged offset is committed
+// before the next generation can start.
+func (cm *ConsumerManager) consumePartition(ctx, genCtx context.Context, committer Committer, topic string, pa kafka.PartitionAssignment, sink Sink) {
+	logger := slog.With("topic", topic, "partition", pa.ID)
+	tuning := cm.tuningFor(topic)
+
+	offset := pa.Offset
+	if cm.config.Checkpoints != nil {
+		checkpoint, ok := cm.loadCheckpoint(genCtx, topic, pa.ID, logger)
+		if !ok {
 			return
-		default:
-			// Continue processing
 		}
+		if checkpoint >= 0 {
+			offset = checkpoint
+		}
+	}
+	if offset < 0 {
+		// No committed offset: FirstOffset or LastOffset for this topic.
+		offset = tuning.StartOffset
+	}
+	r := kafka.NewReader(cm.partitionReaderConfig(topic, pa.ID))
+	defer r.Close()
+	if err := r.SetOffset(offset); err != nil {
+		logger.Error("failed to set offset", "offset", offset, "error", err)
+		return
+	}
 
+	cm.commits.Assign(topic, pa.ID, committer, tuning.CommitSync)
+	logger.Info("starting partition consumer", "offset", offset)
+
+	fetchCtx, cancel := context.WithCancel(genCtx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	cm.fetch(fetchCtx, r, topic, pa.ID, sink, logger)
+
+	// On shutdown keep the generation, and with it the coordinator
+	// connection, open until Close so the final commit can go through.
+	<-genCtx.Done()
+	drain := revokeDrainTimeout
+	if ctx.Err() != nil || cm.closing.Load() {
+		drain = 0
+	}
+	cm.commits.Revoke(topic, pa.ID, drain)
+	logger.Info("partition revoked")
+}
+
+// fetch hands messages from r to sink until ctx is done.
+func (cm *ConsumerManager) fetch(ctx context.Context, r *kafka.Reader, topic string, partition int, sink Sink, logger *slog.Logger) {
+	for {
+		if err := cm.waitResumed(ctx, topic); err != nil {
+			return
+		}
+		if cm.config.Flow != nil {
+			if err := cm.config.Flow.Wait(ctx); err != nil {
+				return
+			}
+		}
 		m, err := r.FetchMessage(ctx)
 		if err != nil {
-			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			if ctx.Err() != nil {
 				return
 			}
 			logger.Error("failed to fetch message", "error", err)
-			time.Sleep(cm.config.RetryInterval)
+			select {
+			case <-time.After(cm.config.RetryInterval):
+			case <-ctx.Done():
+				return
+			}
 			continue
 		}
 
 		msg := &Message{
-			Topic:   topic,
-			Key:     m.Key,
-			Value:   m.Value,
-			Headers: m.Headers,
-			Time:    m.Time,
-		}
-
-		select {
-		case outCh <- msg:
-			// Message sent successfully
-		case <-ctx.Done():
-			logger.Info("context canceled during send", "reason", ctx.Err())
+			Topic:     topic,
+			Partition: partition,
+			Offset:    m.Offset,
+			Key:       m.Key,
+			Value:     m.Value,
+			Headers:   m.Headers,
+			Time:      m.Time,
+			ack:       cm.commits.Track(topic, partition, m.Offset),
+			release:   cm.acquire(len(m.Key) + len(m.Value)),
+		}
+
+		if err := sink.Put(ctx, msg); err != nil {
+			cm.commits.Untrack(topic, partition, m.Offset)
+			msg.Release()
 			return
 		}
+	}
+}
 
-		// Commit after handing off
-		if err := r.CommitMessages(ctx, m); err != nil {
-			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-				return
+// acquire charges a message of the given size to the flow controller and
+// returns the function that releases it.
+func (cm *ConsumerManager) acquire(size int) func() {
+	if cm.config.Flow == nil {
+		return nil
+	}
+	cm.config.Flow.Acquire(size)
+	var once sync.Once
+	return func() {
+		once.Do(func() { cm.config.Flow.Release(size) })
+	}
+}
+
+// formatAssignments renders assignments as "topic:p0,p1 ..." for logging.
+func formatAssignments(assignments map[string][]kafka.PartitionAssignment) string {
+	topics := make([]string, 0, len(assignments))
+	for t := range assignments {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+
+	var sb strings.Builder
+	for i, t := range topics {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		ids := make([]int, 0, len(assignments[t]))
+		for _, pa := range assignments[t] {
+			ids = append(ids, pa.ID)
+		}
+		sort.Ints(ids)
+		fmt.Fprintf(&sb, "%s:", t)
+		for j, id := range ids {
+			if j > 0 {
+				sb.WriteByte(',')
 			}
-			logger.Error("failed to commit message", "error", err, "offset", m.Offset)
+			fmt.Fprintf(&sb, "%d", id)
 		}
 	}
+	return sb.String()
 }
 
-// Close gracefully closes all Kafka readers
+// Close leaves the consumer groups, committing the acknowledged offsets of
+// every assigned partition, and stops the commit manager.
 func (cm *ConsumerManager) Close() error {
+	cm.closing.Store(true)
+	if cm.static != nil {
+		cm.static.close()
+	}
 	var lastErr error
-	for i, r := range cm.readers {
-		if err := r.Close(); err != nil {
-			lastErr = err
-			slog.Error("failed to close reader", "index", i, "error", err)
-		}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, g := range cm.groups {
+		wg.Add(1)
+		go func(g *consumerGroup) {
+			defer wg.Done()
+			if err := g.group.Close(); err != nil {
+				slog.Error("failed to close consumer group", "topics", g.topics, "error", err)
+				mu.Lock()
+				lastErr = err
+				mu.Unlock()
+			}
+		}(g)
+	}
+	wg.Wait()
+	if err := cm.commits.Close(); err != nil {
+		lastErr = err
 	}
 	return lastErr
 }
 
+// Assignments returns the partitions currently assigned to each group member.
+func (cm *ConsumerManager) Assignments() []GroupAssignment {
+	out := make([]GroupAssignment, 0, len(cm.groups))
+	if cm.static != nil {
+		out = append(out, cm.static.assignment())
+	}
+	for _, g := range cm.groups {
+		out = append(out, g.assignment())
+	}
+	return out
+}
+
 // Topics returns the list of topics this consumer is subscribed to
 func (cm *ConsumerManager) Topics() []string {
-	topics := make([]string, 0, len(cm.readers))
-	for _, r := range cm.readers {
-		topics = append(topics, r.Config().Topic)
+	var topics []string
+	if cm.static != nil {
+		topics = append(topics, cm.static.topics...)
+	}
+	for _, g := range cm.groups {
+		topics = append(topics, g.topics...)
 	}
 	return topics
 }
@@ -0,0 +1,162 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+)
+
+// ControlConsumer reads every partition of a control topic from its first
+// offset without joining a consumer group, so every instance sees every
+// command. Nothing is committed: a restart reads the topic again, which
+// suits a compacted topic that keeps the latest command per key.
+type ControlConsumer struct {
+	cm     *ConsumerManager
+	client *kafka.Client
+	topic  string
+
+	mu sync.Mutex // serializes the handler
+}
+
+// NewControlConsumer creates a ControlConsumer for topic using the
+// connection settings of config. Topics, GroupID, flow control and the
+// commit settings are ignored.
+func NewControlConsumer(config ConsumerConfig, topic string) *ControlConsumer {
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = DefaultConsumerConfig().RetryInterval
+	}
+	config.Flow = nil
+	return &ControlConsumer{
+		cm:    &ConsumerManager{config: config, dialer: NewDialer(config.TLS, config.SASL, config.Timeouts)},
+		topic: topic,
+		client: &kafka.Client{
+			Addr:      kafka.TCP(config.Brokers...),
+			Transport: NewTransport(config.TLS, config.SASL, config.Timeouts),
+			Timeout:   config.Timeouts.withDefaults().Request,
+		},
+	}
+}
+
+// Run hands every message of the topic to handle, one at a time and in
+// offset order within a partition, until ctx is done. Partitions added
+// after Run started are not read.
+func (c *ControlConsumer) Run(ctx context.Context, handle func(context.Context, *Message)) error {
+	partitions, err := c.partitions(ctx)
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for _, p := range partitions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.consume(ctx, p, handle)
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// partitions looks up the partitions of the topic, retrying until it
+// succeeds or ctx is done.
+func (c *ControlConsumer) partitions(ctx context.Context) ([]int, error) {
+	for {
+		meta, err := c.client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{c.topic}})
+		switch {
+		case err != nil:
+		case len(meta.Topics) == 0:
+			err = fmt.Errorf("no metadata for topic %s", c.topic)
+		case meta.Topics[0].Error != nil:
+			err = meta.Topics[0].Error
+		}
+		if err == nil {
+			var ids []int
+			for _, p := range meta.Topics[0].Partitions {
+				ids = append(ids, p.ID)
+			}
+			return ids, nil
+		}
+		slog.Error("failed to look up control topic", "topic", c.topic, "error", err)
+		select {
+		case <-time.After(c.cm.config.RetryInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *ControlConsumer) consume(ctx context.Context, partition int, handle func(context.Context, *Message)) {
+	logger := slog.With("topic", c.topic, "partition", partition)
+	r := kafka.NewReader(c.cm.partitionReaderConfig(c.topic, partition))
+	defer r.Close()
+	if err := r.SetOffset(kafka.FirstOffset); err != nil {
+		logger.Error("failed to set offset", "error", err)
+		return
+	}
+	for {
+		m, err := r.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error("failed to fetch control message", "error", err)
+			select {
+			case <-time.After(c.cm.config.RetryInterval):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		c.mu.Lock()
+		handle(ctx, &Message{
+			Topic:     c.topic,
+			Partition: partition,
+			Offset:    m.Offset,
+			Key:       m.Key,
+			Value:     m.Value,
+			Headers:   m.Headers,
+			Time:      m.Time,
+		})
+		c.mu.Unlock()
+	}
+}
+
+// StatusWriter produces keyed status records, such as the acknowledgements
+// of control commands.
+type StatusWriter struct {
+	w *kafka.Writer
+}
+
+// NewStatusWriter creates a StatusWriter for topic.
+func NewStatusWriter(brokers []string, topic string, tlsCfg *tls.Config, mechanism sasl.Mechanism, timeouts Timeouts) *StatusWriter {
+	timeouts = timeouts.withDefaults()
+	return &StatusWriter{w: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchTimeout: writerBatchTimeout,
+		WriteTimeout: timeouts.Write,
+		ReadTimeout:  timeouts.Write,
+		Transport:    NewTransport(tlsCfg, mechanism, timeouts),
+	}}
+}
+
+// Send writes one record.
+func (s *StatusWriter) Send(ctx context.Context, key, value []byte) error {
+	if err := s.w.WriteMessages(ctx, kafka.Message{Key: key, Value: value}); err != nil {
+		return fmt.Errorf("write to status topic %s: %w", s.w.Topic, err)
+	}
+	return nil
+}
+
+// Close flushes pending writes and closes the writer.
+func (s *StatusWriter) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,118 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"expvar"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+
+	"github.com/gor0utine/kafka-to-es/internal/metrics"
+)
+
+// Headers added to dead-lettered messages.
+const (
+	HeaderDLQTopic     = "dlq.original.topic"
+	HeaderDLQPartition = "dlq.original.partition"
+	HeaderDLQOffset    = "dlq.original.offset"
+	HeaderDLQError     = "dlq.error"
+)
+
+// writerBatchTimeout is how long the single-record writers wait for more
+// records before sending a batch. WriteMessages blocks for it, so it is kept
+// short instead of kafka-go's default of one second.
+const writerBatchTimeout = 5 * time.Millisecond
+
+// DeadLetterWriter produces messages that could not be indexed to a dead
+// letter topic. The original key, value and headers are kept; the source
+// position and the error are added as headers.
+type DeadLetterWriter struct {
+	w    *kafka.Writer
+	sent *expvar.Int
+}
+
+// NewDeadLetterWriter creates a DeadLetterWriter for topic that counts the
+// messages it sends in reg, or in the top-level registry if reg is nil.
+func NewDeadLetterWriter(brokers []string, topic string, tlsCfg *tls.Config, mechanism sasl.Mechanism, timeouts Timeouts, reg *metrics.Registry) *DeadLetterWriter {
+	timeouts = timeouts.withDefaults()
+	return &DeadLetterWriter{sent: reg.Counter("dlq_messages_" + topic), w: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchTimeout: writerBatchTimeout,
+		WriteTimeout: timeouts.Write,
+		ReadTimeout:  timeouts.Write,
+		Transport:    NewTransport(tlsCfg, mechanism, timeouts),
+	}}
+}
+
+// Send writes msg to the dead letter topic with reason attached.
+func (d *DeadLetterWriter) Send(ctx context.Context, msg *Message, reason error) error {
+	headers := make([]kafka.Header, 0, len(msg.Headers)+4)
+	headers = append(headers, msg.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: HeaderDLQTopic, Value: []byte(msg.Topic)},
+		kafka.Header{Key: HeaderDLQPartition, Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: HeaderDLQOffset, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+	)
+	if reason != nil {
+		headers = append(headers, kafka.Header{Key: HeaderDLQError, Value: []byte(reason.Error())})
+	}
+	err := d.w.WriteMessages(ctx, kafka.Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+		Time:    msg.Time,
+	})
+	if err != nil {
+		return fmt.Errorf("write to dead letter topic %s: %w", d.w.Topic, err)
+	}
+	d.sent.Add(1)
+	return nil
+}
+
+// Topic returns the dead letter topic.
+func (d *DeadLetterWriter) Topic() string {
+	return d.w.Topic
+}
+
+// Close flushes pending writes and closes the writer.
+func (d *DeadLetterWriter) Close() error {
+	return d.w.Close()
+}
+
+// ListTopics returns the sorted names of the topics on the cluster that
+// match any of the glob patterns, as understood by path.Match. Internal
+// topics are skipped.
+func ListTopics(ctx context.Context, brokers []string, tlsCfg *tls.Config, mechanism sasl.Mechanism, timeouts Timeouts, patterns []string) ([]string, error) {
+	client := &kafka.Client{
+		Addr:      kafka.TCP(brokers...),
+		Transport: NewTransport(tlsCfg, mechanism, timeouts),
+		Timeout:   timeouts.withDefaults().Request,
+	}
+	meta, err := client.Metadata(ctx, &kafka.MetadataRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("fetch metadata: %w", err)
+	}
+	var topics []string
+	for _, t := range meta.Topics {
+		if t.Internal || t.Error != nil {
+			continue
+		}
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, t.Name); ok {
+				topics = append(topics, t.Name)
+				break
+			}
+		}
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
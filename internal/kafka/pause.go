@@ -0,0 +1,76 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// ErrUnknownTopic is returned by Pause and Resume for a topic the consumer
+// is not subscribed to.
+var ErrUnknownTopic = errors.New("unknown topic")
+
+// Pause stops fetching from topic until Resume is called. Its partitions
+// stay assigned, so the group does not rebalance, and messages already
+// fetched are still processed and committed. Pausing a paused topic is a
+// no-op.
+func (cm *ConsumerManager) Pause(topic string) error {
+	if !slices.Contains(cm.Topics(), topic) {
+		return fmt.Errorf("%w %s", ErrUnknownTopic, topic)
+	}
+	cm.pauseMu.Lock()
+	defer cm.pauseMu.Unlock()
+	if _, ok := cm.paused[topic]; ok {
+		return nil
+	}
+	if cm.paused == nil {
+		cm.paused = make(map[string]chan struct{})
+	}
+	cm.paused[topic] = make(chan struct{})
+	return nil
+}
+
+// Resume restarts fetching from a paused topic. Resuming a topic that is
+// not paused is a no-op.
+func (cm *ConsumerManager) Resume(topic string) error {
+	if !slices.Contains(cm.Topics(), topic) {
+		return fmt.Errorf("%w %s", ErrUnknownTopic, topic)
+	}
+	cm.pauseMu.Lock()
+	defer cm.pauseMu.Unlock()
+	if ch, ok := cm.paused[topic]; ok {
+		close(ch)
+		delete(cm.paused, topic)
+	}
+	return nil
+}
+
+// Paused returns the sorted list of paused topics.
+func (cm *ConsumerManager) Paused() []string {
+	cm.pauseMu.Lock()
+	defer cm.pauseMu.Unlock()
+	topics := make([]string, 0, len(cm.paused))
+	for t := range cm.paused {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// waitResumed blocks while topic is paused or until ctx is done.
+func (cm *ConsumerManager) waitResumed(ctx context.Context, topic string) error {
+	cm.pauseMu.Lock()
+	ch, ok := cm.paused[topic]
+	cm.pauseMu.Unlock()
+	if !ok {
+		return nil
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,53 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPauseResume(t *testing.T) {
+	cm := &ConsumerManager{groups: []*consumerGroup{{topics: []string{"orders", "users"}}}}
+	if err := cm.Pause("missing"); !errors.Is(err, ErrUnknownTopic) {
+		t.Fatalf("Pause(missing) error = %v, want ErrUnknownTopic", err)
+	}
+	if err := cm.waitResumed(context.Background(), "orders"); err != nil {
+		t.Fatalf("waitResumed() on a running topic = %v", err)
+	}
+
+	for range 2 {
+		if err := cm.Pause("users"); err != nil {
+			t.Fatalf("Pause() error = %v", err)
+		}
+	}
+	if got := cm.Paused(); !reflect.DeepEqual(got, []string{"users"}) {
+		t.Errorf("Paused() = %v", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := cm.waitResumed(ctx, "users"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("waitResumed() on a paused topic = %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cm.waitResumed(context.Background(), "users") }()
+	for range 2 {
+		if err := cm.Resume("users"); err != nil {
+			t.Fatalf("Resume() error = %v", err)
+		}
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("waitResumed() after Resume = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Resume did not release the waiting fetcher")
+	}
+	if got := cm.Paused(); len(got) != 0 {
+		t.Errorf("Paused() after Resume = %v", got)
+	}
+}
@@ -0,0 +1,157 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Probe inspects a cluster and the progress of a consumer group without
+// joining the group.
+type Probe struct {
+	client    *kafka.Client
+	isolation kafka.IsolationLevel
+}
+
+// NewProbe creates a Probe for the brokers of config.
+func NewProbe(config ConsumerConfig) *Probe {
+	return &Probe{
+		isolation: config.IsolationLevel,
+		client: &kafka.Client{
+			Addr:      kafka.TCP(config.Brokers...),
+			Transport: NewTransport(config.TLS, config.SASL, config.Timeouts),
+			Timeout:   config.Timeouts.withDefaults().Request,
+		},
+	}
+}
+
+// Cluster describes the brokers and topics of a cluster.
+type Cluster struct {
+	Brokers    int
+	Controller string
+	// Partitions lists the partitions of every topic the client may
+	// describe.
+	Partitions map[string][]int
+	// Internal holds the names of internal topics such as
+	// __consumer_offsets.
+	Internal map[string]bool
+}
+
+// Match returns the sorted names of the topics that match pattern, leaving
+// out internal topics as ListTopics does.
+func (c *Cluster) Match(pattern string) []string {
+	var topics []string
+	for t := range c.Partitions {
+		if c.Internal[t] {
+			continue
+		}
+		if ok, _ := path.Match(pattern, t); ok {
+			topics = append(topics, t)
+		}
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Cluster fetches the metadata of every topic.
+func (p *Probe) Cluster(ctx context.Context) (*Cluster, error) {
+	meta, err := p.client.Metadata(ctx, &kafka.MetadataRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("fetch metadata: %w", err)
+	}
+	c := &Cluster{
+		Brokers:    len(meta.Brokers),
+		Controller: net.JoinHostPort(meta.Controller.Host, strconv.Itoa(meta.Controller.Port)),
+		Partitions: make(map[string][]int, len(meta.Topics)),
+		Internal:   make(map[string]bool),
+	}
+	for _, t := range meta.Topics {
+		if t.Error != nil {
+			continue
+		}
+		if t.Internal {
+			c.Internal[t.Name] = true
+		}
+		ids := make([]int, len(t.Partitions))
+		for i, part := range t.Partitions {
+			ids[i] = part.ID
+		}
+		sort.Ints(ids)
+		c.Partitions[t.Name] = ids
+	}
+	return c, nil
+}
+
+// PartitionLag is the position of a consumer group on a partition.
+type PartitionLag struct {
+	Topic     string
+	Partition int
+	// Committed is the group's committed offset, or -1 if it has none.
+	Committed int64
+	// First and End are the first offset the broker holds and the offset of
+	// the next message produced.
+	First, End int64
+}
+
+// Lag returns the messages the group has yet to consume, counting all of
+// them if it has not committed an offset.
+func (l PartitionLag) Lag() int64 {
+	from := l.Committed
+	if from < l.First {
+		from = l.First
+	}
+	if l.End < from {
+		return 0
+	}
+	return l.End - from
+}
+
+// GroupLag returns the committed offsets of group on partitions and how far
+// each trails the end. Reading them requires the Describe permission on the
+// group and on the topics.
+func (p *Probe) GroupLag(ctx context.Context, group string, partitions map[string][]int) ([]PartitionLag, error) {
+	res, err := p.client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: group, Topics: partitions})
+	if err != nil {
+		return nil, fmt.Errorf("fetch offsets of group %s: %w", group, err)
+	}
+	if res.Error != nil {
+		return nil, fmt.Errorf("fetch offsets of group %s: %w", group, res.Error)
+	}
+	first, err := listOffsets(ctx, p.client, p.isolation, partitions, kafka.FirstOffsetOf)
+	if err != nil {
+		return nil, err
+	}
+	end, err := listOffsets(ctx, p.client, p.isolation, partitions, kafka.LastOffsetOf)
+	if err != nil {
+		return nil, err
+	}
+
+	var lags []PartitionLag
+	for topic, parts := range res.Topics {
+		for _, part := range parts {
+			if part.Error != nil {
+				return nil, fmt.Errorf("fetch offsets of group %s for %s/%d: %w", group, topic, part.Partition, part.Error)
+			}
+			tp := topicPartition{topic, part.Partition}
+			lags = append(lags, PartitionLag{
+				Topic:     topic,
+				Partition: part.Partition,
+				Committed: part.CommittedOffset,
+				First:     first[tp],
+				End:       end[tp],
+			})
+		}
+	}
+	sort.Slice(lags, func(i, j int) bool {
+		if lags[i].Topic != lags[j].Topic {
+			return lags[i].Topic < lags[j].Topic
+		}
+		return lags[i].Partition < lags[j].Partition
+	})
+	return lags, nil
+}
@@ -0,0 +1,36 @@
+package kafka
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPartitionLag(t *testing.T) {
+	for _, tc := range []struct {
+		l    PartitionLag
+		want int64
+	}{
+		{PartitionLag{Committed: 40, First: 0, End: 100}, 60},
+		{PartitionLag{Committed: -1, First: 20, End: 100}, 80},
+		{PartitionLag{Committed: 5, First: 20, End: 100}, 80},
+		{PartitionLag{Committed: 100, First: 0, End: 100}, 0},
+		{PartitionLag{Committed: 120, First: 0, End: 100}, 0},
+	} {
+		if got := tc.l.Lag(); got != tc.want {
+			t.Errorf("%+v.Lag() = %d, want %d", tc.l, got, tc.want)
+		}
+	}
+}
+
+func TestClusterMatchSkipsInternalTopics(t *testing.T) {
+	c := &Cluster{
+		Partitions: map[string][]int{"orders": {0}, "order-events": {0}, "__consumer_offsets": {0}, "__transaction_state": {0}},
+		Internal:   map[string]bool{"__consumer_offsets": true, "__transaction_state": true},
+	}
+	if got := c.Match("*"); !slices.Equal(got, []string{"order-events", "orders"}) {
+		t.Errorf("Match(*) = %v", got)
+	}
+	if got := c.Match("__*"); len(got) != 0 {
+		t.Errorf("Match(__*) = %v, want no internal topics", got)
+	}
+}
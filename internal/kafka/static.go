@@ -0,0 +1,122 @@
+package kafka
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// staticMemberID identifies the static assignment in Assignments.
+const staticMemberID = "static"
+
+// staticAssignment tracks the partition consumers of a ConsumerManager with
+// StaticPartitions.
+type staticAssignment struct {
+	topics     []string
+	partitions map[string][]int
+
+	cancel   context.CancelFunc
+	released chan struct{} // closed by close to let consumers commit and exit
+	once     sync.Once
+	wg       sync.WaitGroup
+}
+
+func newStaticAssignment(partitions map[string][]int) *staticAssignment {
+	s := &staticAssignment{
+		partitions: make(map[string][]int, len(partitions)),
+		released:   make(chan struct{}),
+		cancel:     func() {},
+	}
+	for topic, ids := range partitions {
+		ids = append([]int(nil), ids...)
+		sort.Ints(ids)
+		s.partitions[topic] = ids
+		s.topics = append(s.topics, topic)
+	}
+	sort.Strings(s.topics)
+	return s
+}
+
+func (s *staticAssignment) assignment() GroupAssignment {
+	a := GroupAssignment{
+		Topics:     s.topics,
+		MemberID:   staticMemberID,
+		Partitions: make(map[string][]int, len(s.partitions)),
+	}
+	for topic, ids := range s.partitions {
+		a.Partitions[topic] = append([]int(nil), ids...)
+	}
+	return a
+}
+
+// close stops the partition consumers and waits for their final commits.
+func (s *staticAssignment) close() {
+	s.once.Do(func() {
+		s.cancel()
+		close(s.released)
+	})
+	s.wg.Wait()
+}
+
+// startStatic starts a consumer for every statically assigned partition.
+func (cm *ConsumerManager) startStatic(ctx context.Context, sink Sink) {
+	ctx, cm.static.cancel = context.WithCancel(ctx)
+	for _, topic := range cm.static.topics {
+		for _, p := range cm.static.partitions[topic] {
+			cm.static.wg.Add(1)
+			go cm.consumeStatic(ctx, topic, p, sink)
+		}
+	}
+}
+
+// consumeStatic reads one statically assigned partition, resuming from its
+// checkpoint. The final offset is checkpointed once Close is called, after
+// the indexer has had a chance to flush.
+func (cm *ConsumerManager) consumeStatic(ctx context.Context, topic string, partition int, sink Sink) {
+	defer cm.static.wg.Done()
+	logger := slog.With("topic", topic, "partition", partition)
+	tuning := cm.tuningFor(topic)
+
+	offset, ok := cm.loadCheckpoint(ctx, topic, partition, logger)
+	if !ok {
+		return
+	}
+	if offset < 0 {
+		offset = tuning.StartOffset
+	}
+	r := kafka.NewReader(cm.partitionReaderConfig(topic, partition))
+	defer r.Close()
+	if err := r.SetOffset(offset); err != nil {
+		logger.Error("failed to set offset", "offset", offset, "error", err)
+		return
+	}
+
+	cm.commits.Assign(topic, partition, cm.config.Checkpoints, tuning.CommitSync)
+	logger.Info("starting partition consumer", "offset", offset, "member", staticMemberID)
+	cm.fetch(ctx, r, topic, partition, sink, logger)
+
+	<-cm.static.released
+	cm.commits.Revoke(topic, partition, 0)
+	logger.Info("partition released")
+}
+
+// loadCheckpoint reads a partition's stored offset, retrying until it
+// succeeds or ctx is done.
+func (cm *ConsumerManager) loadCheckpoint(ctx context.Context, topic string, partition int, logger *slog.Logger) (int64, bool) {
+	for {
+		offset, err := cm.config.Checkpoints.Offset(ctx, topic, partition)
+		if err == nil {
+			return offset, true
+		}
+		logger.Error("failed to load checkpoint", "error", err)
+		select {
+		case <-time.After(cm.config.RetryInterval):
+		case <-ctx.Done():
+			return 0, false
+		}
+	}
+}
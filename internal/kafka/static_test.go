@@ -0,0 +1,71 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type fakeCheckpoints struct {
+	fakeCommitter
+}
+
+func (f *fakeCheckpoints) Offset(context.Context, string, int) (int64, error) {
+	return -1, nil
+}
+
+func TestStaticPartitions(t *testing.T) {
+	cfg := ConsumerConfig{
+		Brokers:          []string{"localhost:9092"},
+		Topics:           []string{"ignored"},
+		StaticPartitions: map[string][]int{"b": {3, 1}, "a": {0}},
+	}
+	if _, err := NewConsumerManager(cfg); err == nil {
+		t.Fatal("expected error without a checkpoint store")
+	}
+
+	cfg.Checkpoints = &fakeCheckpoints{}
+	cm, err := NewConsumerManager(cfg)
+	if err != nil {
+		t.Fatalf("NewConsumerManager() error = %v", err)
+	}
+	if len(cm.groups) != 0 {
+		t.Errorf("static assignment joined %d groups", len(cm.groups))
+	}
+	if got := cm.Topics(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Topics() = %v", got)
+	}
+	a := cm.Assignments()
+	if len(a) != 1 || a[0].MemberID != staticMemberID || !reflect.DeepEqual(a[0].Partitions["b"], []int{1, 3}) {
+		t.Errorf("Assignments() = %+v", a)
+	}
+	if err := cm.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestCheckpointCommitterWritesCheckpointFirst(t *testing.T) {
+	store := &fakeCheckpoints{}
+	group := &fakeCommitter{}
+	c := &checkpointCommitter{checkpoints: store, group: group}
+	if err := c.CommitOffsets(map[string]map[int]int64{"a": {0: 5}}); err != nil {
+		t.Fatalf("CommitOffsets() error = %v", err)
+	}
+	if off, _ := group.last("a", 0); off != 5 {
+		t.Errorf("group offset = %d, want 5", off)
+	}
+
+	store.err = errors.New("es down")
+	if err := c.CommitOffsets(map[string]map[int]int64{"a": {0: 9}}); err == nil {
+		t.Fatal("expected checkpoint error")
+	}
+	if off, _ := group.last("a", 0); off != 5 {
+		t.Errorf("group offset committed although the checkpoint failed: %d", off)
+	}
+
+	cm := &ConsumerManager{}
+	if _, ok := cm.groupCommitter(nil).(*checkpointCommitter); ok {
+		t.Error("group committer wraps a checkpoint store that is not configured")
+	}
+}
@@ -0,0 +1,101 @@
+package kafka
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// ReaderTuning controls how a topic is fetched and committed. Zero values
+// select the defaults from DefaultConsumerConfig or kafka-go.
+type ReaderTuning struct {
+	MinBytes      int
+	MaxBytes      int
+	MaxWait       time.Duration
+	QueueCapacity int
+	// StartOffset is kafka.FirstOffset or kafka.LastOffset and applies when
+	// the group has no committed offset.
+	StartOffset int64
+	// CommitSync commits as soon as a message is acknowledged. When false,
+	// acknowledged offsets are batched and committed every CommitInterval
+	// or once the commit threshold is reached. CommitInterval is only read
+	// from ConsumerConfig.ReaderTuning.
+	CommitSync        bool
+	CommitInterval    time.Duration
+	SessionTimeout    time.Duration
+	HeartbeatInterval time.Duration
+	RebalanceTimeout  time.Duration
+	IsolationLevel    kafka.IsolationLevel
+	GroupBalancers    []kafka.GroupBalancer
+}
+
+// withDefaults fills unset fields from DefaultConsumerConfig.
+func (t ReaderTuning) withDefaults() ReaderTuning {
+	def := DefaultConsumerConfig().ReaderTuning
+	if t.MinBytes <= 0 {
+		t.MinBytes = def.MinBytes
+	}
+	if t.MaxBytes <= 0 {
+		t.MaxBytes = def.MaxBytes
+	}
+	if t.StartOffset == 0 {
+		t.StartOffset = def.StartOffset
+	}
+	return t
+}
+
+// Special offsets, re-exported from kafka-go.
+const (
+	FirstOffset = kafka.FirstOffset
+	LastOffset  = kafka.LastOffset
+)
+
+// ParseStartOffset maps "earliest"/"first" and "latest"/"last" to the
+// kafka-go offset constants. An empty string selects the default.
+func ParseStartOffset(s string) (int64, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return 0, nil
+	case "earliest", "first":
+		return kafka.FirstOffset, nil
+	case "latest", "last":
+		return kafka.LastOffset, nil
+	default:
+		return 0, fmt.Errorf("invalid start offset %q (want earliest or latest)", s)
+	}
+}
+
+// ParseIsolationLevel maps "read_uncommitted" and "read_committed" to the
+// kafka-go isolation levels. An empty string selects read_uncommitted.
+func ParseIsolationLevel(s string) (kafka.IsolationLevel, error) {
+	switch strings.ToLower(s) {
+	case "", "read_uncommitted":
+		return kafka.ReadUncommitted, nil
+	case "read_committed":
+		return kafka.ReadCommitted, nil
+	default:
+		return 0, fmt.Errorf("invalid isolation level %q (want read_uncommitted or read_committed)", s)
+	}
+}
+
+// ParseGroupBalancers maps partition assignment strategy names ("range",
+// "round_robin", "rack_affinity") to kafka-go group balancers, in order of
+// preference.
+func ParseGroupBalancers(names []string) ([]kafka.GroupBalancer, error) {
+	var balancers []kafka.GroupBalancer
+	for _, n := range names {
+		switch strings.ToLower(strings.ReplaceAll(n, "-", "_")) {
+		case "range":
+			balancers = append(balancers, kafka.RangeGroupBalancer{})
+		case "round_robin", "roundrobin":
+			balancers = append(balancers, kafka.RoundRobinGroupBalancer{})
+		case "rack_affinity":
+			balancers = append(balancers, kafka.RackAffinityGroupBalancer{})
+		default:
+			return nil, fmt.Errorf("unknown partition assignment strategy %q", n)
+		}
+	}
+	return balancers, nil
+}
@@ -0,0 +1,105 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestParseTuning(t *testing.T) {
+	if off, err := ParseStartOffset("latest"); err != nil || off != kafka.LastOffset {
+		t.Errorf("ParseStartOffset(latest) = %d, %v", off, err)
+	}
+	if off, err := ParseStartOffset("earliest"); err != nil || off != kafka.FirstOffset {
+		t.Errorf("ParseStartOffset(earliest) = %d, %v", off, err)
+	}
+	if _, err := ParseStartOffset("middle"); err == nil {
+		t.Error("expected error for invalid start offset")
+	}
+	if lvl, err := ParseIsolationLevel("read_committed"); err != nil || lvl != kafka.ReadCommitted {
+		t.Errorf("ParseIsolationLevel(read_committed) = %v, %v", lvl, err)
+	}
+	b, err := ParseGroupBalancers([]string{"round-robin", "range"})
+	if err != nil || len(b) != 2 || b[0].ProtocolName() != "roundrobin" {
+		t.Errorf("ParseGroupBalancers() = %v, %v", b, err)
+	}
+	if _, err := ParseGroupBalancers([]string{"sticky"}); err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+}
+
+func TestReaderConfigAppliesTopicTuning(t *testing.T) {
+	cm := &ConsumerManager{config: ConsumerConfig{
+		Brokers: []string{"localhost:9092"},
+		GroupID: "g",
+		ReaderTuning: ReaderTuning{
+			MaxWait:        time.Second,
+			SessionTimeout: 20 * time.Second,
+		},
+		TopicTuning: map[string]ReaderTuning{
+			"fast": {
+				MaxWait:        100 * time.Millisecond,
+				StartOffset:    kafka.LastOffset,
+				IsolationLevel: kafka.ReadCommitted,
+				CommitSync:     true,
+			},
+		},
+	}}
+
+	def := cm.partitionReaderConfig("default", 3)
+	if def.MaxWait != time.Second || def.MinBytes != 1 || def.Partition != 3 || def.GroupID != "" {
+		t.Errorf("unexpected default reader config: %+v", def)
+	}
+	fast := cm.partitionReaderConfig("fast", 0)
+	if fast.MaxWait != 100*time.Millisecond {
+		t.Errorf("override not applied: %+v", fast)
+	}
+	if fast.IsolationLevel != kafka.ReadCommitted {
+		t.Errorf("expected read_committed, got %v", fast.IsolationLevel)
+	}
+	if tn := cm.tuningFor("fast"); tn.StartOffset != kafka.LastOffset || !tn.CommitSync {
+		t.Errorf("unexpected tuning for fast: %+v", tn)
+	}
+
+	gc := cm.groupConfig([]string{"default"})
+	if gc.ID != "g" || gc.SessionTimeout != 20*time.Second || gc.StartOffset != kafka.FirstOffset || !gc.WatchPartitionChanges {
+		t.Errorf("unexpected group config: %+v", gc)
+	}
+}
+
+func TestSingleGroupUsesGlobalTuning(t *testing.T) {
+	cm := &ConsumerManager{config: ConsumerConfig{
+		GroupID:      "g",
+		ReaderTuning: ReaderTuning{SessionTimeout: 30 * time.Second},
+		TopicTuning:  map[string]ReaderTuning{"a": {SessionTimeout: time.Second}},
+	}}
+	gc := cm.groupConfig([]string{"a", "b"})
+	if len(gc.Topics) != 2 || gc.SessionTimeout != 30*time.Second {
+		t.Errorf("unexpected group config: %+v", gc)
+	}
+}
+
+func TestGroupAssignmentSnapshot(t *testing.T) {
+	g := &consumerGroup{topics: []string{"a", "b"}}
+	g.setGeneration(&kafka.Generation{
+		ID:       7,
+		MemberID: "m-1",
+		Assignments: map[string][]kafka.PartitionAssignment{
+			"a": {{ID: 2}, {ID: 0}},
+			"b": {{ID: 1}},
+		},
+	})
+	a := g.assignment()
+	if a.Generation != 7 || a.MemberID != "m-1" || len(a.Partitions["a"]) != 2 || a.Partitions["a"][0] != 0 {
+		t.Errorf("unexpected assignment %+v", a)
+	}
+	if got := formatAssignments(map[string][]kafka.PartitionAssignment{"b": {{ID: 1}}, "a": {{ID: 2}, {ID: 0}}}); got != "a:0,2 b:1" {
+		t.Errorf("formatAssignments() = %q", got)
+	}
+
+	g.setGeneration(nil)
+	if a := g.assignment(); len(a.Partitions) != 0 {
+		t.Errorf("expected no partitions after generation ended, got %v", a.Partitions)
+	}
+}
@@ -0,0 +1,63 @@
+// Package logging sets up the process-wide logger and lets its level change
+// at runtime.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"sync/atomic"
+)
+
+// Log formats accepted by Setup.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var (
+	level = new(slog.LevelVar)
+	// structured is set once the default logger writes JSON; the log
+	// package is then bridged into it and the level lives in level alone.
+	structured atomic.Bool
+)
+
+// Setup makes the default logger write to w in format, dropping records
+// below lvl. Text keeps the format of the standard log package; JSON also
+// carries the output of the log package, as records at level INFO.
+func Setup(w io.Writer, format string, lvl slog.Level) error {
+	switch format {
+	case FormatText, "":
+		log.SetOutput(w)
+	case FormatJSON:
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})))
+		structured.Store(true)
+	default:
+		return fmt.Errorf("unknown log format %q (want %q or %q)", format, FormatText, FormatJSON)
+	}
+	set(lvl)
+	return nil
+}
+
+// Level returns the lowest level that is logged.
+func Level() slog.Level {
+	return level.Level()
+}
+
+// SetLevel changes the lowest level that is logged.
+func SetLevel(lvl slog.Level) {
+	if old := set(lvl); old != lvl {
+		slog.Warn("log level changed", "from", old, "to", lvl)
+	}
+}
+
+// set changes the level and returns the previous one.
+func set(lvl slog.Level) slog.Level {
+	old := level.Level()
+	level.Set(lvl)
+	if !structured.Load() {
+		slog.SetLogLoggerLevel(lvl)
+	}
+	return old
+}
@@ -1,13 +1,32 @@
 package mapper
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
 
 // Mapper provides mapping from Kafka topics to Elasticsearch indices.
 // It allows configuration of custom topic->index mappings and handles
-// fallback scenarios when a topic has no explicit mapping.
+// fallback scenarios when a topic has no explicit mapping. It is safe for
+// concurrent use, so mappings can be changed while workers are running.
+//
+// Mappings come in two layers: the base mappings, usually those of the
+// config file, and the overrides made at runtime with AddMapping,
+// RemoveMapping and SetMappings. Overrides win over the base and survive
+// SetBaseMappings, so a config reload does not undo them.
 type Mapper struct {
-	mappings map[string]string
-	fallback func(string) string // Custom fallback strategy
+	mu        sync.RWMutex
+	base      map[string]string
+	overrides map[string]override
+	mappings  map[string]string   // base with the overrides applied
+	fallback  func(string) string // Custom fallback strategy
+}
+
+// override is a runtime change of one topic's mapping.
+type override struct {
+	index   string
+	removed bool // the topic uses the fallback even if the base maps it
 }
 
 // Option represents a configuration option for the Mapper.
@@ -21,17 +40,15 @@ func WithFallbackStrategy(strategy func(topic string) string) Option {
 	}
 }
 
-// New creates a Mapper with the given topic->index mappings and options.
+// New creates a Mapper with the given topic->index mappings as its base and
+// options.
 func New(mappings map[string]string, opts ...Option) *Mapper {
 	m := &Mapper{
-		mappings: make(map[string]string, len(mappings)),
-		fallback: func(topic string) string { return topic }, // Default fallback
-	}
-
-	// Copy mappings to prevent external modification
-	for k, v := range mappings {
-		m.mappings[k] = v
+		overrides: make(map[string]override),
+		fallback:  func(topic string) string { return topic }, // Default fallback
 	}
+	m.base = copyMappings(mappings)
+	m.mappings = copyMappings(mappings)
 
 	// Apply options
 	for _, opt := range opts {
@@ -44,7 +61,10 @@ func New(mappings map[string]string, opts ...Option) *Mapper {
 // IndexForTopic returns the index name for a given topic.
 // If no mapping exists, it uses the fallback strategy.
 func (m *Mapper) IndexForTopic(topic string) string {
-	if idx, ok := m.mappings[topic]; ok {
+	m.mu.RLock()
+	idx, ok := m.mappings[topic]
+	m.mu.RUnlock()
+	if ok {
 		return idx
 	}
 	return m.fallback(topic)
@@ -52,19 +72,85 @@ func (m *Mapper) IndexForTopic(topic string) string {
 
 // AddMapping adds or updates a topic->index mapping.
 func (m *Mapper) AddMapping(topic, index string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.overrides[topic] = override{index: index}
 	m.mappings[topic] = index
 }
 
+// RemoveMapping deletes the mapping of topic, which falls back to the
+// fallback strategy afterwards.
+func (m *Mapper) RemoveMapping(topic string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.overrides[topic] = override{removed: true}
+	delete(m.mappings, topic)
+}
+
+// SetMappings replaces all mappings at once, overriding every base
+// mapping. Lookups see either the old or the new set, never a mix.
+func (m *Mapper) SetMappings(mappings map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	next := make(map[string]override, len(mappings)+len(m.base))
+	for topic := range m.base {
+		next[topic] = override{removed: true}
+	}
+	for topic, index := range mappings {
+		next[topic] = override{index: index}
+	}
+	m.overrides = next
+	m.mappings = copyMappings(mappings)
+}
+
+// SetBaseMappings replaces the base mappings at once and keeps the
+// overrides. Lookups see either the old or the new set, never a mix.
+func (m *Mapper) SetBaseMappings(mappings map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.base = copyMappings(mappings)
+	next := copyMappings(mappings)
+	for topic, o := range m.overrides {
+		if o.removed {
+			delete(next, topic)
+		} else {
+			next[topic] = o.index
+		}
+	}
+	m.mappings = next
+}
+
+// Overridden returns the topics whose mapping was changed at runtime,
+// sorted.
+func (m *Mapper) Overridden() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	topics := make([]string, 0, len(m.overrides))
+	for topic := range m.overrides {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
 // GetMappings returns a copy of the current mappings.
 func (m *Mapper) GetMappings() map[string]string {
-	result := make(map[string]string, len(m.mappings))
-	for k, v := range m.mappings {
-		result[k] = v
-	}
-	return result
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return copyMappings(m.mappings)
 }
 
 // String implements the Stringer interface.
 func (m *Mapper) String() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	return fmt.Sprintf("Mapper{mappings: %v}", m.mappings)
 }
+
+func copyMappings(mappings map[string]string) map[string]string {
+	out := make(map[string]string, len(mappings))
+	for k, v := range mappings {
+		out[k] = v
+	}
+	return out
+}
@@ -1,6 +1,7 @@
 package mapper
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -46,6 +47,15 @@ func TestAddMapping(t *testing.T) {
 	}
 }
 
+func TestRemoveMapping(t *testing.T) {
+	m := New(map[string]string{"t": "i"})
+	m.RemoveMapping("t")
+	if got := m.IndexForTopic("t"); got != "t" {
+		t.Errorf("RemoveMapping failed: got %q, want fallback %q", got, "t")
+	}
+	m.RemoveMapping("missing")
+}
+
 func TestGetMappingsReturnsCopy(t *testing.T) {
 	m := New(map[string]string{"a": "b"})
 	cpy := m.GetMappings()
@@ -62,3 +72,50 @@ func TestStringer(t *testing.T) {
 		t.Error("String() returned empty string")
 	}
 }
+
+func TestSetMappingsConcurrent(t *testing.T) {
+	m := New(map[string]string{"a": "old"})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			if idx := m.IndexForTopic("a"); idx != "old" && idx != "new" {
+				t.Errorf("IndexForTopic() = %q", idx)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		m.AddMapping("b", "x")
+		m.SetMappings(map[string]string{"a": "new"})
+	}
+	<-done
+	if got := m.IndexForTopic("a"); got != "new" {
+		t.Errorf("IndexForTopic() = %q, want new", got)
+	}
+	if _, ok := m.GetMappings()["b"]; ok {
+		t.Error("SetMappings kept a mapping that is not in the new set")
+	}
+}
+
+func TestSetBaseMappingsKeepsOverrides(t *testing.T) {
+	m := New(map[string]string{"a": "a-v1", "b": "b-v1", "c": "c-v1"})
+	m.AddMapping("a", "a-runtime")
+	m.RemoveMapping("b")
+
+	m.SetBaseMappings(map[string]string{"a": "a-v2", "b": "b-v2", "c": "c-v2", "d": "d-v2"})
+	want := map[string]string{"a": "a-runtime", "c": "c-v2", "d": "d-v2"}
+	if got := m.GetMappings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("mappings after SetBaseMappings = %v, want %v", got, want)
+	}
+	if got := m.Overridden(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Overridden() = %v, want [a b]", got)
+	}
+
+	m.SetMappings(map[string]string{"e": "e-runtime"})
+	m.SetBaseMappings(map[string]string{"a": "a-v3", "e": "e-v3"})
+	want = map[string]string{"e": "e-runtime"}
+	if got := m.GetMappings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("mappings after SetMappings and SetBaseMappings = %v, want %v", got, want)
+	}
+}
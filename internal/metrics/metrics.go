@@ -0,0 +1,124 @@
+// Package metrics exposes runtime counters and gauges through expvar.
+//
+// All metrics live under the "kafka_to_es" expvar map and are served as JSON
+// by Handler, usually mounted at /debug/vars. Metrics of a named pipeline
+// live in a nested map under "pipelines"; see Pipeline.
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	mu   sync.Mutex
+	root = expvar.NewMap("kafka_to_es")
+	def  = &Registry{m: root}
+)
+
+// Registry is a named group of metrics. A nil *Registry is the top-level
+// one, so components can take an optional registry.
+type Registry struct {
+	m *expvar.Map
+}
+
+// Default returns the top-level registry.
+func Default() *Registry {
+	return def
+}
+
+// Pipeline returns the registry of the named pipeline, served under
+// "pipelines" and the name.
+func Pipeline(name string) *Registry {
+	return def.Sub("pipelines").Sub(name)
+}
+
+func (r *Registry) orDefault() *Registry {
+	if r == nil {
+		return def
+	}
+	return r
+}
+
+// Sub returns the registry nested under name, creating it on first use.
+func (r *Registry) Sub(name string) *Registry {
+	m := getOrCreate(r.orDefault().m, name, func() expvar.Var { return new(expvar.Map).Init() }).(*expvar.Map)
+	return &Registry{m: m}
+}
+
+// Counter returns the monotonically increasing counter with the given name,
+// creating it on first use.
+func (r *Registry) Counter(name string) *expvar.Int {
+	return getOrCreate(r.orDefault().m, name, func() expvar.Var { return new(expvar.Int) }).(*expvar.Int)
+}
+
+// Gauge returns the gauge with the given name, creating it on first use.
+func (r *Registry) Gauge(name string) *expvar.Int {
+	return r.Counter(name)
+}
+
+// Timer returns the timer with the given name, creating it on first use.
+func (r *Registry) Timer(name string) *Timer {
+	return getOrCreate(r.orDefault().m, name, func() expvar.Var { return new(Timer) }).(*Timer)
+}
+
+// Timer summarizes observed durations in milliseconds.
+type Timer struct {
+	mu    sync.Mutex
+	count int64
+	total float64
+	max   float64
+	last  float64
+}
+
+// Observe records one duration.
+func (t *Timer) Observe(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.count++
+	t.total += ms
+	t.last = ms
+	if ms > t.max {
+		t.max = ms
+	}
+}
+
+// Count returns the number of observations.
+func (t *Timer) Count() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.count
+}
+
+// String implements expvar.Var.
+func (t *Timer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b, _ := json.Marshal(map[string]any{
+		"count":    t.count,
+		"total_ms": t.total,
+		"max_ms":   t.max,
+		"last_ms":  t.last,
+	})
+	return string(b)
+}
+
+func getOrCreate(m *expvar.Map, name string, create func() expvar.Var) expvar.Var {
+	mu.Lock()
+	defer mu.Unlock()
+	if v := m.Get(name); v != nil {
+		return v
+	}
+	v := create()
+	m.Set(name, v)
+	return v
+}
+
+// Handler serves all published expvar variables as JSON.
+func Handler() http.Handler {
+	return expvar.Handler()
+}
@@ -0,0 +1,254 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+	"github.com/gor0utine/kafka-to-es/internal/metrics"
+)
+
+// ErrSchedulerClosed is returned by Put after Close.
+var ErrSchedulerClosed = errors.New("scheduler closed")
+
+// TopicPolicy controls how a topic shares the worker pool.
+type TopicPolicy struct {
+	// Priority orders topics strictly: queued messages of a higher priority
+	// topic are always taken first.
+	Priority int
+	// Weight is the topic's share among topics of the same priority.
+	Weight int
+	// MaxConcurrency limits how many of the topic's messages workers handle
+	// at once. Zero means no limit.
+	MaxConcurrency int
+	// QueueSize is the number of messages buffered for the topic. Consumers
+	// of a topic with a full queue block without affecting other topics.
+	QueueSize int
+}
+
+func (p TopicPolicy) withDefaults(def TopicPolicy) TopicPolicy {
+	if p.Priority == 0 {
+		p.Priority = def.Priority
+	}
+	if p.Weight <= 0 {
+		p.Weight = def.Weight
+	}
+	if p.Weight <= 0 {
+		p.Weight = 1
+	}
+	if p.MaxConcurrency <= 0 {
+		p.MaxConcurrency = def.MaxConcurrency
+	}
+	if p.QueueSize <= 0 {
+		p.QueueSize = def.QueueSize
+	}
+	if p.QueueSize <= 0 {
+		p.QueueSize = 1000
+	}
+	return p
+}
+
+// Scheduler keeps one bounded queue per topic and hands messages to workers
+// by priority, then by smooth weighted round robin. It implements kafka.Sink.
+type Scheduler struct {
+	def      TopicPolicy
+	policies map[string]TopicPolicy
+	metrics  *metrics.Registry
+
+	mu      sync.Mutex
+	queues  map[string]*topicQueue
+	order   []*topicQueue
+	changed chan struct{} // closed and replaced on every state change
+	closed  bool
+}
+
+type topicQueue struct {
+	topic   string
+	policy  TopicPolicy
+	msgs    []*kafka.Message
+	active  int
+	current int // smooth weighted round robin state
+
+	depth *expvar.Int
+}
+
+// SchedulerOption configures a Scheduler.
+type SchedulerOption func(*Scheduler)
+
+// WithQueueMetrics reports the queue depths to reg instead of the top-level
+// registry.
+func WithQueueMetrics(reg *metrics.Registry) SchedulerOption {
+	return func(s *Scheduler) {
+		s.metrics = reg
+	}
+}
+
+// NewScheduler creates a Scheduler. Topics without an entry in policies use
+// def.
+func NewScheduler(def TopicPolicy, policies map[string]TopicPolicy, opts ...SchedulerOption) *Scheduler {
+	s := &Scheduler{
+		def:      def,
+		policies: policies,
+		queues:   make(map[string]*topicQueue),
+		changed:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// queueLocked returns the queue for topic, creating it on first use.
+func (s *Scheduler) queueLocked(topic string) *topicQueue {
+	if q, ok := s.queues[topic]; ok {
+		return q
+	}
+	q := &topicQueue{
+		topic:  topic,
+		policy: s.policies[topic].withDefaults(s.def),
+		depth:  s.metrics.Gauge("scheduler_queue_depth_" + topic),
+	}
+	s.queues[topic] = q
+	s.order = append(s.order, q)
+	return q
+}
+
+func (s *Scheduler) signalLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Put queues msg on its topic's queue, blocking while that queue is full.
+// Queued messages keep counting against the flow budget.
+func (s *Scheduler) Put(ctx context.Context, msg *kafka.Message) error {
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return ErrSchedulerClosed
+		}
+		q := s.queueLocked(msg.Topic)
+		if len(q.msgs) < q.policy.QueueSize {
+			q.msgs = append(q.msgs, msg)
+			q.depth.Set(int64(len(q.msgs)))
+			s.signalLocked()
+			s.mu.Unlock()
+			return nil
+		}
+		ch := s.changed
+		s.mu.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Next returns the next message to process, blocking until one is eligible.
+// The caller must call Done once it has finished with the message. Next
+// returns false when ctx is done or the scheduler is closed and drained.
+func (s *Scheduler) Next(ctx context.Context) (*kafka.Message, bool) {
+	for {
+		s.mu.Lock()
+		if q := s.pickLocked(); q != nil {
+			msg := q.msgs[0]
+			q.msgs[0] = nil
+			q.msgs = q.msgs[1:]
+			q.active++
+			q.depth.Set(int64(len(q.msgs)))
+			s.signalLocked()
+			s.mu.Unlock()
+			return msg, true
+		}
+		if s.closed && s.emptyLocked() {
+			s.mu.Unlock()
+			return nil, false
+		}
+		ch := s.changed
+		s.mu.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// Done releases the concurrency slot taken by a message returned from Next.
+func (s *Scheduler) Done(msg *kafka.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q, ok := s.queues[msg.Topic]; ok && q.active > 0 {
+		q.active--
+		s.signalLocked()
+	}
+}
+
+// SetPolicies replaces the policies of all topics, including those that
+// already have a queue. Messages already queued are kept even if a queue
+// shrinks below its length.
+func (s *Scheduler) SetPolicies(def TopicPolicy, policies map[string]TopicPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.def = def
+	s.policies = policies
+	for topic, q := range s.queues {
+		q.policy = policies[topic].withDefaults(def)
+	}
+	s.signalLocked()
+}
+
+// Close stops accepting messages. Queued messages can still be taken.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.signalLocked()
+}
+
+// pickLocked selects the queue to serve next among the eligible queues of
+// the highest priority, using smooth weighted round robin.
+func (s *Scheduler) pickLocked() *topicQueue {
+	var eligible []*topicQueue
+	for _, q := range s.order {
+		if len(q.msgs) == 0 {
+			continue
+		}
+		if q.policy.MaxConcurrency > 0 && q.active >= q.policy.MaxConcurrency {
+			continue
+		}
+		if len(eligible) > 0 && q.policy.Priority < eligible[0].policy.Priority {
+			continue
+		}
+		if len(eligible) > 0 && q.policy.Priority > eligible[0].policy.Priority {
+			eligible = eligible[:0]
+		}
+		eligible = append(eligible, q)
+	}
+	if len(eligible) == 0 {
+		return nil
+	}
+	var best *topicQueue
+	total := 0
+	for _, q := range eligible {
+		q.current += q.policy.Weight
+		total += q.policy.Weight
+		if best == nil || q.current > best.current {
+			best = q
+		}
+	}
+	best.current -= total
+	return best
+}
+
+func (s *Scheduler) emptyLocked() bool {
+	for _, q := range s.order {
+		if len(q.msgs) > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gor0utine/kafka-to-es/internal/kafka"
+)
+
+func fill(t *testing.T, s *Scheduler, topic string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := s.Put(context.Background(), &kafka.Message{Topic: topic}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSchedulerWeights(t *testing.T) {
+	s := NewScheduler(TopicPolicy{}, map[string]TopicPolicy{
+		"heavy": {Weight: 3},
+		"light": {Weight: 1},
+	})
+	fill(t, s, "heavy", 100)
+	fill(t, s, "light", 100)
+
+	counts := make(map[string]int)
+	for i := 0; i < 40; i++ {
+		msg, ok := s.Next(context.Background())
+		if !ok {
+			t.Fatal("Next() returned false")
+		}
+		counts[msg.Topic]++
+		s.Done(msg)
+	}
+	if counts["heavy"] != 30 || counts["light"] != 10 {
+		t.Errorf("unexpected share %v, want 30/10", counts)
+	}
+}
+
+func TestSchedulerPriorityAndConcurrency(t *testing.T) {
+	s := NewScheduler(TopicPolicy{}, map[string]TopicPolicy{
+		"alerts": {Priority: 10, MaxConcurrency: 1},
+	})
+	fill(t, s, "bulk", 5)
+	fill(t, s, "alerts", 2)
+
+	ctx := context.Background()
+	first, _ := s.Next(ctx)
+	if first.Topic != "alerts" {
+		t.Fatalf("expected alerts first, got %s", first.Topic)
+	}
+	// The second alert waits for the first to finish.
+	second, _ := s.Next(ctx)
+	if second.Topic != "bulk" {
+		t.Fatalf("expected bulk while alerts is at max concurrency, got %s", second.Topic)
+	}
+	s.Done(first)
+	third, _ := s.Next(ctx)
+	if third.Topic != "alerts" {
+		t.Fatalf("expected alerts after Done, got %s", third.Topic)
+	}
+}
+
+func TestSchedulerQueueIsolation(t *testing.T) {
+	s := NewScheduler(TopicPolicy{QueueSize: 2}, nil)
+	fill(t, s, "hot", 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Put(ctx, &kafka.Message{Topic: "hot"}); err == nil {
+		t.Fatal("expected Put to block on a full queue")
+	}
+	// Other topics are not affected.
+	fill(t, s, "critical", 1)
+
+	s.Close()
+	if err := s.Put(context.Background(), &kafka.Message{Topic: "critical"}); err != ErrSchedulerClosed {
+		t.Errorf("Put() after Close = %v", err)
+	}
+	n := 0
+	for {
+		msg, ok := s.Next(context.Background())
+		if !ok {
+			break
+		}
+		s.Done(msg)
+		n++
+	}
+	if n != 3 {
+		t.Errorf("drained %d messages, want 3", n)
+	}
+}
+
+func TestWorkerPoolWithScheduler(t *testing.T) {
+	bulker := &mockBulker{}
+	s := NewScheduler(TopicPolicy{}, nil)
+	wp := NewWorkerPool(bulker, &mockMapper{index: "idx"}, nil, 2, WithScheduler(s))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wp.Start(ctx)
+
+	fill(t, s, "a", 3)
+	fill(t, s, "b", 3)
+	s.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	bulker.mu.Lock()
+	defer bulker.mu.Unlock()
+	if len(bulker.items) != 6 {
+		t.Errorf("expected 6 items, got %d", len(bulker.items))
+	}
+}
+
+func TestSchedulerSetPolicies(t *testing.T) {
+	s := NewScheduler(TopicPolicy{}, nil)
+	fill(t, s, "a", 100)
+	fill(t, s, "b", 100)
+	s.SetPolicies(TopicPolicy{}, map[string]TopicPolicy{"b": {Priority: 5}})
+
+	for i := 0; i < 10; i++ {
+		msg, _ := s.Next(context.Background())
+		if msg.Topic != "b" {
+			t.Fatalf("message %d from %s, want b after its priority was raised", i, msg.Topic)
+		}
+		s.Done(msg)
+	}
+}
@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Transform operations accepted in Transform.Op.
+const (
+	// TransformSet assigns Value to Field, creating parent objects as needed.
+	TransformSet = "set"
+	// TransformRemove deletes Field.
+	TransformRemove = "remove"
+	// TransformRename moves Field to To.
+	TransformRename = "rename"
+)
+
+// Transform edits an indexed document before it is sent to Elasticsearch.
+// Fields are dotted paths into the document, for example "payload.user.id"
+// or "key".
+type Transform struct {
+	Op    string
+	Field string
+	To    string
+	Value interface{}
+}
+
+// Validate checks that t names a known operation and the fields it needs.
+func (t Transform) Validate() error {
+	switch t.Op {
+	case TransformSet, TransformRemove:
+	case TransformRename:
+		if t.To == "" {
+			return fmt.Errorf("rename of %q needs a target field", t.Field)
+		}
+	default:
+		return fmt.Errorf("unknown transform %q", t.Op)
+	}
+	if t.Field == "" {
+		return fmt.Errorf("%s transform needs a field", t.Op)
+	}
+	return nil
+}
+
+// apply runs t on doc. Removing or renaming a missing field is not an error.
+func (t Transform) apply(doc map[string]interface{}) error {
+	switch t.Op {
+	case TransformSet:
+		return setField(doc, t.Field, t.Value)
+	case TransformRemove:
+		removeField(doc, t.Field)
+		return nil
+	case TransformRename:
+		v, ok := removeField(doc, t.Field)
+		if !ok {
+			return nil
+		}
+		return setField(doc, t.To, v)
+	}
+	return fmt.Errorf("unknown transform %q", t.Op)
+}
+
+// setField assigns v at the dotted path in doc.
+func setField(doc map[string]interface{}, field string, v interface{}) error {
+	parts := strings.Split(field, ".")
+	m := doc
+	for i, p := range parts[:len(parts)-1] {
+		next, ok := m[p]
+		if !ok || next == nil {
+			child := make(map[string]interface{})
+			m[p] = child
+			m = child
+			continue
+		}
+		child, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("set %s: %s is not an object", field, strings.Join(parts[:i+1], "."))
+		}
+		m = child
+	}
+	m[parts[len(parts)-1]] = v
+	return nil
+}
+
+// removeField deletes the dotted path from doc and returns its value.
+func removeField(doc map[string]interface{}, field string) (interface{}, bool) {
+	parts := strings.Split(field, ".")
+	m := doc
+	for _, p := range parts[:len(parts)-1] {
+		child, ok := m[p].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		m = child
+	}
+	last := parts[len(parts)-1]
+	v, ok := m[last]
+	delete(m, last)
+	return v, ok
+}
@@ -0,0 +1,52 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTransforms(t *testing.T) {
+	doc := map[string]interface{}{
+		"key": "k",
+		"payload": map[string]interface{}{
+			"user":   map[string]interface{}{"id": "u1", "password": "x"},
+			"amount": 3,
+		},
+	}
+	ts := []Transform{
+		{Op: TransformRemove, Field: "payload.user.password"},
+		{Op: TransformRename, Field: "payload.user.id", To: "user_id"},
+		{Op: TransformSet, Field: "meta.source", Value: "kafka"},
+		{Op: TransformRemove, Field: "payload.missing.field"},
+	}
+	for _, tr := range ts {
+		if err := tr.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tr.apply(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]interface{}{
+		"key":     "k",
+		"user_id": "u1",
+		"meta":    map[string]interface{}{"source": "kafka"},
+		"payload": map[string]interface{}{
+			"user":   map[string]interface{}{},
+			"amount": 3,
+		},
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("got %v, want %v", doc, want)
+	}
+
+	if err := (Transform{Op: TransformSet, Field: "key.sub", Value: 1}).apply(doc); err == nil {
+		t.Error("expected setting below a scalar to fail")
+	}
+	if err := (Transform{Op: TransformRename, Field: "a"}).Validate(); err == nil {
+		t.Error("expected rename without target to be invalid")
+	}
+	if err := (Transform{Op: "upper", Field: "a"}).Validate(); err == nil {
+		t.Error("expected unknown op to be invalid")
+	}
+}
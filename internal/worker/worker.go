@@ -2,9 +2,18 @@
 package worker
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -20,56 +29,635 @@ type Mapper interface {
 	IndexForTopic(topic string) string
 }
 
+// Dispatch selects how messages are distributed across workers.
+type Dispatch string
+
+const (
+	// DispatchShared lets any worker take any message.
+	DispatchShared Dispatch = "shared"
+	// DispatchPartition sends all messages of a partition to the same worker.
+	DispatchPartition Dispatch = "partition"
+	// DispatchKey sends all messages with the same key to the same worker.
+	// Messages without a key are routed by partition.
+	DispatchKey Dispatch = "key"
+)
+
+// Document ID strategies accepted by WithIDStrategy.
+const (
+	// IDRandom gives every message a new random document ID.
+	IDRandom = "uuid"
+	// IDKey uses the message key as document ID, so later messages for the
+	// same key overwrite earlier ones. Messages without a key fall back to
+	// the IDOffset ID, so they stay idempotent on replay.
+	IDKey = "key"
+	// IDOffset derives the ID from topic, partition and offset, so a message
+	// that is consumed again overwrites its own document.
+	IDOffset = "offset"
+)
+
+// Decoders accepted by WithDecoder. They turn the message value into the
+// document's payload field.
+const (
+	// DecoderJSON embeds the value as JSON. Values that are not valid JSON
+	// are rejected.
+	DecoderJSON = "json"
+	// DecoderString stores the value as a string.
+	DecoderString = "string"
+	// DecoderBase64 stores the value as a base64 string, for binary data.
+	DecoderBase64 = "base64"
+)
+
+// DeadLetter receives messages that could not be indexed.
+type DeadLetter interface {
+	Send(ctx context.Context, msg *kafka.Message, reason error) error
+}
+
+// deadLetterTimeout bounds how long a message may take to reach the dead
+// letter topic once indexing has failed.
+const deadLetterTimeout = 30 * time.Second
+
+// deadLetterConcurrency bounds the dead letters in flight after indexing
+// failed; further failures wait for a slot.
+const deadLetterConcurrency = 16
+
+// Retries of items whose bulk request failed: the backoff doubles from
+// retryMinDelay up to retryMaxDelay, and after retryAttempts the message is
+// dead-lettered.
+const (
+	retryMinDelay = 500 * time.Millisecond
+	retryMaxDelay = 30 * time.Second
+	retryAttempts = 10
+)
+
+// laneBuffer is the number of messages queued per worker in ordered dispatch.
+const laneBuffer = 128
+
 type Pool struct {
-	bulker Bulker
-	mapper Mapper
-	inCh   <-chan *kafka.Message
-	num    int
+	bulker   Bulker
+	mapper   Mapper
+	inCh     <-chan *kafka.Message
+	num      int
+	dispatch Dispatch
+	sched    *Scheduler
+	dlq      DeadLetter
+	wg       sync.WaitGroup
+	dlqWG    sync.WaitGroup // dead letters sent after indexing failed
+	dlqSem   chan struct{}
+	retries  []*retryQueue // by worker
+
+	retryMin, retryMax time.Duration
+	retryAttempts      int
+
+	// opts is set by the options; out is the copy in effect, which
+	// Reconfigure replaces.
+	opts output
+	out  atomic.Pointer[output]
+}
+
+// output holds how a pool turns messages into bulk items.
+type output struct {
+	idStrategy string
+	decoder    string
+	action     string
+	transforms []Transform
+	indexTmpl  string
+}
+
+// Option configures a Pool.
+type Option func(*Pool)
+
+// WithDispatch sets how messages are distributed across workers. With
+// DispatchPartition or DispatchKey each worker handles its messages in
+// arrival order; combine it with an ordered bulk indexer to preserve that
+// order in Elasticsearch.
+func WithDispatch(d Dispatch) Option {
+	return func(wp *Pool) {
+		wp.dispatch = d
+	}
+}
+
+// WithIDStrategy sets how document IDs are derived from messages.
+func WithIDStrategy(s string) Option {
+	return func(wp *Pool) {
+		wp.opts.idStrategy = s
+	}
+}
+
+// WithScheduler makes the workers take messages from s instead of the input
+// channel, so topics share the pool according to their policies.
+func WithScheduler(s *Scheduler) Option {
+	return func(wp *Pool) {
+		wp.sched = s
+	}
+}
+
+// WithDecoder sets how message values are decoded, DecoderJSON by default.
+func WithDecoder(d string) Option {
+	return func(wp *Pool) {
+		wp.opts.decoder = d
+	}
+}
+
+// WithAction sets the bulk action of every document, indexer.ActionIndex by
+// default. Updates are sent as upserts of the whole document; updates and
+// deletes need a deterministic document ID.
+func WithAction(a string) Option {
+	return func(wp *Pool) {
+		wp.opts.action = a
+	}
+}
+
+// WithTransforms applies ts in order to every document before it is indexed.
+func WithTransforms(ts ...Transform) Option {
+	return func(wp *Pool) {
+		wp.opts.transforms = ts
+	}
+}
+
+// WithIndexTemplate names the target index instead of the mapper. The
+// template may contain {topic}, {partition} and {date:LAYOUT}, where LAYOUT
+// is a Go time layout applied to the message time in UTC, for example
+// "logs-{topic}-{date:2006.01.02}".
+func WithIndexTemplate(tmpl string) Option {
+	return func(wp *Pool) {
+		wp.opts.indexTmpl = tmpl
+	}
 }
 
-func NewWorkerPool(b Bulker, m Mapper, in <-chan *kafka.Message, num int) *Pool {
-	return &Pool{bulker: b, mapper: m, inCh: in, num: num}
+// WithDeadLetter sends messages that fail to decode, transform or index to
+// d before acknowledging them.
+func WithDeadLetter(d DeadLetter) Option {
+	return func(wp *Pool) {
+		wp.dlq = d
+	}
+}
+
+func NewWorkerPool(b Bulker, m Mapper, in <-chan *kafka.Message, num int, opts ...Option) *Pool {
+	wp := &Pool{
+		bulker:   b,
+		mapper:   m,
+		inCh:     in,
+		num:      num,
+		dispatch: DispatchShared,
+		dlqSem:   make(chan struct{}, deadLetterConcurrency),
+
+		retryMin:      retryMinDelay,
+		retryMax:      retryMaxDelay,
+		retryAttempts: retryAttempts,
+		opts: output{
+			idStrategy: IDRandom,
+			decoder:    DecoderJSON,
+			action:     indexer.ActionIndex,
+		},
+	}
+	for _, opt := range opts {
+		opt(wp)
+	}
+	out := wp.opts
+	wp.out.Store(&out)
+	return wp
+}
+
+// Reconfigure applies the options that change how documents are built,
+// WithIDStrategy, WithDecoder, WithAction, WithTransforms and
+// WithIndexTemplate, to the messages processed from now on. Other options
+// are ignored.
+func (wp *Pool) Reconfigure(opts ...Option) {
+	next := &Pool{opts: *wp.out.Load()}
+	for _, opt := range opts {
+		opt(next)
+	}
+	wp.out.Store(&next.opts)
 }
 
 func (wp *Pool) Start(ctx context.Context) {
-	for i := 0; i < wp.num; i++ {
-		go wp.run(ctx, i)
+	next := receiveFrom(wp.inCh)
+	if wp.sched != nil {
+		next = wp.sched.Next
+	}
+	wp.retries = make([]*retryQueue, wp.num)
+	for i := range wp.retries {
+		wp.retries[i] = &retryQueue{}
 	}
+	if wp.dispatch == DispatchShared || wp.dispatch == "" {
+		for i := 0; i < wp.num; i++ {
+			wp.wg.Add(1)
+			go wp.run(ctx, i, next)
+		}
+		return
+	}
+
+	lanes := make([]chan *kafka.Message, wp.num)
+	for i := range lanes {
+		lanes[i] = make(chan *kafka.Message, laneBuffer)
+		wp.wg.Add(1)
+		go wp.run(ctx, i, receiveFrom(lanes[i]))
+	}
+	go wp.route(ctx, next, lanes)
 }
 
-func (wp *Pool) run(ctx context.Context, id int) {
-	log.Printf("worker %d started", id)
+// Wait blocks until every worker has returned, after the input channel was
+// closed (or the scheduler closed and drained) or the context was cancelled.
+func (wp *Pool) Wait() {
+	wp.wg.Wait()
+}
+
+// WaitDeadLetters blocks until the messages whose indexing failed have been
+// sent to the dead letter topic. Call it after the bulk indexer was closed
+// and before closing the dead letter writer.
+func (wp *Pool) WaitDeadLetters() {
+	wp.dlqWG.Wait()
+}
+
+// receiveFrom adapts a channel to the signature of Scheduler.Next.
+func receiveFrom(in <-chan *kafka.Message) func(context.Context) (*kafka.Message, bool) {
+	return func(ctx context.Context) (*kafka.Message, bool) {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case msg, ok := <-in:
+			return msg, ok && msg != nil
+		}
+	}
+}
+
+// route forwards every message to the lane of the worker that owns it.
+func (wp *Pool) route(ctx context.Context, next func(context.Context) (*kafka.Message, bool), lanes []chan *kafka.Message) {
+	defer func() {
+		for _, l := range lanes {
+			close(l)
+		}
+	}()
 	for {
+		msg, ok := next(ctx)
+		if !ok {
+			return
+		}
 		select {
+		case lanes[wp.lane(msg)] <- msg:
 		case <-ctx.Done():
-			log.Printf("worker %d shutting down", id)
 			return
-		case msg, ok := <-wp.inCh:
-			if !ok || msg == nil {
-				log.Printf("worker %d input channel closed", id)
+		}
+	}
+}
+
+// lane returns the index of the worker responsible for msg.
+func (wp *Pool) lane(msg *kafka.Message) int {
+	h := fnv.New32a()
+	if wp.dispatch == DispatchKey && len(msg.Key) > 0 {
+		h.Write(msg.Key)
+	} else {
+		h.Write([]byte(msg.Topic))
+		h.Write([]byte(strconv.Itoa(msg.Partition)))
+	}
+	return int(h.Sum32() % uint32(wp.num))
+}
+
+// documentID returns the Elasticsearch _id for msg.
+func (o *output) documentID(msg *kafka.Message) string {
+	switch {
+	case o.idStrategy == IDKey && len(msg.Key) > 0:
+		return string(msg.Key)
+	case o.idStrategy == IDKey, o.idStrategy == IDOffset:
+		return msg.Topic + "-" + strconv.Itoa(msg.Partition) + "-" + strconv.FormatInt(msg.Offset, 10)
+	}
+	return uuid.New().String()
+}
+
+func (wp *Pool) run(ctx context.Context, id int, next func(context.Context) (*kafka.Message, bool)) {
+	defer wp.wg.Done()
+	rq := wp.retries[id]
+	defer func() {
+		if n := rq.stop(); n > 0 {
+			log.Printf("worker %d: %d messages of failed bulk requests left for redelivery", id, n)
+		}
+	}()
+	log.Printf("worker %d started", id)
+	for {
+		if batch := rq.take(); len(batch) > 0 {
+			if !wp.retry(ctx, id, batch) {
 				return
 			}
-			idx := wp.mapper.IndexForTopic(msg.Topic)
-			doc := map[string]interface{}{
-				"payload": json.RawMessage(msg.Value),
-				"key":     string(msg.Key),
-				"ts":      msg.Time,
-				"topic":   msg.Topic,
-			}
-			b, err := json.Marshal(doc)
-			if err != nil {
-				log.Printf("worker %d marshal error: %v", id, err)
-				continue
+			continue
+		}
+		msg, ok, woken := rq.next(ctx, next)
+		if woken {
+			continue
+		}
+		if !ok {
+			if ctx.Err() != nil {
+				log.Printf("worker %d shutting down", id)
+			} else {
+				log.Printf("worker %d input channel closed", id)
 			}
-			docID := uuid.New().String()
-			item := indexer.Item{
-				Index: idx,
-				ID:    docID,
-				Body:  b,
+			return
+		}
+		wp.process(ctx, id, msg)
+		if wp.sched != nil {
+			wp.sched.Done(msg)
+		}
+	}
+}
+
+// index returns the index msg is written to.
+func (o *output) index(msg *kafka.Message, m Mapper) string {
+	if o.indexTmpl != "" {
+		return ExpandIndex(o.indexTmpl, msg)
+	}
+	return m.IndexForTopic(msg.Topic)
+}
+
+// ExpandIndex fills in the placeholders of an index template for msg.
+// Unknown placeholders are kept as they are.
+func ExpandIndex(tmpl string, msg *kafka.Message) string {
+	if !strings.Contains(tmpl, "{") {
+		return tmpl
+	}
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(tmpl, '{')
+		end := strings.IndexByte(tmpl[start+1:], '}')
+		if start < 0 || end < 0 {
+			b.WriteString(tmpl)
+			return b.String()
+		}
+		end += start + 1
+		b.WriteString(tmpl[:start])
+		switch name := tmpl[start+1 : end]; {
+		case name == "topic":
+			b.WriteString(msg.Topic)
+		case name == "partition":
+			b.WriteString(strconv.Itoa(msg.Partition))
+		case strings.HasPrefix(name, "date:"):
+			b.WriteString(msg.Time.UTC().Format(strings.TrimPrefix(name, "date:")))
+		default:
+			b.WriteString(tmpl[start : end+1])
+		}
+		tmpl = tmpl[end+1:]
+	}
+}
+
+// document builds the body sent to Elasticsearch for msg.
+func (o *output) document(msg *kafka.Message) ([]byte, error) {
+	var payload interface{}
+	switch o.decoder {
+	case DecoderString:
+		payload = string(msg.Value)
+	case DecoderBase64:
+		payload = msg.Value
+	default:
+		if len(msg.Value) == 0 {
+			// Tombstones and empty values become a null payload.
+			break
+		}
+		if !json.Valid(msg.Value) {
+			return nil, errors.New("value is not valid JSON")
+		}
+		payload = json.RawMessage(msg.Value)
+		if len(o.transforms) > 0 {
+			// Transforms may reach into the payload, so decode it.
+			d := json.NewDecoder(bytes.NewReader(msg.Value))
+			d.UseNumber()
+			if err := d.Decode(&payload); err != nil {
+				return nil, fmt.Errorf("decode value: %w", err)
 			}
-			if err := wp.bulker.Add(ctx, item); err != nil {
-				log.Printf("worker %d failed to add to bulker: %v", id, err)
+		}
+	}
+	doc := map[string]interface{}{
+		"payload": payload,
+		"key":     string(msg.Key),
+		"ts":      msg.Time,
+		"topic":   msg.Topic,
+	}
+	for _, t := range o.transforms {
+		if err := t.apply(doc); err != nil {
+			return nil, err
+		}
+	}
+	if o.action == indexer.ActionUpdate {
+		return json.Marshal(map[string]interface{}{"doc": doc, "doc_as_upsert": true})
+	}
+	return json.Marshal(doc)
+}
+
+// deadLetter sends msg to the dead letter topic, if there is one, and
+// acknowledges it. A message that cannot be dead-lettered is left
+// unacknowledged so it is consumed again after a restart. Either way msg is
+// released from the flow budget.
+func (wp *Pool) deadLetter(msg *kafka.Message, reason error) {
+	defer msg.Release()
+	if wp.dlq != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), deadLetterTimeout)
+		defer cancel()
+		if err := wp.dlq.Send(ctx, msg, reason); err != nil {
+			log.Printf("dead letter %s/%d@%d: %v", msg.Topic, msg.Partition, msg.Offset, err)
+			return
+		}
+	}
+	msg.Ack()
+}
+
+// Item returns the bulk item msg becomes, without OnDone, so it can be
+// previewed without indexing it.
+func (wp *Pool) Item(msg *kafka.Message) (indexer.Item, error) {
+	o := wp.out.Load()
+	item := indexer.Item{
+		Index:  o.index(msg, wp.mapper),
+		ID:     o.documentID(msg),
+		Action: o.action,
+	}
+	if o.action != indexer.ActionDelete {
+		b, err := o.document(msg)
+		if err != nil {
+			return item, err
+		}
+		item.Body = b
+	}
+	return item, nil
+}
+
+// process indexes one message.
+func (wp *Pool) process(ctx context.Context, id int, msg *kafka.Message) {
+	item, err := wp.Item(msg)
+	if err != nil {
+		log.Printf("worker %d: %s/%d@%d: %v", id, msg.Topic, msg.Partition, msg.Offset, err)
+		wp.deadLetter(msg, err)
+		return
+	}
+	wp.add(ctx, id, msg, item, 0)
+}
+
+// add queues the item of msg with the bulker. Failed documents are
+// acknowledged too, after a detour through the dead letter topic if there is
+// one. Items of a bulk request that failed as a whole, or that Elasticsearch
+// rejected temporarily, go back to the worker, which queues them again
+// before it takes new messages; a message that cannot be queued is left
+// unacknowledged, so it is consumed again after a restart or rebalance.
+//
+// msg keeps counting against the flow budget until its item is done and
+// not retried, so the budget bounds everything the worker holds, including
+// items waiting in the bulk indexer and for a retry.
+func (wp *Pool) add(ctx context.Context, id int, msg *kafka.Message, item indexer.Item, attempt int) {
+	item.OnDone = func(err error) {
+		switch {
+		case indexer.Temporary(err):
+			r := retryItem{msg: msg, item: item, attempt: attempt + 1, err: err}
+			if wp.retries[id].push(r) {
+				return
 			}
+			log.Printf("worker %d: %s/%d@%d: %v; left for redelivery", id, msg.Topic, msg.Partition, msg.Offset, err)
+		case err != nil && wp.dlq != nil:
+			wp.deadLetterAsync(msg, err)
+			return
+		default:
+			msg.Ack()
+		}
+		msg.Release()
+	}
+	if err := wp.bulker.Add(ctx, item); err != nil {
+		log.Printf("worker %d: %s/%d@%d: add to bulker: %v", id, msg.Topic, msg.Partition, msg.Offset, err)
+		msg.Release()
+	}
+}
+
+// deadLetterAsync dead-letters msg without blocking the bulk indexer for
+// longer than it takes to get one of the deadLetterConcurrency slots.
+func (wp *Pool) deadLetterAsync(msg *kafka.Message, reason error) {
+	wp.dlqWG.Add(1)
+	wp.dlqSem <- struct{}{}
+	go func() {
+		defer func() {
+			<-wp.dlqSem
+			wp.dlqWG.Done()
+		}()
+		wp.deadLetter(msg, reason)
+	}()
+}
+
+// retry queues the items of batch again, in order, after the backoff of
+// their attempt. Items out of attempts are dead-lettered, or left
+// unacknowledged without a dead letter topic. It returns false if ctx is
+// done before the backoff has passed.
+func (wp *Pool) retry(ctx context.Context, id int, batch []retryItem) bool {
+	var again []retryItem
+	for _, r := range batch {
+		if r.attempt <= wp.retryAttempts {
+			again = append(again, r)
+			continue
+		}
+		log.Printf("worker %d: %s/%d@%d: giving up after %d attempts: %v", id, r.msg.Topic, r.msg.Partition, r.msg.Offset, r.attempt, r.err)
+		if wp.dlq != nil {
+			wp.deadLetterAsync(r.msg, r.err)
+		} else {
+			r.msg.Release()
 		}
 	}
+	if len(again) == 0 {
+		return true
+	}
+	delay := wp.retryDelay(again[0].attempt)
+	log.Printf("worker %d: retrying %d failed bulk items in %s (attempt %d)", id, len(again), delay, again[0].attempt)
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		for _, r := range again {
+			r.msg.Release()
+		}
+		return false
+	case <-t.C:
+	}
+	for _, r := range again {
+		wp.add(ctx, id, r.msg, r.item, r.attempt)
+	}
+	return true
+}
+
+// retryDelay returns the backoff before the given retry, doubling from
+// retryMin up to retryMax.
+func (wp *Pool) retryDelay(attempt int) time.Duration {
+	d := wp.retryMin
+	for i := 1; i < attempt && d < wp.retryMax; i++ {
+		d *= 2
+	}
+	return min(d, wp.retryMax)
+}
+
+// retryItem is an item that failed temporarily, with the attempt it is
+// queued for next.
+type retryItem struct {
+	msg     *kafka.Message
+	item    indexer.Item
+	attempt int
+	err     error
+}
+
+// retryQueue holds the failed items of one worker. The bulk indexer pushes
+// them; the worker takes them before its next message, so they keep their
+// place in the worker's lane.
+type retryQueue struct {
+	mu      sync.Mutex
+	items   []retryItem
+	wake    context.CancelFunc // ends the worker's wait for a message
+	stopped bool
+}
+
+// push queues r and wakes the worker. It returns false once the worker has
+// stopped.
+func (q *retryQueue) push(r retryItem) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return false
+	}
+	q.items = append(q.items, r)
+	if q.wake != nil {
+		q.wake()
+	}
+	return true
+}
+
+// take removes and returns the queued items.
+func (q *retryQueue) take() []retryItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := q.items
+	q.items = nil
+	return items
+}
+
+// next waits for a message from next like the worker would, but returns
+// woken instead once an item is pushed.
+func (q *retryQueue) next(ctx context.Context, next func(context.Context) (*kafka.Message, bool)) (msg *kafka.Message, ok, woken bool) {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	q.mu.Lock()
+	if len(q.items) > 0 {
+		q.mu.Unlock()
+		return nil, false, true
+	}
+	q.wake = cancel
+	q.mu.Unlock()
+
+	msg, ok = next(wctx)
+
+	q.mu.Lock()
+	q.wake = nil
+	q.mu.Unlock()
+	return msg, ok, !ok && ctx.Err() == nil && wctx.Err() != nil
+}
+
+// stop makes later pushes fail and returns the number of items that were
+// still queued; their messages stay unacknowledged and are released from
+// the flow budget.
+func (q *retryQueue) stop() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stopped = true
+	n := len(q.items)
+	for _, r := range q.items {
+		r.msg.Release()
+	}
+	q.items = nil
+	return n
 }
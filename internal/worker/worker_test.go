@@ -2,8 +2,13 @@ package worker
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -83,6 +88,148 @@ func TestWorkerPoolBulkerError(t *testing.T) {
 	// No panic or deadlock expected
 }
 
+// failingBulker fails the bulk request of the first fail items it receives,
+// or of every item if fail is negative.
+type failingBulker struct {
+	mockBulker
+	fail int
+}
+
+func (f *failingBulker) Add(ctx context.Context, item indexer.Item) error {
+	f.mu.Lock()
+	failed := f.fail < 0 || len(f.items) < f.fail
+	f.mu.Unlock()
+	if err := f.mockBulker.Add(ctx, item); err != nil {
+		return err
+	}
+	if failed {
+		item.OnDone(fmt.Errorf("%w: 503 Service Unavailable", indexer.ErrRequestFailed))
+	}
+	return nil
+}
+
+func (f *failingBulker) ids() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make([]string, len(f.items))
+	for i, it := range f.items {
+		ids[i] = it.ID
+	}
+	return ids
+}
+
+func TestWorkerPoolRetriesInLane(t *testing.T) {
+	bulker := &failingBulker{fail: 1}
+	inCh := make(chan *kafka.Message, 2)
+	wp := NewWorkerPool(bulker, &mockMapper{index: "idx"}, inCh, 1,
+		WithIDStrategy(IDOffset), WithDispatch(DispatchPartition))
+	wp.retryMin = time.Millisecond
+	inCh <- &kafka.Message{Topic: "t", Offset: 1, Value: []byte(`{}`)}
+	inCh <- &kafka.Message{Topic: "t", Offset: 2, Value: []byte(`{}`)}
+	close(inCh)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wp.Start(ctx)
+	wp.Wait()
+	want := []string{"t-0-1", "t-0-1", "t-0-2"}
+	if got := bulker.ids(); !slices.Equal(got, want) {
+		t.Errorf("items added %v, want %v", got, want)
+	}
+}
+
+func TestWorkerPoolDeadLettersAfterRetries(t *testing.T) {
+	bulker := &failingBulker{fail: -1}
+	dlq := &mockDeadLetter{}
+	inCh := make(chan *kafka.Message, 1)
+	wp := NewWorkerPool(bulker, &mockMapper{index: "idx"}, inCh, 1, WithDeadLetter(dlq))
+	wp.retryMin, wp.retryAttempts = time.Millisecond, 2
+	inCh <- &kafka.Message{Topic: "t", Offset: 1, Value: []byte(`{}`)}
+	close(inCh)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wp.Start(ctx)
+	wp.Wait()
+	wp.WaitDeadLetters()
+	if n := len(bulker.ids()); n != 3 {
+		t.Errorf("item added %d times, want 3", n)
+	}
+	if len(dlq.msgs) != 1 {
+		t.Errorf("%d dead letters, want 1", len(dlq.msgs))
+	}
+}
+
+func TestWorkerPoolWakesForRetries(t *testing.T) {
+	bulker := &mockBulker{}
+	inCh := make(chan *kafka.Message)
+	wp := NewWorkerPool(bulker, &mockMapper{index: "idx"}, inCh, 1)
+	wp.retryMin = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wp.Start(ctx)
+	inCh <- &kafka.Message{Topic: "t", Value: []byte(`{}`)}
+	// The request fails while the worker waits for its next message.
+	time.Sleep(10 * time.Millisecond)
+	bulker.mu.Lock()
+	first := bulker.items[0]
+	bulker.mu.Unlock()
+	first.OnDone(indexer.ErrRequestFailed)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		bulker.mu.Lock()
+		n := len(bulker.items)
+		bulker.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("item added %d times, want 2", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(inCh)
+	wp.Wait()
+}
+
+func TestRetryDelay(t *testing.T) {
+	wp := NewWorkerPool(nil, nil, nil, 1)
+	for attempt, want := range map[int]time.Duration{
+		1:  retryMinDelay,
+		2:  2 * retryMinDelay,
+		3:  4 * retryMinDelay,
+		20: retryMaxDelay,
+	} {
+		if got := wp.retryDelay(attempt); got != want {
+			t.Errorf("retryDelay(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
+
+func TestWorkerPoolReconfigure(t *testing.T) {
+	wp := NewWorkerPool(nil, &mockMapper{index: "idx"}, nil, 1, WithIDStrategy(IDOffset), WithDispatch(DispatchKey))
+	msg := &kafka.Message{Topic: "orders", Partition: 1, Offset: 5, Key: []byte("k"), Value: []byte(`{"a":1}`)}
+
+	wp.Reconfigure(
+		WithIDStrategy(IDKey),
+		WithIndexTemplate("{topic}-v2"),
+		WithTransforms(Transform{Op: "remove", Field: "payload.a"}),
+		WithDispatch(DispatchShared),
+	)
+	item, err := wp.Item(msg)
+	if err != nil {
+		t.Fatalf("Item() error = %v", err)
+	}
+	if item.ID != "k" || item.Index != "orders-v2" || strings.Contains(string(item.Body), `"a"`) {
+		t.Errorf("item after Reconfigure = %s %s %s", item.Index, item.ID, item.Body)
+	}
+	if wp.dispatch != DispatchKey {
+		t.Errorf("Reconfigure changed dispatch to %q", wp.dispatch)
+	}
+}
+
 func TestWorkerPoolShutdown(t *testing.T) {
 	bulker := &mockBulker{}
 	mapper := &mockMapper{index: "idx"}
@@ -95,3 +242,228 @@ func TestWorkerPoolShutdown(t *testing.T) {
 	time.Sleep(20 * time.Millisecond)
 	// Should exit cleanly
 }
+
+func TestWorkerPoolKeyDispatchPreservesOrder(t *testing.T) {
+	bulker := &mockBulker{}
+	inCh := make(chan *kafka.Message, 100)
+	wp := NewWorkerPool(bulker, &mockMapper{index: "idx"}, inCh, 4,
+		WithDispatch(DispatchKey), WithIDStrategy(IDKey))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wp.Start(ctx)
+
+	keys := []string{"a", "b", "c", "d", "e"}
+	for i := 0; i < 50; i++ {
+		inCh <- &kafka.Message{
+			Topic: "t",
+			Key:   []byte(keys[i%len(keys)]),
+			Value: []byte(fmt.Sprintf(`{"seq":%d}`, i)),
+		}
+	}
+	close(inCh)
+	time.Sleep(100 * time.Millisecond)
+
+	bulker.mu.Lock()
+	defer bulker.mu.Unlock()
+	if len(bulker.items) != 50 {
+		t.Fatalf("expected 50 items, got %d", len(bulker.items))
+	}
+	last := make(map[string]int)
+	for _, it := range bulker.items {
+		var doc struct {
+			Payload struct{ Seq int } `json:"payload"`
+		}
+		if err := json.Unmarshal(it.Body, &doc); err != nil {
+			t.Fatal(err)
+		}
+		if prev, ok := last[it.ID]; ok && doc.Payload.Seq < prev {
+			t.Errorf("key %s: seq %d indexed after %d", it.ID, doc.Payload.Seq, prev)
+		}
+		last[it.ID] = doc.Payload.Seq
+	}
+	if len(last) != len(keys) {
+		t.Errorf("expected key document IDs, got %v", last)
+	}
+}
+
+func TestWorkerPoolLaneIsStable(t *testing.T) {
+	wp := NewWorkerPool(nil, nil, nil, 8, WithDispatch(DispatchPartition))
+	m := &kafka.Message{Topic: "t", Partition: 3, Key: []byte("x")}
+	lane := wp.lane(m)
+	for i := 0; i < 10; i++ {
+		if wp.lane(&kafka.Message{Topic: "t", Partition: 3, Key: []byte(fmt.Sprint(i))}) != lane {
+			t.Fatal("partition dispatch must ignore the key")
+		}
+	}
+}
+
+func TestDocumentIDStrategies(t *testing.T) {
+	msg := &kafka.Message{Topic: "orders", Partition: 3, Offset: 42, Key: []byte("k1")}
+	noKey := &kafka.Message{Topic: "orders", Partition: 3, Offset: 43}
+
+	offset := NewWorkerPool(nil, nil, nil, 1, WithIDStrategy(IDOffset))
+	if got := offset.out.Load().documentID(msg); got != "orders-3-42" {
+		t.Errorf("offset ID = %q, want orders-3-42", got)
+	}
+	if offset.out.Load().documentID(msg) != offset.out.Load().documentID(msg) {
+		t.Error("offset IDs are not deterministic")
+	}
+
+	key := NewWorkerPool(nil, nil, nil, 1, WithIDStrategy(IDKey))
+	if got := key.out.Load().documentID(msg); got != "k1" {
+		t.Errorf("key ID = %q, want k1", got)
+	}
+	if got := key.out.Load().documentID(noKey); got != "orders-3-43" {
+		t.Errorf("key ID without a key = %q, want the offset ID orders-3-43", got)
+	}
+}
+
+type mockDeadLetter struct {
+	mu   sync.Mutex
+	msgs []*kafka.Message
+}
+
+func (d *mockDeadLetter) Send(ctx context.Context, msg *kafka.Message, reason error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.msgs = append(d.msgs, msg)
+	return nil
+}
+
+// blockingDeadLetter records how many sends run at once until release is
+// closed.
+type blockingDeadLetter struct {
+	release  chan struct{}
+	inFlight atomic.Int64
+	peak     atomic.Int64
+}
+
+func (d *blockingDeadLetter) Send(ctx context.Context, msg *kafka.Message, reason error) error {
+	n := d.inFlight.Add(1)
+	defer d.inFlight.Add(-1)
+	for p := d.peak.Load(); n > p && !d.peak.CompareAndSwap(p, n); p = d.peak.Load() {
+	}
+	<-d.release
+	return nil
+}
+
+// rejectingBulker reports every item as rejected by Elasticsearch.
+type rejectingBulker struct{}
+
+func (rejectingBulker) Add(ctx context.Context, item indexer.Item) error {
+	item.OnDone(errors.New("mapper_parsing_exception: bad field"))
+	return nil
+}
+
+func TestWorkerPoolBoundsDeadLetters(t *testing.T) {
+	dlq := &blockingDeadLetter{release: make(chan struct{})}
+	inCh := make(chan *kafka.Message, 2*deadLetterConcurrency)
+	wp := NewWorkerPool(rejectingBulker{}, &mockMapper{index: "idx"}, inCh, 1, WithDeadLetter(dlq))
+	for i := 0; i < 2*deadLetterConcurrency; i++ {
+		inCh <- &kafka.Message{Topic: "t", Offset: int64(i), Value: []byte(`{}`)}
+	}
+	close(inCh)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wp.Start(ctx)
+	deadline := time.Now().Add(time.Second)
+	for dlq.inFlight.Load() < deadLetterConcurrency && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(dlq.release)
+	wp.Wait()
+	wp.WaitDeadLetters()
+	if p := dlq.peak.Load(); p > deadLetterConcurrency {
+		t.Errorf("%d dead letters in flight, want at most %d", p, deadLetterConcurrency)
+	}
+}
+
+func TestExpandIndex(t *testing.T) {
+	msg := &kafka.Message{Topic: "orders", Partition: 2, Time: time.Date(2024, 3, 9, 23, 0, 0, 0, time.FixedZone("X", -3600))}
+	cases := map[string]string{
+		"static":                         "static",
+		"{topic}":                        "orders",
+		"{topic}-{partition}":            "orders-2",
+		"logs-{topic}-{date:2006.01.02}": "logs-orders-2024.03.10",
+		"{unknown}-{topic":               "{unknown}-{topic",
+	}
+	for tmpl, want := range cases {
+		if got := ExpandIndex(tmpl, msg); got != want {
+			t.Errorf("ExpandIndex(%q) = %q, want %q", tmpl, got, want)
+		}
+	}
+}
+
+func TestWorkerPoolDecodersAndActions(t *testing.T) {
+	msg := &kafka.Message{Topic: "t", Key: []byte("k"), Value: []byte("hi")}
+
+	str := NewWorkerPool(nil, nil, nil, 1, WithDecoder(DecoderString), WithAction(indexer.ActionUpdate))
+	b, err := str.out.Load().document(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var upd struct {
+		Doc struct {
+			Payload string `json:"payload"`
+		} `json:"doc"`
+		Upsert bool `json:"doc_as_upsert"`
+	}
+	if err := json.Unmarshal(b, &upd); err != nil {
+		t.Fatal(err)
+	}
+	if upd.Doc.Payload != "hi" || !upd.Upsert {
+		t.Errorf("unexpected update body %s", b)
+	}
+
+	b64 := NewWorkerPool(nil, nil, nil, 1, WithDecoder(DecoderBase64))
+	if b, err := b64.out.Load().document(msg); err != nil || !strings.Contains(string(b), `"payload":"aGk="`) {
+		t.Errorf("base64 document = %s, %v", b, err)
+	}
+
+	if _, err := NewWorkerPool(nil, nil, nil, 1).out.Load().document(msg); err == nil {
+		t.Error("expected invalid JSON to be rejected")
+	}
+}
+
+func TestWorkerPoolDeadLettersBadMessages(t *testing.T) {
+	bulker := &mockBulker{}
+	dlq := &mockDeadLetter{}
+	inCh := make(chan *kafka.Message, 2)
+	wp := NewWorkerPool(bulker, nil, inCh, 1,
+		WithIndexTemplate("{topic}-x"), WithDeadLetter(dlq))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wp.Start(ctx)
+
+	bad := &kafka.Message{Topic: "t", Offset: 1, Value: []byte("{not json")}
+	inCh <- bad
+	inCh <- &kafka.Message{Topic: "t", Value: []byte(`{}`)}
+	close(inCh)
+	wp.Wait()
+
+	if len(dlq.msgs) != 1 || dlq.msgs[0] != bad {
+		t.Fatalf("expected the bad message in the DLQ, got %v", dlq.msgs)
+	}
+	if len(bulker.items) != 1 || bulker.items[0].Index != "t-x" {
+		t.Errorf("unexpected items %+v", bulker.items)
+	}
+}
+
+func TestWorkerPoolDeleteHasNoBody(t *testing.T) {
+	bulker := &mockBulker{}
+	inCh := make(chan *kafka.Message, 1)
+	wp := NewWorkerPool(bulker, &mockMapper{index: "idx"}, inCh, 1,
+		WithAction(indexer.ActionDelete), WithIDStrategy(IDKey))
+	wp.Start(context.Background())
+	inCh <- &kafka.Message{Topic: "t", Key: []byte("k")}
+	close(inCh)
+	wp.Wait()
+
+	if len(bulker.items) != 1 || bulker.items[0].Body != nil || bulker.items[0].Action != indexer.ActionDelete {
+		t.Errorf("unexpected items %+v", bulker.items)
+	}
+}